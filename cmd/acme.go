@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// acme holds whether or not to obtain a trusted certificate for the quayHostname from an ACME CA such as Let's Encrypt
+var acme bool
+
+// acmeEmail is the contact address of the ACME account
+var acmeEmail string
+
+// acmeServer is the directory URL or acme.sh short name of the ACME CA
+var acmeServer string
+
+// acmeDNSProvider is the acme.sh DNS API used for the DNS-01 challenge, the HTTP-01 challenge is used if empty
+var acmeDNSProvider string
+
+// acmeEnvFile is the path of an env file holding the credentials of the DNS provider
+var acmeEnvFile string
+
+// acmeImage is the acme.sh image run on the target to issue and renew the certificate
+var acmeImage string
+
+// validateACME checks that a certificate can be requested with the install options
+func validateACME() error {
+	if !acme {
+		return nil
+	}
+	hostname := hostOnly(quayHostname)
+	switch {
+	case acmeEmail == "":
+		return errors.New("--acme requires --acmeEmail")
+	case sslCert != "" || sslKey != "":
+		return errors.New("--acme cannot be used with a user-provided certificate (--sslCert/--sslKey)")
+	case enableCertAutorenew:
+		return errors.New("--acme renews its own certificate and cannot be used with --enable-cert-autorenew")
+	case haMode:
+		return errors.New("--acme cannot be used with --ha, pass the certificate of the load balancer hostname with --sslCert and --sslKey")
+	case net.ParseIP(hostname) != nil || !strings.Contains(hostname, "."):
+		return errors.New("--acme requires a public DNS name as quayHostname, not " + hostname)
+	case acmeEnvFile != "" && acmeDNSProvider == "":
+		return errors.New("--acmeEnvFile holds the credentials of --acmeDNSProvider, which is not set")
+	case acmeEnvFile != "" && !pathExists(acmeEnvFile):
+		return errors.New("Could not find --acmeEnvFile " + acmeEnvFile)
+	}
+	return nil
+}
+
+// acmeMountFlag returns the podman flag mounting the DNS provider credentials into the execution environment
+func acmeMountFlag() (string, error) {
+	if !acme || acmeEnvFile == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(acmeEnvFile)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(" -v %s:/runner/certs/acme.env:Z", hostMountPath(abs)), nil
+}
+
+// acmeVars returns the ACME extra-vars of the install playbook
+func acmeVars() string {
+	if !acme {
+		return ""
+	}
+	return fmt.Sprintf(" enable_acme=true acme_email=%s acme_server=%s acme_dns_provider=%s acme_image=%s", acmeEmail, acmeServer, acmeDNSProvider, acmeImage)
+}
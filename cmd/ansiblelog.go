@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ansibleHeader matches the PLAY and TASK banners of the default ansible output
+	ansibleHeader = regexp.MustCompile(`^(PLAY|TASK|RUNNING HANDLER) \[(.*)\] \**$`)
+
+	// ansibleResult matches a per-host task result such as "changed: [host]" or "fatal: [host]: FAILED! => {...}"
+	ansibleResult = regexp.MustCompile(`^(ok|changed|skipping|fatal|failed|unreachable): \[([^\]]+)\](?:: [A-Z!]+)?(?: => (.*))?$`)
+
+	// ansibleRecap matches a host line of the PLAY RECAP
+	ansibleRecap = regexp.MustCompile(`^(\S+)\s+: ((?:\w+=\d+\s*)+)$`)
+
+	// ansiEscape matches the color sequences ansible writes when it runs on a tty without ANSIBLE_NOCOLOR
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+)
+
+// ansibleEvent is a parsed line of ansible-playbook output
+type ansibleEvent struct {
+	Kind   string // play, task, result, recap or output
+	Play   string
+	Task   string
+	Host   string
+	Status string
+	Result string
+	Counts map[string]int
+	Line   string
+}
+
+// ansibleEventWriter turns the line based output of ansible-playbook into events. The playbook is run with
+// ansible-playbook rather than ansible-runner, so the job events of ansible-runner are not available and the default
+// callback output is parsed instead.
+type ansibleEventWriter struct {
+	play    string
+	task    string
+	pending []byte
+	handle  func(ansibleEvent)
+}
+
+// playbookOutput returns where the output of ansible-playbook is written
+func playbookOutput() io.Writer {
+	switch {
+	case logFormat == "json":
+		return &ansibleEventWriter{handle: logAnsibleEvent}
+	case verbose:
+		return os.Stdout
+	default:
+		return &ansibleEventWriter{handle: newPlaybookProgress(os.Stdout).handle}
+	}
+}
+
+// Write parses every complete line and keeps a trailing partial line for the next call
+func (w *ansibleEventWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if event, ok := w.parse(strings.TrimSpace(ansiEscape.ReplaceAllString(string(w.pending[:i]), ""))); ok {
+			w.handle(event)
+		}
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// parse converts a single line of ansible output into an event
+func (w *ansibleEventWriter) parse(line string) (ansibleEvent, bool) {
+	if line == "" {
+		return ansibleEvent{}, false
+	}
+
+	if m := ansibleHeader.FindStringSubmatch(line); m != nil {
+		if m[1] == "PLAY" {
+			w.play, w.task = m[2], ""
+			return ansibleEvent{Kind: "play", Play: w.play}, true
+		}
+		w.task = m[2]
+		return ansibleEvent{Kind: "task", Play: w.play, Task: w.task}, true
+	}
+
+	if m := ansibleResult.FindStringSubmatch(line); m != nil {
+		return ansibleEvent{Kind: "result", Play: w.play, Task: w.task, Host: m[2], Status: m[1], Result: m[3]}, true
+	}
+
+	if strings.HasPrefix(line, "PLAY RECAP") {
+		w.task = ""
+		return ansibleEvent{}, false
+	}
+
+	if m := ansibleRecap.FindStringSubmatch(line); m != nil && w.task == "" {
+		counts := map[string]int{}
+		for _, count := range strings.Fields(m[2]) {
+			kv := strings.SplitN(count, "=", 2)
+			counts[kv[0]], _ = strconv.Atoi(kv[1])
+		}
+		return ansibleEvent{Kind: "recap", Host: m[1], Counts: counts}, true
+	}
+
+	return ansibleEvent{Kind: "output", Play: w.play, Task: w.task, Line: line}, true
+}
+
+// logAnsibleEvent emits an event as a structured log entry
+func logAnsibleEvent(event ansibleEvent) {
+	fields := logrus.Fields{"source": "ansible"}
+	switch event.Kind {
+	case "play":
+		fields["play"] = event.Play
+		log.WithFields(fields).Info("Play started")
+	case "task":
+		fields["play"], fields["task"] = event.Play, event.Task
+		log.WithFields(fields).Debug("Task started")
+	case "result":
+		fields["play"], fields["task"], fields["host"], fields["status"] = event.Play, event.Task, event.Host, event.Status
+		if event.Result != "" {
+			fields["result"] = event.Result
+		}
+		switch event.Status {
+		case "fatal", "failed", "unreachable":
+			log.WithFields(fields).Error("Task failed")
+		case "skipping":
+			log.WithFields(fields).Debug("Task skipped")
+		default:
+			log.WithFields(fields).Info("Task finished")
+		}
+	case "recap":
+		fields["host"] = event.Host
+		for name, count := range event.Counts {
+			fields[name] = count
+		}
+		log.WithFields(fields).Info("Play recap")
+	default:
+		fields["play"], fields["task"] = event.Play, event.Task
+		log.WithFields(fields).Debug(event.Line)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"net/http"
+)
+
+// createAPIToken holds whether or not to create an OAuth access token for API automation after install
+var createAPIToken bool
+
+const (
+	// apiTokenOrganization is the organization owning the automation OAuth application
+	apiTokenOrganization = "automation"
+
+	// apiTokenApplication is the name of the automation OAuth application
+	apiTokenApplication = "mirror-registry-automation"
+)
+
+// apiTokenScopes are the admin scopes granted to the automation token
+var apiTokenScopes = []string{"org:admin", "repo:admin", "repo:create", "repo:read", "repo:write", "super:user", "user:admin", "user:read"}
+
+// ensureAPIApplication creates the automation organization and OAuth application if they do not exist and returns the client ID
+func ensureAPIApplication(api *quayAPIClient) (string, error) {
+	status, err := api.do("GET", "/organization/"+apiTokenOrganization, nil, nil)
+	if status == http.StatusNotFound {
+		log.Infof("Creating organization %s", apiTokenOrganization)
+		if _, err := api.do("POST", "/organization/", map[string]string{"name": apiTokenOrganization}, nil); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	var applications struct {
+		Applications []struct {
+			Name     string `json:"name"`
+			ClientID string `json:"client_id"`
+		} `json:"applications"`
+	}
+	if _, err := api.do("GET", "/organization/"+apiTokenOrganization+"/applications", nil, &applications); err != nil {
+		return "", err
+	}
+	for _, app := range applications.Applications {
+		if app.Name == apiTokenApplication {
+			log.Infof("Found existing OAuth application %s", apiTokenApplication)
+			return app.ClientID, nil
+		}
+	}
+
+	log.Infof("Creating OAuth application %s", apiTokenApplication)
+	var created struct {
+		ClientID string `json:"client_id"`
+	}
+	_, err = api.do("POST", "/organization/"+apiTokenOrganization+"/applications", map[string]string{
+		"name":            apiTokenApplication,
+		"description":     "Created by mirror-registry for API automation",
+		"application_uri": "https://" + api.hostname,
+		"redirect_uri":    "https://" + api.hostname + "/oauth/localapp",
+	}, &created)
+	return created.ClientID, err
+}
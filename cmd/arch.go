@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// targetArch is the CPU architecture of the target, detected over SSH unless set with --arch
+var targetArch string
+
+// targetArchitectures maps the machine names uname reports to the architectures images are published for
+var targetArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// supportedArchitectures lists the architectures of --arch in the messages
+const supportedArchitectures = "amd64, arm64, ppc64le or s390x"
+
+// normalizeArch returns the image architecture of a machine name, or "" if Quay is not published for it
+func normalizeArch(machine string) string {
+	return targetArchitectures[strings.TrimSpace(machine)]
+}
+
+// bundleArch returns the architecture of the execution environment and the images bundled with this binary, which are
+// built for the same architecture as the binary itself
+func bundleArch() string {
+	return runtime.GOARCH
+}
+
+// detectTargetArch validates --arch, or reads the architecture of the target with uname when it is not set
+func detectTargetArch() error {
+	if targetArch != "" {
+		if arch := normalizeArch(targetArch); arch != "" {
+			targetArch = arch
+		} else {
+			return errors.New("Invalid --arch " + targetArch + ", must be " + supportedArchitectures)
+		}
+	} else if haMode {
+		// The hosts of an HA install are expected to match the bundled images
+		targetArch = bundleArch()
+		return nil
+	} else if skipForDryRun("detect the architecture of " + targetHostname) {
+		targetArch = bundleArch()
+		return nil
+	} else {
+		out, err := runRemoteCommand("uname -m\n")
+		if err != nil {
+			return fmt.Errorf("could not detect the architecture of %s: %w", targetHostname, err)
+		}
+		machine := strings.TrimSpace(out)
+		if targetArch = normalizeArch(machine); targetArch == "" {
+			return fmt.Errorf("%s runs on %s, Quay is only available for %s, pass --arch to override", targetHostname, machine, supportedArchitectures)
+		}
+	}
+	log.Infof("Target %s is %s", targetHostname, targetArch)
+
+	// A local install runs the execution environment bundled with this binary on the target itself
+	if isLocalInstall() && targetArch != bundleArch() {
+		return fmt.Errorf("this mirror-registry is built for %s and cannot install on the local %s host, use the %s release", bundleArch(), targetArch, targetArch)
+	}
+	return nil
+}
+
+// defaultImageArchive returns the image archive next to the binary matching the architecture of the target:
+// image-archive-<arch>.tar, or image-archive.tar when the target has the architecture of the bundle. It returns ""
+// when there is no archive, and fails when the only archive is for another architecture.
+func defaultImageArchive() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	dir := path.Dir(executable)
+	if archive := path.Join(dir, "image-archive-"+targetArch+".tar"); pathExists(archive) {
+		return archive, nil
+	}
+	archive := path.Join(dir, "image-archive.tar")
+	if !pathExists(archive) {
+		return "", nil
+	}
+	if targetArch != bundleArch() {
+		return "", fmt.Errorf("The image archive %s holds %s images but %s is %s. Use the %s release of mirror-registry, pass an %s archive with --image-archive or use --online", archive, bundleArch(), targetHostname, targetArch, targetArch, targetArch)
+	}
+	return archive, nil
+}
+
+// archCheck reports whether Quay is available for the machine the target runs on and the bundled images match it
+func archCheck(machine []string) preflightCheck {
+	if len(machine) == 0 {
+		return preflightCheck{Name: "arch", Result: "WARN", Detail: "could not detect the architecture"}
+	}
+	arch := normalizeArch(machine[0])
+	switch {
+	case arch == "":
+		return preflightCheck{Name: "arch", Result: "FAIL", Detail: machine[0] + " is not supported, Quay is only available for " + supportedArchitectures}
+	case arch != bundleArch():
+		return preflightCheck{Name: "arch", Result: "WARN", Detail: fmt.Sprintf("%s, the bundled images are %s, use the %s release or --online", arch, bundleArch(), arch)}
+	}
+	return preflightCheck{Name: "arch", Result: "PASS", Detail: arch}
+}
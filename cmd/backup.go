@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// backupOutput is the path of the backup archive, or a directory to write it to
+var backupOutput string
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the Quay database, storage and config to the local host.",
+	Run: func(cmd *cobra.Command, args []string) {
+		backup()
+	},
+}
+
+func init() {
+
+	// Add backup command
+	rootCmd.AddCommand(backupCmd)
+
+	backupCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	backupCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	backupCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	backupCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	backupCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	backupCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	backupCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder or Podman named volume where quay persistent storage data is saved. This defaults to 'quay-storage'")
+	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", ".", "The path of the backup archive. If a directory is given, a timestamped archive is created in it. This defaults to the current directory")
+}
+
+func backup() {
+
+	log.Printf("Backup has begun")
+
+	err := loadSSHKeys()
+	check(err)
+
+	output := backupOutput
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		output = path.Join(output, fmt.Sprintf("mirror-registry-backup-%s-%s.tar.gz", hostOnly(targetHostname), time.Now().Format("20060102-150405")))
+	}
+	output, err = filepath.Abs(output)
+	check(err)
+
+	// The archive is streamed from the target so it never needs to fit in memory
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	check(err)
+	defer file.Close()
+
+	log.Printf("Backing up database, storage and config of %s to %s. This may take some time.", targetHostname, output)
+	cmd := remoteCommand(backupScript())
+	cmd.Stdout = file
+	log.Debug("Running remote command: ", cmd)
+	if err := cmd.Run(); err != nil {
+		file.Close()
+		os.Remove(output)
+		check(fmt.Errorf("Backup of %s failed: %s", targetHostname, err.Error()))
+	}
+
+	sum, err := sha256File(output)
+	check(err)
+	err = ioutil.WriteFile(output+".sha256", []byte(fmt.Sprintf("%s  %s\n", sum, path.Base(output))), 0644)
+	check(err)
+
+	log.Printf("Backup written to %s (sha256 %s)", output, sum)
+	log.Warn("The backup contains config.yaml with the database password and secret keys, store it securely")
+}
+
+// backupScript dumps the database and packs it with the config bundle and storage into a tar.gz on stdout.
+// Storage owned by the rootless user namespace is read through podman unshare.
+func backupScript() string {
+	return remotePreamble() + `set -o pipefail
+STAGE=$(mktemp -d)
+trap 'rm -rf "$STAGE"' EXIT
+STORAGE=` + quayStorage + `
+if podman volume exists "$STORAGE" 2>/dev/null; then STORAGE_DIR=$(podman volume inspect --format '{{.Mountpoint}}' "$STORAGE"); else STORAGE_DIR=$STORAGE; fi
+if [ "$(id -u)" = 0 ]; then UNSHARE=""; else UNSHARE="podman unshare"; fi
+
+podman exec quay-postgres pg_dump -U postgres quay > "$STAGE/quay.sql"
+cp -a "$(dirname "$CONFIG")" "$STAGE/quay-config"
+$UNSHARE tar -C "$STORAGE_DIR" -cf "$STAGE/storage.tar" .
+for c in quay-app quay-postgres quay-redis quay-clair; do if podman container exists $c; then echo "$c $(podman inspect --format '{{.ImageName}}' $c)"; fi; done > "$STAGE/images"
+
+tar -C "$STAGE" -czf - quay.sql quay-config storage.tar images
+`
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// backupCron is the cron expression of the scheduled backups
+var backupCron string
+
+// backupScheduleStatus holds whether or not to report the scheduled backups instead of installing them
+var backupScheduleStatus bool
+
+// backupScheduleRemove holds whether or not to remove the scheduled backups
+var backupScheduleRemove bool
+
+// backupDestination is the directory on the target the scheduled backups are written to
+var backupDestination string
+
+// backupKeep is the number of scheduled backups kept in the destination
+var backupKeep int
+
+// backupPush is the optional scp destination the scheduled backups are copied to from the target
+var backupPush string
+
+// backupScheduleCmd represents the backup schedule command
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Install a systemd timer on the target that backs up Quay on a schedule.",
+	Run: func(cmd *cobra.Command, args []string) {
+		backupSchedule()
+	},
+}
+
+func init() {
+
+	// Add backup schedule command
+	backupCmd.AddCommand(backupScheduleCmd)
+
+	backupScheduleCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	backupScheduleCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	backupScheduleCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	backupScheduleCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	backupScheduleCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	backupScheduleCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	backupScheduleCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder or Podman named volume where quay persistent storage data is saved. This defaults to 'quay-storage'")
+	backupScheduleCmd.Flags().StringVarP(&backupCron, "cron", "", "", "When to back up, as a cron expression of minute, hour, day of month, month and day of week, e.g. \"0 2 * * *\" for every night at 2:00 in the time zone of the target")
+	backupScheduleCmd.Flags().StringVarP(&backupDestination, "destination", "", "", "The directory on the target the backups are written to. This defaults to <quayRoot>/backups")
+	backupScheduleCmd.Flags().IntVarP(&backupKeep, "keep", "", 7, "The number of backups kept in --destination, older ones are removed. This defaults to 7")
+	backupScheduleCmd.Flags().StringVarP(&backupPush, "push", "", "", "Copy each backup from the target to a remote path with scp, e.g. backup@archive.example.com:/backups/quay. The target needs key based SSH access to it.")
+	backupScheduleCmd.Flags().BoolVarP(&backupScheduleStatus, "status", "", false, "Report the schedule, the last and next run and the latest backup instead of installing the timer")
+	backupScheduleCmd.Flags().BoolVarP(&backupScheduleRemove, "remove", "", false, "Remove the timer. Existing backups are kept.")
+}
+
+func backupSchedule() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	switch {
+	case backupScheduleStatus && backupScheduleRemove:
+		check(errors.New("--status and --remove cannot be combined"))
+	case backupScheduleStatus:
+		backupScheduleReport()
+	case backupScheduleRemove:
+		log.Infof("Removing the scheduled backups from %s", targetHostname)
+		_, err = runRemoteCommand(remotePreamble() + `$SC disable --now quay-backup.timer 2>/dev/null || true
+rm -f "$UNIT_DIR/quay-backup.timer" "$UNIT_DIR/quay-backup.service" ` + quayRoot + `/backup.sh
+$SC daemon-reload
+`)
+		check(err)
+		log.Infof("Scheduled backups removed from %s, existing backups are kept", targetHostname)
+	default:
+		if backupCron == "" {
+			check(errors.New("--cron is required, e.g. --cron \"0 2 * * *\""))
+		}
+		calendar, err := cronToOnCalendar(backupCron)
+		check(err)
+		if backupKeep < 1 {
+			check(errors.New("--keep must be at least 1"))
+		}
+		if backupDestination == "" {
+			backupDestination = quayRoot + "/backups"
+		}
+
+		log.Infof("Scheduling backups of %s at %s to %s", targetHostname, calendar, backupDestination)
+		out, err := runRemoteCommand(backupTimerScript(calendar))
+		check(err)
+		log.Infof("Backups scheduled, the next one runs %s", strings.TrimSpace(out))
+	}
+}
+
+// backupTimerScript installs the backup script and its systemd service and timer on the target and prints the next run
+func backupTimerScript(calendar string) string {
+	return remotePreamble() + `NEXT=$(systemd-analyze calendar '` + calendar + `' 2>&1 | sed -n 's/^ *Next elapse: //p')
+if [ -z "$NEXT" ]; then echo "systemd rejects the schedule ` + calendar + `" >&2; exit 1; fi
+ROOT=$(cd ` + quayRoot + ` && pwd)
+mkdir -p "$UNIT_DIR"
+cat > "$ROOT/backup.sh" <<'MIRROR_REGISTRY_BACKUP'
+#!/bin/bash
+# Installed by mirror-registry backup schedule
+DEST=` + backupDestination + `
+KEEP=` + strconv.Itoa(backupKeep) + `
+PUSH='` + backupPush + `'
+mkdir -p "$DEST"
+OUT="$DEST/mirror-registry-backup-$(hostname -f)-$(date +%Y%m%d-%H%M%S).tar.gz"
+if ! ( ` + backupScript() + ` ) > "$OUT.tmp"; then rm -f "$OUT.tmp"; exit 1; fi
+mv "$OUT.tmp" "$OUT"
+(cd "$DEST" && sha256sum "$(basename "$OUT")" > "$OUT.sha256")
+ls -1t "$DEST"/mirror-registry-backup-*.tar.gz | tail -n +$((KEEP + 1)) | while read -r old; do rm -f "$old" "$old.sha256"; done
+if [ -n "$PUSH" ]; then scp -q -o BatchMode=yes "$OUT" "$OUT.sha256" "$PUSH"; fi
+MIRROR_REGISTRY_BACKUP
+chmod 700 "$ROOT/backup.sh"
+cat > "$UNIT_DIR/quay-backup.service" <<EOF
+[Unit]
+Description=Back up the Quay database, storage and config
+After=quay-app.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash $ROOT/backup.sh
+EOF
+cat > "$UNIT_DIR/quay-backup.timer" <<EOF
+[Unit]
+Description=Scheduled backups of Quay
+
+[Timer]
+OnCalendar=` + calendar + `
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+EOF
+$SC daemon-reload
+$SC enable --now quay-backup.timer >/dev/null
+echo "$NEXT"
+`
+}
+
+// backupScheduleReport prints the schedule, the last and next run and the latest backup of the target
+func backupScheduleReport() {
+	out, err := runRemoteCommand(remotePreamble() + `set +e
+if [ ! -f "$UNIT_DIR/quay-backup.timer" ]; then echo "schedule none"; exit 0; fi
+echo "schedule $(sed -n 's/^OnCalendar=//p' "$UNIT_DIR/quay-backup.timer")"
+echo "state $($SC is-active quay-backup.timer)"
+echo "next $($SC show quay-backup.timer -p NextElapseUSecRealtime --value)"
+echo "last $($SC show quay-backup.service -p ExecMainExitTimestamp --value)"
+echo "result $($SC show quay-backup.service -p Result --value)"
+DEST=$(sed -n 's/^DEST=//p' ` + quayRoot + `/backup.sh)
+echo "destination $DEST"
+echo "latest $(eval ls -1t "$DEST"/mirror-registry-backup-*.tar.gz 2>/dev/null | head -1)"
+`)
+	check(err)
+
+	facts := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if fields := strings.SplitN(line, " ", 2); len(fields) == 2 {
+			facts[fields[0]] = strings.TrimSpace(fields[1])
+		}
+	}
+	if facts["schedule"] == "none" {
+		log.Infof("No backups are scheduled on %s", targetHostname)
+		return
+	}
+	for _, key := range []string{"schedule", "state", "next", "last", "result", "destination", "latest"} {
+		value := facts[key]
+		if value == "" || value == "n/a" {
+			value = "none"
+		}
+		fmt.Printf("%-12s %s\n", key, value)
+	}
+	if facts["result"] != "" && facts["result"] != "success" {
+		log.Warnf("The last scheduled backup of %s failed, see journalctl -u quay-backup.service on the target", targetHostname)
+	}
+}
+
+// cronWeekdays are the systemd names of the cron days of the week, where both 0 and 7 are Sunday
+var cronWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// cronToOnCalendar converts a cron expression to a systemd OnCalendar expression. Steps are expanded to lists, and
+// restricting both the day of month and the day of week is rejected since cron matches either but systemd both.
+func cronToOnCalendar(expression string) (string, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return "", errors.New("Invalid --cron " + expression + ", expected minute, hour, day of month, month and day of week")
+	}
+	if fields[2] != "*" && fields[4] != "*" {
+		return "", errors.New("Invalid --cron " + expression + ", restricting both the day of month and the day of week is not supported")
+	}
+
+	var converted []string
+	for i, limits := range [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}} {
+		values, err := expandCronField(fields[i], limits[0], limits[1])
+		if err != nil {
+			return "", errors.New("Invalid --cron " + expression + ": " + err.Error())
+		}
+		if values == nil {
+			converted = append(converted, "*")
+			continue
+		}
+		var items []string
+		for _, value := range values {
+			if i == 4 {
+				// Sunday is matched as 0 already when the field lists both 0 and 7
+				if value == 7 && values[0] == 0 {
+					continue
+				}
+				items = append(items, cronWeekdays[value])
+			} else {
+				items = append(items, fmt.Sprintf("%02d", value))
+			}
+		}
+		converted = append(converted, strings.Join(items, ","))
+	}
+
+	calendar := fmt.Sprintf("*-%s-%s %s:%s:00", converted[3], converted[2], converted[1], converted[0])
+	if converted[4] != "*" {
+		calendar = converted[4] + " " + calendar
+	}
+	return calendar, nil
+}
+
+// expandCronField returns the values a cron field matches in ascending order, or nil for *
+func expandCronField(field string, min, max int) ([]int, error) {
+	if field == "*" {
+		return nil, nil
+	}
+	matched := map[int]bool{}
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %s", item)
+			}
+			item = item[:i]
+		}
+		first, last := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %s", item)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %s", item)
+				}
+			} else if step > 1 {
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("%s is out of range %d-%d", item, min, max)
+		}
+		for value := first; value <= last; value += step {
+			matched[value] = true
+		}
+	}
+
+	var values []int
+	for value := min; value <= max; value++ {
+		if matched[value] {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newRootCA holds whether or not cert rotate also replaces the root CA of a self-signed certificate
+var newRootCA bool
+
+// remoteCertUpload and remoteKeyUpload are where a user-provided certificate is uploaded to in the home of the target user
+const (
+	remoteCertUpload = ".mirror-registry-ssl.cert"
+	remoteKeyUpload  = ".mirror-registry-ssl.key"
+)
+
+// certCmd represents the cert command
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage the TLS certificate of the registry.",
+}
+
+// certRotateCmd represents the cert rotate command
+var certRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the TLS certificate of the registry and restart Quay, without reinstalling.",
+	Run: func(cmd *cobra.Command, args []string) {
+		rotateCert()
+	},
+}
+
+func init() {
+
+	// Add cert command
+	rootCmd.AddCommand(certCmd)
+	certCmd.AddCommand(certRotateCmd)
+
+	certRotateCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	certRotateCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	certRotateCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	certRotateCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	certRotateCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	certRotateCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, which the certificate must cover. This defaults to <targetHostname>:8443")
+	certRotateCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	certRotateCmd.Flags().StringVarP(&sslCert, "sslCert", "", "", "The path to the new SSL certificate Quay should use. If not set, a self-signed certificate is generated")
+	certRotateCmd.Flags().StringVarP(&sslKey, "sslKey", "", "", "The path to the key of the new SSL certificate")
+	certRotateCmd.Flags().BoolVarP(&sslCheckSkip, "sslCheckSkip", "", false, "Whether or not to check the certificate hostname against the SERVER_HOSTNAME in config.yaml.")
+	certRotateCmd.Flags().BoolVarP(&newRootCA, "newCA", "", false, "Also generate a new root CA for the self-signed certificate. Clients must then trust the new CA")
+}
+
+func rotateCert() {
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+	hostname := hostOnly(quayHostname)
+
+	userProvided := sslCert != "" || sslKey != ""
+	if userProvided && newRootCA {
+		check(errors.New("--newCA only applies to self-signed certificates and cannot be used with --sslCert/--sslKey"))
+	}
+	err := loadCerts(sslCert, sslKey, hostname, sslCheckSkip)
+	check(err)
+
+	err = loadSSHKeys()
+	check(err)
+
+	if userProvided {
+		log.Printf("Uploading %s and %s to %s", sslCert, sslKey, targetHostname)
+		err = copyToRemote(sslCert, remoteCertUpload)
+		check(err)
+		err = copyToRemote(sslKey, remoteKeyUpload)
+		check(err)
+	}
+
+	log.Printf("Replacing the certificate of %s", quayHostname)
+	_, err = runRemoteCommand(certRotateScript(hostname, userProvided))
+	if err == nil {
+		log.Printf("Waiting for Quay to become healthy at https://%s/health/instance", quayHostname)
+		err = waitForQuayHealth(quayHostname, 18, 10*time.Second)
+	}
+	if err != nil {
+		log.Errorf("Certificate rotation failed, rolling back: %s", err.Error())
+		if _, rollbackErr := runRemoteCommand(certRollbackScript()); rollbackErr != nil {
+			log.Errorf("Rollback failed, restore %s/quay-config/ssl.cert.bak and ssl.key.bak manually", quayRoot)
+		}
+		check(err)
+	}
+	_, err = runRemoteCommand(certPreamble() + `rm -f "$QUAY_CONFIG"/ssl.cert.bak "$QUAY_CONFIG"/ssl.key.bak "$QUAY_ROOTCA"/rootCA.pem.bak "$QUAY_ROOTCA"/rootCA.key.bak` + "\n")
+	check(err)
+
+	// Print what clients must trust from now on
+	ca, err := runRemoteCommand(registryCAScript())
+	check(err)
+	log.Printf("Certificate of %s rotated successfully", quayHostname)
+	if userProvided {
+		log.Printf("Clients must trust the issuer of the new certificate chain:")
+	} else if newRootCA {
+		log.Printf("Clients must trust the new root CA, update additionalTrustBundle and /etc/containers/certs.d/%s/ca.crt:", quayHostname)
+	} else {
+		log.Printf("The certificate is signed by the same root CA, clients need no change:")
+	}
+	fmt.Print(ca)
+}
+
+// certPreamble locates the certificate files on the target
+func certPreamble() string {
+	return remotePreamble() + `QUAY_CONFIG=` + quayRoot + `/quay-config
+QUAY_ROOTCA=` + quayRoot + `/quay-rootCA
+`
+}
+
+// registryCAScript prints the root CA if it signed the certificate of the registry, or the certificate chain of a
+// user-provided certificate otherwise
+func registryCAScript() string {
+	return certPreamble() + `if [ -f "$QUAY_ROOTCA/rootCA.pem" ] && openssl verify -CAfile "$QUAY_ROOTCA/rootCA.pem" "$QUAY_CONFIG/ssl.cert" >/dev/null 2>&1; then
+    cat "$QUAY_ROOTCA/rootCA.pem"
+else
+    cat "$QUAY_CONFIG/ssl.cert"
+fi
+`
+}
+
+// certRotateScript backs up the current certificate and installs the uploaded one or a new self-signed one
+func certRotateScript(hostname string, userProvided bool) string {
+	script := certPreamble() + `cp -p "$QUAY_CONFIG/ssl.cert" "$QUAY_CONFIG/ssl.cert.bak"
+cp -p "$QUAY_CONFIG/ssl.key" "$QUAY_CONFIG/ssl.key.bak"
+`
+	if userProvided {
+		script += `mv -f ~/` + remoteCertUpload + ` "$QUAY_CONFIG/ssl.cert"
+mv -f ~/` + remoteKeyUpload + ` "$QUAY_CONFIG/ssl.key"
+# The renewal timers would replace a user-provided certificate
+for timer in quay-cert-renew.timer quay-acme-renew.timer; do
+    if [ -f "$UNIT_DIR/$timer" ]; then $SC disable --now $timer; echo "Disabled $timer"; fi
+done
+`
+	} else {
+		script += `if [ ! -f "$QUAY_CONFIG/openssl.cnf" ]; then
+cat > "$QUAY_CONFIG/openssl.cnf" <<'EOF'
+[req]
+default_bits = 4096
+default_md = sha256
+distinguished_name = req_distinguished_name
+x509_extensions = v3_req
+prompt = no
+[req_distinguished_name]
+C = US
+ST = VA
+L = New York
+O = Quay
+OU = Division
+CN = ` + hostname + `
+[v3_req]
+keyUsage = nonRepudiation, digitalSignature, keyEncipherment, keyCertSign
+extendedKeyUsage = serverAuth
+subjectAltName = @alt_names
+[alt_names]
+` + sanType(hostname) + ` = ` + hostname + `
+EOF
+fi
+mkdir -p "$QUAY_ROOTCA"
+if [ ` + fmt.Sprint(newRootCA) + ` = true ] || [ ! -f "$QUAY_ROOTCA/rootCA.key" ]; then
+    if [ -f "$QUAY_ROOTCA/rootCA.key" ]; then cp -p "$QUAY_ROOTCA/rootCA.key" "$QUAY_ROOTCA/rootCA.key.bak"; cp -p "$QUAY_ROOTCA/rootCA.pem" "$QUAY_ROOTCA/rootCA.pem.bak"; fi
+    openssl genrsa -out "$QUAY_ROOTCA/rootCA.key" 2048
+    openssl req -x509 -new -config "$QUAY_CONFIG/openssl.cnf" -nodes -key "$QUAY_ROOTCA/rootCA.key" -sha256 -days 1024 -out "$QUAY_ROOTCA/rootCA.pem" -addext basicConstraints=critical,CA:TRUE,pathlen:1
+fi
+openssl genrsa -out "$QUAY_CONFIG/ssl.key.new" 2048
+openssl req -new -key "$QUAY_CONFIG/ssl.key.new" -out "$QUAY_CONFIG/ssl.csr" -subj "/CN=quay-enterprise" -config "$QUAY_CONFIG/openssl.cnf"
+openssl x509 -req -in "$QUAY_CONFIG/ssl.csr" -CA "$QUAY_ROOTCA/rootCA.pem" -CAkey "$QUAY_ROOTCA/rootCA.key" -CAcreateserial -out "$QUAY_CONFIG/ssl.cert.new" -days 356 -extensions v3_req -extfile "$QUAY_CONFIG/openssl.cnf"
+cat "$QUAY_ROOTCA/rootCA.pem" >> "$QUAY_CONFIG/ssl.cert.new"
+mv -f "$QUAY_CONFIG/ssl.key.new" "$QUAY_CONFIG/ssl.key"
+mv -f "$QUAY_CONFIG/ssl.cert.new" "$QUAY_CONFIG/ssl.cert"
+`
+	}
+	return script + `chmod u=rw,g=r,o=r "$QUAY_CONFIG/ssl.cert" "$QUAY_CONFIG/ssl.key"
+$SC restart quay-app.service
+`
+}
+
+// certRollbackScript restores the backups taken by certRotateScript
+func certRollbackScript() string {
+	return certPreamble() + `mv -f "$QUAY_CONFIG/ssl.cert.bak" "$QUAY_CONFIG/ssl.cert"
+mv -f "$QUAY_CONFIG/ssl.key.bak" "$QUAY_CONFIG/ssl.key"
+if [ -f "$QUAY_ROOTCA/rootCA.key.bak" ]; then mv -f "$QUAY_ROOTCA/rootCA.key.bak" "$QUAY_ROOTCA/rootCA.key"; mv -f "$QUAY_ROOTCA/rootCA.pem.bak" "$QUAY_ROOTCA/rootCA.pem"; fi
+rm -f ~/` + remoteCertUpload + ` ~/` + remoteKeyUpload + `
+$SC restart quay-app.service
+`
+}
+
+// sanType returns the subjectAltName type of a certificate for the hostname, which may be an IP address
+func sanType(hostname string) string {
+	if net.ParseIP(hostname) != nil {
+		return "IP"
+	}
+	return "DNS"
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roleTemplates is the template directory of the install playbook
+const roleTemplates = "../ansible-runner/context/app/project/roles/mirror_appliance/templates/"
+
+func TestCertRenewReplacesExpiredRootCA(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	root := t.TempDir()
+	for _, dir := range []string{"quay-config", "quay-rootCA", "bin"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Render the templates with the values the playbook sets
+	script := renderTestTemplate(t, "cert-renew.sh.j2", map[string]string{"expanded_quay_root": root, "cert_renew_days_before_expiry": "30"})
+	writeTestFile(t, filepath.Join(root, "cert-renew.sh"), script)
+	writeTestFile(t, filepath.Join(root, "quay-config", "openssl.cnf"), renderTestTemplate(t, "req.j2", map[string]string{"quay_host": "quay.example.com"}))
+	writeTestFile(t, filepath.Join(root, "bin", "systemctl"), "#!/bin/sh\necho \"$@\" > \""+filepath.Join(root, "systemctl.log")+"\"\n")
+	if err := os.Chmod(filepath.Join(root, "bin", "systemctl"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A CA that expired yesterday and a certificate it signed, which is not yet due for renewal
+	caKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "expired root CA"},
+		NotBefore:             time.Now().AddDate(-3, 0, 0),
+		NotAfter:              time.Now().AddDate(0, 0, -1),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "quay.example.com"},
+		DNSNames:     []string{"quay.example.com"},
+		NotBefore:    time.Now().AddDate(-1, 0, 0),
+		NotAfter:     time.Now().AddDate(0, 0, 200),
+	}, caTemplate, &certKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "quay-rootCA", "rootCA.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})))
+	writeTestFile(t, filepath.Join(root, "quay-rootCA", "rootCA.key"), string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey)})))
+	writeTestFile(t, filepath.Join(root, "quay-config", "ssl.cert"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})))
+
+	cmd := exec.Command("bash", filepath.Join(root, "cert-renew.sh"))
+	cmd.Env = append(os.Environ(), "PATH="+filepath.Join(root, "bin")+":"+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("cert-renew.sh failed: %s\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "WARNING: the root CA") {
+		t.Errorf("no warning about the expired root CA:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "quay-rootCA", "rootCA.pem.expired")); err != nil {
+		t.Errorf("the expired root CA is not kept: %s", err)
+	}
+
+	// The renewed certificate must chain to a new root CA that is valid now
+	data, err := ioutil.ReadFile(filepath.Join(root, "quay-rootCA", "rootCA.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		t.Fatal("the new root CA is not a PEM certificate")
+	}
+	data, err = ioutil.ReadFile(filepath.Join(root, "quay-config", "ssl.cert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "quay.example.com"}); err != nil {
+		t.Errorf("the renewed certificate does not verify against the new root CA: %s", err)
+	}
+	if restart, _ := ioutil.ReadFile(filepath.Join(root, "systemctl.log")); !strings.Contains(string(restart), "restart quay-app.service") {
+		t.Errorf("quay-app was not restarted")
+	}
+}
+
+// renderTestTemplate substitutes the given variables of a role template. Conditional expressions such as
+// {{ 'a' if x else 'b' }} render their else value, and the remaining expressions render empty.
+func renderTestTemplate(t *testing.T, name string, vars map[string]string) string {
+	data, err := ioutil.ReadFile(roleTemplates + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditional := regexp.MustCompile(`^'[^']*' if .* else '([^']*)'$`)
+	return regexp.MustCompile(`\{\{(.*?)\}\}`).ReplaceAllStringFunc(string(data), func(expression string) string {
+		expression = strings.TrimSpace(expression[2 : len(expression)-2])
+		if m := conditional.FindStringSubmatch(expression); m != nil {
+			return m[1]
+		}
+		return vars[expression]
+	})
+}
+
+// writeTestFile writes content to file or fails the test
+func writeTestFile(t *testing.T, file, content string) {
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
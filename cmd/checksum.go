@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// These SHA-256 digests of the release archives are set at build time via ldflags, empty for development builds
+var eeArchiveDigest string
+var imageArchiveDigest string
+
+// skipArchiveChecksum holds whether or not to use archives that do not match the digests of the release
+var skipArchiveChecksum bool
+
+// verifyArchive fails when an archive does not match the digest the binary was built with, so a truncated download is
+// reported before podman tries to load it. Archives are not checked when no digest is embedded or with --skipArchiveChecksum.
+func verifyArchive(file, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	if skipArchiveChecksum {
+		log.Warnf("Not verifying the checksum of %s", file)
+		return nil
+	}
+	log.Infof("Verifying the checksum of %s", file)
+	sum, err := sha256File(file)
+	if err != nil {
+		return err
+	}
+	if sum != expected {
+		return fmt.Errorf("%s is a corrupted or mismatched archive: its SHA-256 is %s, this release of mirror-registry expects %s. Download the release again, or pass --skipArchiveChecksum to use a custom archive", file, sum, expected)
+	}
+	return nil
+}
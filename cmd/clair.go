@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"errors"
+)
+
+// withClair holds whether or not to deploy the Clair security scanner alongside Quay
+var withClair bool
+
+// validateClair checks that Clair can be deployed with the requested install options
+func validateClair() error {
+	if !withClair {
+		return nil
+	}
+	if clairImage == "" {
+		return errors.New("--with-clair requires a Clair image, set --clairImage")
+	}
+	// Clair keeps its vulnerability database next to the Quay database in the bundled Postgres
+	if pgHost != "" {
+		return errors.New("--with-clair cannot be used with an external PostgreSQL database (--pgHost)")
+	}
+	return nil
+}
+
+// clairVars returns the Clair extra-vars of the install playbook
+func clairVars() string {
+	if !withClair {
+		return ""
+	}
+	return " enable_clair=true clair_image=" + clairImage
+}
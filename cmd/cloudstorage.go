@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureAccountName is the Azure storage account Quay stores image blobs in
+var azureAccountName string
+
+// azureAccountKey is an access key of azureAccountName
+var azureAccountKey string
+
+// azureContainer is the blob container of azureAccountName Quay stores image blobs in
+var azureContainer string
+
+// gcsBucket is the Google Cloud Storage bucket Quay stores image blobs in
+var gcsBucket string
+
+// gcsAccessKey is the access ID of an HMAC key of Google Cloud Storage
+var gcsAccessKey string
+
+// gcsSecretKey is the secret of gcsAccessKey
+var gcsSecretKey string
+
+// azureAccountKeyEnv and gcsSecretKeyEnv are the environment variables the secrets of the cloud storage backends are
+// read from and passed to the playbook in
+const (
+	azureAccountKeyEnv = "MIRROR_REGISTRY_AZURE_ACCOUNT_KEY"
+	gcsSecretKeyEnv    = "MIRROR_REGISTRY_GCS_SECRET_KEY"
+)
+
+// azureStorageVersion is the Blob service REST API version of the preflight requests
+const azureStorageVersion = "2020-04-08"
+
+// gcsHost is the endpoint of the S3 compatible XML API of Google Cloud Storage
+const gcsHost = "storage.googleapis.com"
+
+// validAzureAccount and validAzureContainer match Azure storage account and blob container names
+var (
+	validAzureAccount   = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+	validAzureContainer = regexp.MustCompile(`^[a-z0-9]([a-z0-9]|-[a-z0-9]){2,62}$`)
+)
+
+// validGCSBucket matches Google Cloud Storage bucket names without dots, which would need domain verification
+var validGCSBucket = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,61}[a-z0-9]$`)
+
+// validateAzureStorage checks the Azure Blob storage flags. The account key can also be passed in
+// $MIRROR_REGISTRY_AZURE_ACCOUNT_KEY.
+func validateAzureStorage() error {
+	if azureAccountKey == "" {
+		azureAccountKey = os.Getenv(azureAccountKeyEnv)
+	}
+	if azureAccountName == "" || azureContainer == "" || azureAccountKey == "" {
+		return errors.New("--storage azure requires --azure-account-name, --azure-container and --azure-account-key")
+	}
+	if !validAzureAccount.MatchString(azureAccountName) {
+		return errors.New("Invalid --azure-account-name " + azureAccountName + ", storage account names are 3 to 24 lowercase letters and digits")
+	}
+	if !validAzureContainer.MatchString(azureContainer) {
+		return errors.New("Invalid --azure-container " + azureContainer + ", container names are 3 to 63 lowercase letters, digits and single hyphens")
+	}
+	if _, err := base64.StdEncoding.DecodeString(azureAccountKey); err != nil {
+		return errors.New("--azure-account-key is not a base64 encoded storage account key")
+	}
+	registerSecret(azureAccountKey)
+	return nil
+}
+
+// validateGCSStorage checks the Google Cloud Storage flags. The secret can also be passed in
+// $MIRROR_REGISTRY_GCS_SECRET_KEY.
+func validateGCSStorage() error {
+	if gcsSecretKey == "" {
+		gcsSecretKey = os.Getenv(gcsSecretKeyEnv)
+	}
+	if gcsBucket == "" || gcsAccessKey == "" || gcsSecretKey == "" {
+		return errors.New("--storage gcs requires --gcs-bucket, --gcs-access-key and --gcs-secret-key")
+	}
+	if !validGCSBucket.MatchString(gcsBucket) {
+		return errors.New("Invalid --gcs-bucket " + gcsBucket + ", bucket names are 3 to 63 lowercase letters, digits, hyphens and underscores")
+	}
+	if strings.ContainsAny(gcsAccessKey, " \"'") {
+		return errors.New("--gcs-access-key may not contain spaces or quotes")
+	}
+	registerSecret(gcsSecretKey)
+	return nil
+}
+
+// checkGCSBucket checks that --gcs-bucket exists and the HMAC key can write to it, through the S3 compatible XML API
+func checkGCSBucket() error {
+	return checkBucketAccess(s3Target{
+		s3Location: s3Location{Host: gcsHost, Port: 443, Secure: true},
+		Bucket:     gcsBucket,
+		AccessKey:  gcsAccessKey,
+		SecretKey:  gcsSecretKey,
+		Region:     "auto",
+	})
+}
+
+// checkAzureContainer writes and deletes a blob below the storage path of Quay to check that the container exists and
+// the account key can write to it
+func checkAzureContainer() error {
+	for _, method := range []string{"PUT", "DELETE"} {
+		var body []byte
+		if method == "PUT" {
+			body = []byte("mirror-registry preflight\n")
+		}
+		if err := azureRequest(method, s3PreflightKey, body); err != nil {
+			return fmt.Errorf("Could not %s a blob in container %s of storage account %s: %s", strings.ToLower(method), azureContainer, azureAccountName, err.Error())
+		}
+	}
+	return nil
+}
+
+// azureRequest sends a request for a blob of --azure-container, signed with the Shared Key of the storage account
+func azureRequest(method, blob string, body []byte) error {
+	resource := "/" + azureContainer + "/" + blob
+	req, err := http.NewRequest(method, "https://"+azureAccountName+".blob.core.windows.net"+resource, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	canonicalHeaders := "x-ms-date:" + req.Header.Get("x-ms-date") + "\nx-ms-version:" + azureStorageVersion
+	contentLength := ""
+	if len(body) > 0 {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		canonicalHeaders = "x-ms-blob-type:BlockBlob\n" + canonicalHeaders
+		contentLength = strconv.Itoa(len(body))
+	}
+
+	// VERB, the standard headers from Content-Encoding to Range, the x-ms- headers and the resource
+	stringToSign := method + "\n\n\n" + contentLength + "\n\n\n\n\n\n\n\n\n" + canonicalHeaders + "\n/" + azureAccountName + resource
+	key, err := base64.StdEncoding.DecodeString(azureAccountKey)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "SharedKey "+azureAccountName+":"+base64.StdEncoding.EncodeToString(hmacSHA256(key, stringToSign)))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	var azureErr s3Error
+	if xml.Unmarshal(data, &azureErr) == nil && azureErr.Code != "" {
+		switch azureErr.Code {
+		case "ContainerNotFound":
+			return errors.New("the container does not exist, create it first")
+		case "AuthenticationFailed":
+			return errors.New("the account key was rejected (" + azureErr.Code + ")")
+		}
+		return fmt.Errorf("%s: %s", azureErr.Code, strings.SplitN(azureErr.Message, "\n", 2)[0])
+	}
+	return errors.New(resp.Status)
+}
+
+// azureStorageVars returns the Azure Blob storage extra-vars of the install playbook, the account key is passed in the
+// environment
+func azureStorageVars() string {
+	return fmt.Sprintf(" azure_account_name=%s azure_container=%s", azureAccountName, azureContainer)
+}
+
+// gcsStorageVars returns the Google Cloud Storage extra-vars of the install playbook, the secret is passed in the
+// environment
+func gcsStorageVars() string {
+	return fmt.Sprintf(" gcs_bucket=%s gcs_access_key=%s", gcsBucket, gcsAccessKey)
+}
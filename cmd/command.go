@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// secretValues are the values masked in every log line, dry-run command and saved playbook output
+var secretValues struct {
+	sync.Mutex
+	values []string
+}
+
+// revealSecrets is the log field of the messages that are meant to show a secret to the user, such as the
+// generated init password
+const revealSecrets = "revealSecrets"
+
+// playbookCommand is a shell command line run by bash together with the secret environment passed to it.
+// Its String form is safe to log, the real values only reach exec.
+type playbookCommand struct {
+	line string
+	env  map[string]string
+}
+
+func init() {
+	log.AddHook(redactHook{})
+}
+
+// newPlaybookCommand creates a command whose env values are secrets
+func newPlaybookCommand(line string, env map[string]string) *playbookCommand {
+	for _, value := range env {
+		registerSecret(value)
+	}
+	return &playbookCommand{line: line, env: env}
+}
+
+// String returns the command line with every secret masked
+func (c *playbookCommand) String() string {
+	return redact(c.line)
+}
+
+// exec returns the command to run with the real values of the secrets in its environment
+func (c *playbookCommand) exec() *exec.Cmd {
+	cmd := exec.Command("bash", "-c", c.line)
+	cmd.Env = os.Environ()
+	for name, value := range c.env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	return cmd
+}
+
+// registerSecret masks values wherever the installer logs or prints them from now on
+func registerSecret(values ...string) {
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	for _, value := range values {
+		if value != "" {
+			secretValues.values = append(secretValues.values, value)
+		}
+	}
+}
+
+// redact replaces every registered secret in s
+func redact(s string) string {
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	for _, value := range secretValues.values {
+		s = strings.ReplaceAll(s, value, "<redacted>")
+	}
+	return s
+}
+
+// redactHook masks secrets in log messages and their string fields before they are formatted
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data[revealSecrets]; ok {
+		delete(entry.Data, revealSecrets)
+		return nil
+	}
+	entry.Message = redact(entry.Message)
+	for key, value := range entry.Data {
+		if s, ok := value.(string); ok {
+			entry.Data[key] = redact(s)
+		}
+	}
+	return nil
+}
+
+// redactWriter masks secrets in the output of a command, which is written a line at a time by a tty
+type redactWriter struct {
+	out io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
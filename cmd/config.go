@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// configExportOutput is the path of the exported config bundle, or a directory to write it to
+var configExportOutput string
+
+// configExportRedact holds whether or not to redact the secrets of config.yaml and leave out the TLS key
+var configExportRedact bool
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the Quay config bundle of the registry.",
+}
+
+// configExportCmd represents the config export command
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Download the live Quay config bundle from the target for inspection or editing in the Quay config tool.",
+	Run: func(cmd *cobra.Command, args []string) {
+		exportConfig()
+	},
+}
+
+func init() {
+
+	// Add config command
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configExportCmd)
+
+	configExportCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	configExportCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	configExportCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	configExportCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	configExportCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	configExportCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	configExportCmd.Flags().StringVarP(&configExportOutput, "output", "o", ".", "The path of the config bundle. If a directory is given, a timestamped bundle is created in it. This defaults to the current directory")
+	configExportCmd.Flags().BoolVarP(&configExportRedact, "redact", "", false, "Replace the passwords, secret keys and tokens in config.yaml with <redacted> and leave out the TLS key, for sharing the config")
+}
+
+func exportConfig() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	output := configExportOutput
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		output = path.Join(output, fmt.Sprintf("mirror-registry-config-%s-%s.tar.gz", hostOnly(targetHostname), time.Now().Format("20060102-150405")))
+	}
+	output, err = filepath.Abs(output)
+	check(err)
+
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	check(err)
+	defer file.Close()
+
+	log.Printf("Exporting the Quay config bundle of %s to %s", targetHostname, output)
+	cmd := remoteCommand(configExportScript())
+	cmd.Stdout = file
+	log.Debug("Running remote command: ", cmd)
+	if err := sshFailure(cmd.Run()); err != nil {
+		file.Close()
+		os.Remove(output)
+		check(fmt.Errorf("Export of the config of %s failed: %w", targetHostname, err))
+	}
+
+	files, err := bundleFiles(output)
+	check(err)
+	for _, name := range files {
+		log.Infof("Exported %s", name)
+	}
+	if configExportRedact {
+		log.Printf("Config bundle written to %s, with secrets redacted", output)
+		return
+	}
+	log.Printf("Config bundle written to %s", output)
+	log.Warn("The bundle contains config.yaml with the database password and secret keys and the TLS key, store it securely")
+}
+
+// configExportScript packs config.yaml, the TLS certificate and key and the extra CA certificates of the install into a
+// tar.gz on stdout, laid out like the bundles the Quay config tool reads and writes
+func configExportScript() string {
+	return remotePreamble() + `if [ ! -f "$CONFIG" ]; then
+    echo "There is no Quay config at $CONFIG, pass the --quayRoot of the install" >&2
+    exit 1
+fi
+STAGE=$(mktemp -d)
+trap 'rm -rf "$STAGE"' EXIT
+CONFIG_DIR=$(dirname "$CONFIG")
+REDACT=` + fmt.Sprint(configExportRedact) + `
+if [ "$REDACT" = true ]; then
+    ` + redactConfigCommand + ` "$CONFIG" > "$STAGE/config.yaml"
+else
+    cp "$CONFIG" "$STAGE/config.yaml"
+fi
+cp "$CONFIG_DIR/ssl.cert" "$STAGE/" 2>/dev/null || true
+if [ "$REDACT" != true ]; then cp "$CONFIG_DIR/ssl.key" "$STAGE/" 2>/dev/null || true; fi
+if [ -d "$CONFIG_DIR/extra_ca_certs" ]; then cp -a "$CONFIG_DIR/extra_ca_certs" "$STAGE/"; fi
+tar -C "$STAGE" -czf - .
+`
+}
+
+// bundleFiles lists the regular files of a tar.gz
+func bundleFiles(archive string) ([]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			names = append(names, path.Clean(header.Name))
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configBundle is the path of the config bundle applied to the target
+var configBundle string
+
+// remoteBundleUpload is where the config bundle is uploaded to in the home of the target user
+const remoteBundleUpload = ".mirror-registry-config.tar.gz"
+
+// requiredConfigKeys are the config.yaml keys Quay cannot start without
+var requiredConfigKeys = []string{"SERVER_HOSTNAME", "DB_URI", "SECRET_KEY", "DATABASE_SECRET_KEY", "DISTRIBUTED_STORAGE_CONFIG", "BUILDLOGS_REDIS", "USER_EVENTS_REDIS"}
+
+// configApplyCmd represents the config apply command
+var configApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Validate a Quay config bundle, push it to the target and restart Quay, rolling back if it does not become healthy.",
+	Run: func(cmd *cobra.Command, args []string) {
+		applyConfig()
+	},
+}
+
+func init() {
+
+	// Add config apply command
+	configCmd.AddCommand(configApplyCmd)
+
+	configApplyCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	configApplyCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	configApplyCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	configApplyCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	configApplyCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	configApplyCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	configApplyCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The hostname and port Quay is reached at for the health check. This defaults to the SERVER_HOSTNAME of the bundle")
+	configApplyCmd.Flags().StringVarP(&configBundle, "bundle", "", "", "The path of the config bundle, a tar.gz with config.yaml and optionally ssl.cert, ssl.key and extra_ca_certs at its root")
+	configApplyCmd.Flags().BoolVarP(&sslCheckSkip, "sslCheckSkip", "", false, "Whether or not to check the certificate hostname against the SERVER_HOSTNAME in config.yaml.")
+	configApplyCmd.Flags().BoolVarP(&skipHealthcheck, "skip-healthcheck", "", false, "Return once Quay is restarted, without waiting for it to report healthy or rolling back.")
+	configApplyCmd.Flags().IntVarP(&healthcheckTimeout, "healthcheckTimeout", "", 300, "The number of seconds to wait for Quay to report healthy before the previous config is restored. This defaults to 300")
+	configApplyCmd.MarkFlagRequired("bundle")
+}
+
+func applyConfig() {
+
+	files, err := readConfigBundle(configBundle)
+	check(err)
+	config, err := validateConfigBundle(files)
+	check(err)
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = fmt.Sprint(config["SERVER_HOSTNAME"])
+	}
+
+	err = loadSSHKeys()
+	check(err)
+
+	// A new DATABASE_SECRET_KEY would leave the encrypted fields of the database unreadable
+	live, err := runRemoteCommand(remotePreamble() + `cat "$CONFIG"` + "\n")
+	check(err)
+	var liveConfig map[string]interface{}
+	if err := yaml.Unmarshal([]byte(live), &liveConfig); err != nil {
+		check(fmt.Errorf("Could not parse the config.yaml on %s: %s", targetHostname, err.Error()))
+	}
+	if liveKey, ok := liveConfig["DATABASE_SECRET_KEY"]; ok && fmt.Sprint(liveKey) != fmt.Sprint(config["DATABASE_SECRET_KEY"]) {
+		check(errors.New("The DATABASE_SECRET_KEY of the bundle differs from the one of " + targetHostname + ", which encrypts fields of its database. Keep the current value"))
+	}
+
+	log.Printf("Uploading %s to %s", configBundle, targetHostname)
+	err = copyToRemote(configBundle, remoteBundleUpload)
+	check(err)
+
+	log.Printf("Applying the config bundle and restarting Quay on %s", targetHostname)
+	_, err = runRemoteCommand(configApplyScript())
+	if err == nil && !skipHealthcheck {
+		err = waitForInstall(quayHostname, "", "", time.Duration(healthcheckTimeout)*time.Second)
+		if err != nil {
+			err = withExitCode(exitHealthcheck, err)
+		}
+	}
+	if err != nil {
+		log.Errorf("Applying the config failed, rolling back: %s", err.Error())
+		if _, rollbackErr := runRemoteCommand(configRollbackScript()); rollbackErr != nil {
+			log.Errorf("Rollback failed, restore %s/quay-config.bak manually", quayRoot)
+		}
+		check(err)
+	}
+	_, err = runRemoteCommand(certPreamble() + `rm -rf "$QUAY_CONFIG.bak"` + "\n")
+	check(err)
+
+	log.Printf("Config bundle %s applied to %s", configBundle, targetHostname)
+}
+
+// readConfigBundle reads the regular files of a config bundle, refusing paths outside of it
+func readConfigBundle(bundle string) (map[string][]byte, error) {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a tar.gz config bundle: %s", bundle, err.Error())
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a tar.gz config bundle: %s", bundle, err.Error())
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("The config bundle %s contains %s, which is outside of it", bundle, header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if files[name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// validateConfigBundle checks that config.yaml parses, has the keys Quay needs with the expected types and no redacted
+// values, and that a TLS certificate in the bundle matches its key and covers the SERVER_HOSTNAME. It returns the
+// parsed config.yaml.
+func validateConfigBundle(files map[string][]byte) (map[string]interface{}, error) {
+	data, ok := files["config.yaml"]
+	if !ok {
+		return nil, errors.New("The config bundle has no config.yaml at its root")
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("config.yaml is not valid YAML: %s", err.Error())
+	}
+	if config == nil {
+		return nil, errors.New("config.yaml is empty")
+	}
+
+	var missing []string
+	for _, key := range requiredConfigKeys {
+		if value, ok := config[key]; !ok || value == nil || value == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.New("config.yaml is missing " + strings.Join(missing, ", "))
+	}
+	for _, key := range []string{"SERVER_HOSTNAME", "DB_URI", "SECRET_KEY", "DATABASE_SECRET_KEY"} {
+		if _, ok := config[key].(string); !ok {
+			return nil, fmt.Errorf("%s in config.yaml must be a string", key)
+		}
+	}
+	for _, key := range []string{"DISTRIBUTED_STORAGE_CONFIG", "BUILDLOGS_REDIS", "USER_EVENTS_REDIS"} {
+		if _, ok := config[key].(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s in config.yaml must be a mapping", key)
+		}
+	}
+	for key, value := range config {
+		if _, ok := value.(bool); strings.HasPrefix(key, "FEATURE_") && !ok {
+			return nil, fmt.Errorf("%s in config.yaml must be true or false", key)
+		}
+	}
+	if scheme, ok := config["PREFERRED_URL_SCHEME"]; ok && scheme != "http" && scheme != "https" {
+		return nil, errors.New("PREFERRED_URL_SCHEME in config.yaml must be http or https")
+	}
+	if redacted := redactedKeys("", config); len(redacted) > 0 {
+		sort.Strings(redacted)
+		return nil, errors.New("config.yaml has redacted values for " + strings.Join(redacted, ", ") + ", export the config without --redact")
+	}
+
+	// The certificate and key replace the current ones together, otherwise Quay fails to start
+	cert, hasCert := files["ssl.cert"]
+	key, hasKey := files["ssl.key"]
+	if hasCert != hasKey {
+		return nil, errors.New("The config bundle must contain both ssl.cert and ssl.key, or neither to keep the current ones")
+	}
+	if hasCert {
+		if err := checkCertificate(cert, key, "ssl.cert", hostOnly(config["SERVER_HOSTNAME"].(string)), sslCheckSkip); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// redactedKeys returns the keys of a config whose values were replaced by config export --redact
+func redactedKeys(prefix string, config map[string]interface{}) []string {
+	var keys []string
+	for key, value := range config {
+		switch value := value.(type) {
+		case string:
+			if value == "<redacted>" {
+				keys = append(keys, prefix+key)
+			}
+		case map[string]interface{}:
+			keys = append(keys, redactedKeys(prefix+key+".", value)...)
+		}
+	}
+	return keys
+}
+
+// configApplyScript backs up the live config, unpacks the uploaded bundle over it and restarts Quay. The extra CA
+// certificates of a bundle replace the current ones, so that certificates removed from the bundle are removed too.
+func configApplyScript() string {
+	return certPreamble() + `BUNDLE=~/` + remoteBundleUpload + `
+trap 'rm -f "$BUNDLE"' EXIT
+rm -rf "$QUAY_CONFIG.bak"
+cp -a "$QUAY_CONFIG" "$QUAY_CONFIG.bak"
+if tar -tzf "$BUNDLE" | grep -q '^\(\./\)\?extra_ca_certs/'; then rm -rf "$QUAY_CONFIG/extra_ca_certs"; fi
+tar -C "$QUAY_CONFIG" --no-same-owner -xzf "$BUNDLE"
+if [ -f "$QUAY_CONFIG/ssl.cert" ]; then chmod u=rw,g=r,o=r "$QUAY_CONFIG/ssl.cert" "$QUAY_CONFIG/ssl.key"; fi
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+$SC restart quay-app.service
+if [ -f "$UNIT_DIR/quay-mirror.service" ]; then $SC restart quay-mirror.service; fi
+`
+}
+
+// configRollbackScript restores the config backed up by configApplyScript
+func configRollbackScript() string {
+	return certPreamble() + `if [ -d "$QUAY_CONFIG.bak" ]; then rm -rf "$QUAY_CONFIG"; mv "$QUAY_CONFIG.bak" "$QUAY_CONFIG"; fi
+rm -f ~/` + remoteBundleUpload + `
+$SC restart quay-app.service
+if [ -f "$UNIT_DIR/quay-mirror.service" ]; then $SC restart quay-mirror.service; fi
+`
+}
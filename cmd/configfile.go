@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// installConfigFile is the path of a YAML file declaring install flags
+var installConfigFile string
+
+// applyConfigFile sets the flags declared in a YAML config file. Keys are flag names and flags given
+// on the command line take precedence over the file.
+func applyConfigFile(flags *pflag.FlagSet, file string) error {
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		return errors.New("TOML config files are not supported, use YAML")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("Could not parse config file %s: %s", file, err.Error())
+	}
+
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil || key == "config" {
+			return fmt.Errorf("Unknown option %s in config file %s", key, file)
+		}
+		if flag.Changed {
+			log.Debugf("Option %s from config file is overridden on the command line", key)
+			continue
+		}
+
+		// Lists are only accepted for repeatable flags such as orgQuota
+		items, isList := values[key].([]interface{})
+		if !isList {
+			items = []interface{}{values[key]}
+		} else if !strings.HasSuffix(flag.Value.Type(), "Array") && !strings.HasSuffix(flag.Value.Type(), "Slice") {
+			return fmt.Errorf("Option %s in config file %s does not accept a list", key, file)
+		}
+		for _, item := range items {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}, nil:
+				return fmt.Errorf("Option %s in config file %s must be a string, number or boolean", key, file)
+			}
+			if err := flags.Set(key, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("Invalid value for option %s in config file %s: %s", key, file, err.Error())
+			}
+		}
+	}
+	log.Infof("Loaded install options from %s", file)
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// credentialsFile returns the path of the local credentials file kept for a target host
+func credentialsFile(host string) string {
+	return path.Join(os.Getenv("HOME"), ".mirror-registry", "credentials", hostOnly(host)+".json")
+}
+
+// loadCredentials reads the credentials stored for a target host
+func loadCredentials(host string) (map[string]string, error) {
+	credentials := map[string]string{}
+	data, err := ioutil.ReadFile(credentialsFile(host))
+	if os.IsNotExist(err) {
+		return credentials, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// saveCredentials merges values into the credentials file of a target host and returns its path.
+// The file is only readable by the current user.
+func saveCredentials(host string, values map[string]string) (string, error) {
+	credentials, err := loadCredentials(host)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range values {
+		credentials[k] = v
+	}
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	file := credentialsFile(host)
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, data, 0600)
+}
+
+// targetCredentialsFile returns the path of the init credentials kept on the target, only readable by the user that owns
+// the install
+func targetCredentialsFile() string {
+	return quayRoot + "/init-credentials.json"
+}
+
+// saveTargetCredentials writes the init credentials and the registry URL to the target, so they can be retrieved from
+// another control host. They are passed on stdin to keep them out of the process list of the target.
+func saveTargetCredentials(values map[string]string) error {
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = runRemoteCommand(`set -e
+umask 077
+cat > ` + targetCredentialsFile() + `.tmp <<'MIRROR_REGISTRY_CREDENTIALS'
+` + string(data) + `
+MIRROR_REGISTRY_CREDENTIALS
+chmod 600 ` + targetCredentialsFile() + `.tmp
+mv ` + targetCredentialsFile() + `.tmp ` + targetCredentialsFile() + `
+`)
+	return err
+}
+
+// loadTargetCredentials reads the init credentials kept on the target, or returns none if it has no copy
+func loadTargetCredentials() (map[string]string, error) {
+	out, err := runRemoteCommand(`if [ -f ` + targetCredentialsFile() + ` ]; then cat ` + targetCredentialsFile() + `; fi
+`)
+	if err != nil {
+		return nil, err
+	}
+	credentials := map[string]string{}
+	if strings.TrimSpace(out) == "" {
+		return credentials, nil
+	}
+	if err := json.Unmarshal([]byte(out), &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// deployedImage is the image a component runs with, as recorded in the install state of the target
+type deployedImage struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+// componentContainers maps the components whose images are recorded to their containers on the target
+var componentContainers = map[string]string{
+	"quay":     "quay-app",
+	"redis":    "quay-redis",
+	"postgres": "quay-postgres",
+	"clair":    "quay-clair",
+}
+
+// resolveImageDigest returns the manifest digest of an image in its registry, read with skopeo on the control host
+func resolveImageDigest(reference string) (string, error) {
+	if _, err := exec.LookPath("skopeo"); err != nil {
+		return "", fmt.Errorf("skopeo is not installed on the control host")
+	}
+	args := []string{"inspect", "--format", "{{.Digest}}"}
+	if pullSecret != "" {
+		args = append(args, "--authfile", pullSecret)
+	}
+	// Resolve multi-architecture images to the manifest the target pulls
+	if targetArch != "" {
+		args = append(args, "--override-arch", targetArch)
+	}
+	out, err := exec.Command("skopeo", append(args, "docker://"+reference)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	digest := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("unexpected digest %q", digest)
+	}
+	return digest, nil
+}
+
+// pinnedReference replaces the tag or digest of reference with digest
+func pinnedReference(reference, digest string) string {
+	name := reference
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + "@" + digest
+}
+
+// pinImages resolves the images the target pulls to digests and points the image variables at them, so the playbook
+// deploys exactly the resolved images even if a tag moves. Images loaded from the image archive are imported under
+// their tag and keep it. It returns the deployed images of the components.
+func pinImages() map[string]deployedImage {
+	images := map[string]*string{"quay": &quayImage, "redis": &redisImage, "postgres": &postgresImage, "clair": &clairImage}
+	names := []string{"quay"}
+	if redisHost == "" {
+		names = append(names, "redis")
+	}
+	if pgHost == "" {
+		names = append(names, "postgres")
+	}
+	if withClair {
+		names = append(names, "clair")
+	}
+	deployed := map[string]deployedImage{}
+	for _, name := range names {
+		reference := images[name]
+		image := deployedImage{Reference: *reference}
+		pulled := name == "clair" || imageArchivePath == "" || !loadsFromArchive(name)
+		if pulled && !strings.Contains(*reference, "@") && !skipForDryRun("resolve "+*reference+" to a digest") {
+			digest, err := resolveImageDigest(*reference)
+			if err != nil {
+				log.Warnf("Could not resolve %s to a digest, deploying it by tag: %s", *reference, err.Error())
+			} else {
+				log.Infof("Pinning %s to %s", *reference, digest)
+				image.Digest = digest
+				*reference = pinnedReference(*reference, digest)
+			}
+		}
+		deployed[name] = image
+	}
+	return deployed
+}
+
+// runningImageIDs returns the image IDs of the component containers running on the target
+func runningImageIDs() (map[string]string, error) {
+	var script strings.Builder
+	script.WriteString("set +e\n")
+	for name, container := range componentContainers {
+		fmt.Fprintf(&script, "echo \"%s $(podman inspect --format '{{.Image}}' %s 2>/dev/null)\"\n", name, container)
+	}
+	out, err := runRemoteCommand(script.String())
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			ids[fields[0]] = fields[1]
+		}
+	}
+	return ids, nil
+}
+
+// recordImageIDs completes the deployed images with the IDs of the images the containers run
+func recordImageIDs(deployed map[string]deployedImage) error {
+	ids, err := runningImageIDs()
+	if err != nil {
+		return err
+	}
+	for name, image := range deployed {
+		image.ID = ids[name]
+		deployed[name] = image
+	}
+	return nil
+}
+
+// imageDrift returns a description of each component running another image than the one recorded for it
+func imageDrift(recorded map[string]deployedImage, ids map[string]string) map[string]string {
+	drift := map[string]string{}
+	for name, image := range recorded {
+		if image.ID == "" || ids[name] == "" || ids[name] == image.ID {
+			continue
+		}
+		drift[name] = fmt.Sprintf("%s runs image %s, but %s was deployed with %s", componentContainers[name], shortID(ids[name]), image.Reference, shortID(image.ID))
+	}
+	return drift
+}
+
+// warnImageDrift logs the recorded images and warns about the components running another image since
+func warnImageDrift(recorded map[string]deployedImage) error {
+	ids, err := runningImageIDs()
+	if err != nil {
+		return err
+	}
+	drift := imageDrift(recorded, ids)
+	for _, name := range []string{"quay", "redis", "postgres", "clair"} {
+		image, ok := recorded[name]
+		if !ok {
+			continue
+		}
+		if description, drifted := drift[name]; drifted {
+			log.Warnf("%s, it was changed outside of mirror-registry", description)
+		} else if image.Digest != "" {
+			log.Infof("%s runs %s@%s as recorded", componentContainers[name], image.Reference, image.Digest)
+		} else {
+			log.Infof("%s runs %s as recorded", componentContainers[name], image.Reference)
+		}
+	}
+	return nil
+}
+
+// shortID abbreviates an image ID the way podman prints it
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// diskHeadroomKiB is the free space kept on top of the size of an archive, for podman metadata and layer decompression
+const diskHeadroomKiB = 512 * 1024
+
+// diskNeed is the free space a step of the install needs in a directory
+type diskNeed struct {
+	dir  string
+	what string
+	kib  int64
+}
+
+// fileKiB returns the size of a file in KiB
+func fileKiB(file string) (int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size()/1024 + 1, nil
+}
+
+// localFreeKiB returns the space available to unprivileged users on the filesystem of dir or of its nearest existing parent
+func localFreeKiB(dir string) (int64, error) {
+	for !pathExists(dir) && dir != filepath.Dir(dir) {
+		dir = filepath.Dir(dir)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize) / 1024, nil
+}
+
+// localGraphRoot returns the directory local podman stores images in
+func localGraphRoot() (string, error) {
+	out, err := exec.Command("podman", "info", "--format", "{{.Store.GraphRoot}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the podman image storage: %s", err.Error())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkLocalDiskSpace fails with the path, required and available space of the first need the control host cannot meet.
+// Needs on the same filesystem are added up.
+func checkLocalDiskSpace(needs []diskNeed) error {
+	required := map[uint64]int64{}
+	for _, need := range needs {
+		var stat syscall.Stat_t
+		dir := need.dir
+		for !pathExists(dir) && dir != filepath.Dir(dir) {
+			dir = filepath.Dir(dir)
+		}
+		if err := syscall.Stat(dir, &stat); err != nil {
+			return err
+		}
+		required[uint64(stat.Dev)] += need.kib
+		free, err := localFreeKiB(dir)
+		if err != nil {
+			return err
+		}
+		log.Debugf("%s needs %d KiB in %s, %d KiB free", need.what, need.kib, need.dir, free)
+		if free < required[uint64(stat.Dev)] {
+			return fmt.Errorf("Not enough disk space on the control host to %s: %s has %s free, %s is required", need.what, need.dir, formatKiB(free), formatKiB(required[uint64(stat.Dev)]))
+		}
+	}
+	return nil
+}
+
+// checkTargetDiskSpace checks that quayRoot can hold the copied and unpacked image archive and that podman on the
+// target can store the loaded images
+func checkTargetDiskSpace(archiveKiB int64) error {
+	out, err := runRemoteCommand(`avail() { d=$1; while [ ! -d "$d" ]; do d=$(dirname "$d"); done; echo "$(df -Pk "$d" | awk 'NR==2 {print $4}') $(stat -c %d "$d") $1"; }
+avail ` + quayRoot + `
+avail "$(podman info --format '{{.Store.GraphRoot}}' 2>/dev/null || echo /var/lib/containers/storage)"
+`)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		return fmt.Errorf("could not measure free disk space on %s: %s", targetHostname, out)
+	}
+
+	// The archive is copied to quayRoot and unpacked next to itself, then imported into podman storage
+	needs := []struct {
+		what string
+		kib  int64
+	}{
+		{"copy and unpack the image archive", 2*archiveKiB + diskHeadroomKiB},
+		{"load the images into podman storage", archiveKiB + diskHeadroomKiB},
+	}
+	required := map[string]int64{}
+	for i, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("could not measure free disk space on %s: %s", targetHostname, line)
+		}
+		free, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("could not measure free disk space on %s: %s", targetHostname, line)
+		}
+		required[fields[1]] += needs[i].kib
+		if free < required[fields[1]] {
+			return fmt.Errorf("Not enough disk space on %s to %s: %s has %s free, %s is required", targetHostname, needs[i].what, fields[2], formatKiB(free), formatKiB(required[fields[1]]))
+		}
+	}
+	return nil
+}
+
+// formatKiB formats a size in KiB for humans
+func formatKiB(kib int64) string {
+	if kib >= 1024*1024 {
+		return fmt.Sprintf("%.1f GiB", float64(kib)/1024/1024)
+	}
+	return fmt.Sprintf("%d MiB", kib/1024)
+}
+
+// checkImageArchiveDiskSpace checks the space needed to unpack and load the image archive, on the control host for a
+// local install and on the target otherwise
+func checkImageArchiveDiskSpace(archive string) error {
+	archiveKiB, err := fileKiB(archive)
+	if err != nil {
+		return err
+	}
+	if !isLocalInstall() {
+		return checkTargetDiskSpace(archiveKiB)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	needs := []diskNeed{{dir: cwd, what: "unpack the image archive", kib: archiveKiB}}
+	if graphRoot, err := localGraphRoot(); err == nil {
+		needs = append(needs, diskNeed{dir: graphRoot, what: "load the images into podman storage", kib: archiveKiB + diskHeadroomKiB})
+	}
+	return checkLocalDiskSpace(needs)
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// dryRun holds whether or not to only print the plan without loading the execution environment or touching the target
+var dryRun bool
+
+// skipForDryRun prints a step of the plan and reports whether it must be skipped because of --dry-run
+func skipForDryRun(step string) bool {
+	if dryRun {
+		fmt.Println("[dry-run] Would " + step)
+	}
+	return dryRun
+}
+
+// printDryRunCommand prints the playbook command of the plan with the registered secrets masked
+func printDryRunCommand(command *playbookCommand) {
+	fmt.Println("[dry-run] Would run: " + command.String())
+}
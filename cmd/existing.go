@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// resetInitPassword holds whether or not to reset the password of an existing init user
+var resetInitPassword bool
+
+// existingInstall describes what a previous install left on the target
+type existingInstall struct {
+	Config   bool `json:"config" yaml:"config"`
+	InitUser bool `json:"initUser" yaml:"initUser"`
+}
+
+// detectExistingInstall checks the target for the config.yaml and the init user of a previous install
+func detectExistingInstall() (existingInstall, error) {
+	var existing existingInstall
+	if !validNamespace.MatchString(initUser) {
+		return existing, errors.New("Invalid --initUser " + initUser)
+	}
+
+	// The init user of an external database is looked up directly, the config is still on the target
+	initUserQuery := `if podman exec quay-postgres psql -d quay -U postgres -tAc "SELECT 1 FROM \"user\" WHERE username = '` + initUser + `'" 2>/dev/null | grep -q 1; then echo init-user; fi`
+	if pgHost != "" {
+		db, err := openExternalPostgres()
+		if err != nil {
+			return existing, err
+		}
+		defer db.Close()
+		var users int
+		if err := db.QueryRow(`SELECT count(*) FROM pg_tables WHERE tablename = 'user'`).Scan(&users); err != nil {
+			return existing, err
+		}
+		if users > 0 {
+			if err := db.QueryRow(`SELECT count(*) FROM "user" WHERE username = $1`, initUser).Scan(&users); err != nil {
+				return existing, err
+			}
+			existing.InitUser = users > 0
+		}
+		initUserQuery = ""
+	}
+	out, err := runRemoteCommand(remotePreamble() + `if [ -f "$CONFIG" ]; then echo config; fi
+` + initUserQuery + "\n")
+	if err != nil {
+		return existing, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		switch strings.TrimSpace(line) {
+		case "config":
+			existing.Config = true
+		case "init-user":
+			existing.InitUser = true
+		}
+	}
+	return existing, nil
+}
+
+// setUserPassword changes the password of a user through the superuser API
+func setUserPassword(api *quayAPIClient, username, password string) error {
+	status, err := api.do("PUT", "/superuser/users/"+username, map[string]string{"password": password}, nil)
+	if status == http.StatusNotFound {
+		return errors.New("user " + username + " does not exist")
+	}
+	return err
+}
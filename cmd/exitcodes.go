@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// These exit codes tell wrapper automation why a command failed, any other error exits with 1
+const (
+	exitFailure     = 1
+	exitPreflight   = 2
+	exitSSH         = 3
+	exitPodman      = 4
+	exitPlaybook    = 5
+	exitHealthcheck = 6
+)
+
+// exitCodesHelp documents the exit codes in the help of every command
+const exitCodesHelp = `Exit codes:
+  0  success
+  1  any other failure, e.g. invalid flags
+  2  preflight failure: the target does not meet the requirements, e.g. its podman, ports, disk space or FIPS mode
+  3  SSH failure: the SSH key or password is missing or the target cannot be reached
+  4  podman failure: the container engine of the control host cannot load or run the execution environment or images
+  5  playbook failure: the ansible playbook failed, see the playbook log
+  6  health check failure: Quay did not serve requests after the playbook, or status found it unhealthy`
+
+func init() {
+	// The usage template is inherited by every command
+	rootCmd.SetUsageTemplate(rootCmd.UsageTemplate() + "\n" + exitCodesHelp + "\n")
+}
+
+// exitCodeError is an error that makes check exit with a code of its failure class
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode classifies err as a failure exiting with code, nil stays nil
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// exitCode returns the exit code of the failure class of err
+func exitCode(err error) int {
+	var classified *exitCodeError
+	if errors.As(err, &classified) {
+		return classified.code
+	}
+	return exitFailure
+}
+
+// sshFailure classifies the error of an ssh or scp command as an SSH failure when the connection failed, which both
+// report with exit status 255. Errors of the remote script keep their own status.
+func sshFailure(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+		return withExitCode(exitSSH, err)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// exportOutput is the path of the image archive written by export-images
+var exportOutput string
+
+// exportImagesCmd represents the export-images command
+var exportImagesCmd = &cobra.Command{
+	Use:   "export-images",
+	Short: "Create an image archive from the images in local podman storage.",
+	Run: func(cmd *cobra.Command, args []string) {
+		exportImages()
+	},
+}
+
+func init() {
+
+	// Add export-images command
+	rootCmd.AddCommand(exportImagesCmd)
+
+	exportImagesCmd.Flags().StringVarP(&exportOutput, "output", "o", "image-archive.tar", "The path of the image archive to create. This defaults to image-archive.tar")
+	exportImagesCmd.Flags().StringVarP(&quayImage, "quayImage", "", quayImage, "The Quay image to export")
+	exportImagesCmd.Flags().StringVarP(&redisImage, "redisImage", "", redisImage, "The Redis image to export")
+	exportImagesCmd.Flags().StringVarP(&postgresImage, "postgresImage", "", postgresImage, "The Postgres image to export")
+	exportImagesCmd.Flags().StringVarP(&pauseImage, "pauseImage", "", pauseImage, "The pause image to export")
+}
+
+func exportImages() {
+
+	log.Printf("Image export has begun")
+
+	// The archive members must match the names the installer unpacks
+	images := []struct {
+		file      string
+		reference string
+	}{
+		{"quay.tar", quayImage},
+		{"redis.tar", redisImage},
+		{"postgres.tar", postgresImage},
+		{"pause.tar", pauseImage},
+	}
+
+	// Fail early with every image that is missing
+	var missing []string
+	for _, image := range images {
+		if err := exec.Command("podman", "image", "exists", image.reference).Run(); err != nil {
+			missing = append(missing, image.reference)
+		}
+	}
+	if len(missing) > 0 {
+		check(errors.New("The following images are not present in local podman storage: " + strings.Join(missing, ", ")))
+	}
+
+	workDir, err := ioutil.TempDir("", "mirror-registry-export")
+	check(err)
+	defer os.RemoveAll(workDir)
+
+	var members []string
+	for _, image := range images {
+		log.Printf("Exporting %s to %s", image.reference, image.file)
+		err = exportImageFilesystem(image.reference, path.Join(workDir, image.file))
+		check(err)
+		members = append(members, image.file)
+	}
+
+	outputAbs, err := filepath.Abs(exportOutput)
+	check(err)
+	log.Printf("Writing image archive %s", outputAbs)
+	cmd := exec.Command("tar", append([]string{"-cf", outputAbs, "-C", workDir}, members...)...)
+	if verbose {
+		cmd.Stderr = os.Stderr
+		cmd.Stdout = os.Stdout
+	}
+	err = cmd.Run()
+	check(err)
+
+	sum, err := sha256File(outputAbs)
+	check(err)
+	err = ioutil.WriteFile(outputAbs+".sha256", []byte(fmt.Sprintf("%s  %s\n", sum, path.Base(outputAbs))), 0644)
+	check(err)
+
+	log.Printf("Image archive written to %s (sha256 %s)", outputAbs, sum)
+}
+
+// exportImageFilesystem flattens an image into a filesystem tarball, the format the installer imports
+func exportImageFilesystem(reference, dest string) error {
+	name := "mirror-registry-export-" + strings.TrimSuffix(path.Base(dest), ".tar")
+	create := exec.Command("podman", "create", "--name", name, reference)
+	if verbose {
+		create.Stderr = os.Stderr
+	}
+	if err := create.Run(); err != nil {
+		return err
+	}
+	defer exec.Command("podman", "rm", "--force", name).Run()
+
+	export := exec.Command("podman", "export", "--output", dest, name)
+	if verbose {
+		export.Stderr = os.Stderr
+		export.Stdout = os.Stdout
+	}
+	log.Debug("Exporting image with command: ", export)
+	return export.Run()
+}
+
+// sha256File returns the hex encoded SHA-256 digest of a file
+func sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+// pgHost is the host of an existing PostgreSQL server to use instead of the bundled one
+var pgHost string
+
+// pgPort is the port of the external PostgreSQL server
+var pgPort int
+
+// pgUser is the user Quay connects to the external PostgreSQL server with
+var pgUser string
+
+// pgPassword is the password of pgUser
+var pgPassword string
+
+// pgDatabase is the database Quay uses on the external PostgreSQL server
+var pgDatabase string
+
+// pgPasswordEnv is the environment variable holding the external PostgreSQL password
+const pgPasswordEnv = "MIRROR_REGISTRY_PG_PASSWORD"
+
+// validateExternalPostgres checks the external PostgreSQL flags and that the database is reachable and has pg_trgm installed
+func validateExternalPostgres() error {
+	if pgPassword == "" {
+		pgPassword = os.Getenv(pgPasswordEnv)
+	}
+	if pgHost == "" {
+		if pgUser != "" || pgPassword != "" {
+			return errors.New("--pgUser and --pgPassword require --pgHost")
+		}
+		return nil
+	}
+	if pgUser == "" || pgPassword == "" {
+		return errors.New("An external PostgreSQL database requires --pgUser and --pgPassword")
+	}
+	if !validNamespace.MatchString(pgUser) || !validNamespace.MatchString(pgDatabase) {
+		return errors.New("--pgUser and --pgDatabase may only contain lowercase letters, digits and . _ -")
+	}
+	if !validDBPassword.MatchString(pgPassword) {
+		return errors.New("--pgPassword must be at least 8 characters long and only contain letters, digits and . _ ~ -")
+	}
+
+	if skipForDryRun(fmt.Sprintf("test the connection to PostgreSQL database %s on %s:%d", pgDatabase, pgHost, pgPort)) {
+		return nil
+	}
+	log.Infof("Testing connection to PostgreSQL database %s on %s:%d", pgDatabase, pgHost, pgPort)
+	db, err := openExternalPostgres()
+	if err != nil {
+		return fmt.Errorf("Could not connect to PostgreSQL database %s on %s:%d: %s", pgDatabase, pgHost, pgPort, err.Error())
+	}
+	defer db.Close()
+
+	// Quay needs pg_trgm, try to create it when it is missing
+	var trgm int
+	if err := db.QueryRow("SELECT count(*) FROM pg_extension WHERE extname = 'pg_trgm'").Scan(&trgm); err != nil {
+		return err
+	}
+	if trgm == 0 {
+		if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm"); err != nil {
+			return fmt.Errorf("The pg_trgm extension is not installed in database %s and could not be created: %s", pgDatabase, err.Error())
+		}
+		log.Infof("Created the pg_trgm extension in database %s", pgDatabase)
+	}
+	log.Info("PostgreSQL connection check succeeded")
+	return nil
+}
+
+// externalPostgresVars returns the extra-vars pointing the playbook at the external PostgreSQL server, if any
+func externalPostgresVars() string {
+	if pgHost == "" {
+		return ""
+	}
+	return fmt.Sprintf(" external_postgres=true pg_host=%s pg_port=%d pg_user=%s pg_database=%s", pgHost, pgPort, pgUser, pgDatabase)
+}
+
+// openExternalPostgres connects to the external PostgreSQL server, preferring TLS like Quay does
+func openExternalPostgres() (*sql.DB, error) {
+	var err error
+	for _, sslMode := range []string{"require", "disable"} {
+		var db *sql.DB
+		db, err = sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=10", pgHost, pgPort, pgUser, pgPassword, pgDatabase, sslMode))
+		if err != nil {
+			return nil, err
+		}
+		if err = db.Ping(); err == nil {
+			return db, nil
+		}
+		db.Close()
+		if err != pq.ErrSSLNotSupported {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisHost is the host of an existing Redis server to use instead of the bundled one
+var redisHost string
+
+// redisPort is the port of the external Redis server
+var redisPort int
+
+// redisPassword is the password of the external Redis server
+var redisPassword string
+
+// redisPasswordEnv is the environment variable holding the external Redis password
+const redisPasswordEnv = "MIRROR_REGISTRY_REDIS_PASSWORD"
+
+// validateExternalRedis checks the external Redis flags and that the server accepts the password
+func validateExternalRedis() error {
+	if redisPassword == "" {
+		redisPassword = os.Getenv(redisPasswordEnv)
+	}
+	if redisHost == "" {
+		if redisPassword != "" {
+			return errors.New("--redisPassword requires --redisHost")
+		}
+		return nil
+	}
+	if redisPassword != "" && !validDBPassword.MatchString(redisPassword) {
+		return errors.New("--redisPassword must be at least 8 characters long and only contain letters, digits and . _ ~ -")
+	}
+
+	if skipForDryRun(fmt.Sprintf("test the connection to Redis on %s:%d", redisHost, redisPort)) {
+		return nil
+	}
+	log.Infof("Testing connection to Redis on %s:%d", redisHost, redisPort)
+	if err := pingRedis(); err != nil {
+		return fmt.Errorf("Could not connect to Redis on %s:%d: %s", redisHost, redisPort, err.Error())
+	}
+	log.Info("Redis connection check succeeded")
+	return nil
+}
+
+// pingRedis authenticates against the external Redis server and sends a PING
+func pingRedis() error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(redisHost, strconv.Itoa(redisPort)), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	send := func(args ...string) (string, error) {
+		command := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+		if _, err := conn.Write([]byte(command)); err != nil {
+			return "", err
+		}
+		reply, err := reader.ReadString('\n')
+		reply = strings.TrimSpace(reply)
+		if err == nil && strings.HasPrefix(reply, "-") {
+			err = errors.New(strings.TrimPrefix(reply, "-"))
+		}
+		return reply, err
+	}
+
+	if redisPassword != "" {
+		if _, err := send("AUTH", redisPassword); err != nil {
+			return err
+		}
+	}
+	reply, err := send("PING")
+	if err == nil && reply != "+PONG" {
+		err = errors.New("unexpected reply " + reply)
+	}
+	return err
+}
+
+// externalRedisVars returns the extra-vars pointing the playbook at the external Redis server, if any
+func externalRedisVars() string {
+	if redisHost == "" {
+		return ""
+	}
+	return fmt.Sprintf(" external_redis=true redis_host=%s redis_port=%d", redisHost, redisPort)
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// userCreation holds whether or not users can create their own accounts
+var userCreation bool
+
+// anonymousAccess holds whether or not public repositories can be pulled without logging in
+var anonymousAccess bool
+
+// repoMirror holds whether or not repositories can be configured to mirror an external registry
+var repoMirror bool
+
+// proxyCache holds whether or not organizations can be configured as a pull-through cache of an external registry
+var proxyCache bool
+
+// featureVars returns the extra-vars of the Quay feature flags
+func featureVars() string {
+	return fmt.Sprintf(" feature_user_creation=%t feature_anonymous_access=%t feature_repo_mirror=%t feature_proxy_cache=%t", userCreation, anonymousAccess, repoMirror, proxyCache)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+)
+
+// fipsMode holds whether or not to install a registry for a host running in FIPS mode
+var fipsMode bool
+
+// validateFIPS rejects options that would run cryptography outside the FIPS validated modules of the target
+func validateFIPS() error {
+	if !fipsMode {
+		return nil
+	}
+	// The default load balancer and acme.sh images come from Docker Hub and are not built on a FIPS validated base
+	if haMode && strings.HasPrefix(haproxyImage, "docker.io/") {
+		return errors.New("--fips with --ha requires a FIPS compatible load balancer image, pass it with --haproxyImage")
+	}
+	if acme && strings.HasPrefix(acmeImage, "docker.io/") {
+		return errors.New("--fips with --acme requires a FIPS compatible acme.sh image, pass it with --acmeImage")
+	}
+	for _, image := range []string{quayImage, postgresImage, redisImage} {
+		if !strings.HasPrefix(image, "registry.redhat.io/") && !strings.HasPrefix(image, "registry.access.redhat.com/") {
+			log.Warnf("%s is not a Red Hat image, make sure it is built for FIPS mode", image)
+		}
+	}
+	return nil
+}
+
+// checkFIPSMode fails when --fips is set and the kernel of the target is not in FIPS mode
+func checkFIPSMode(facts runtimeFacts) error {
+	if fipsMode && !facts.FIPS {
+		return errors.New("--fips requires the target to run in FIPS mode, enable it with fips-mode-setup --enable and reboot")
+	}
+	return nil
+}
+
+// fipsVars returns the extra-vars of a FIPS install
+func fipsVars() string {
+	if !fipsMode {
+		return ""
+	}
+	return " fips_mode=true"
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// mirrorMappings are the source=mirror repository pairs written into generated manifests
+var mirrorMappings []string
+
+// manifestName is the metadata.name of generated manifests
+var manifestName string
+
+// generateOutput is the file the generate commands write to, stdout if empty
+var generateOutput string
+
+// releaseSources are the repositories an OpenShift release pulls its payload from
+var releaseSources = []string{
+	"quay.io/openshift-release-dev/ocp-release",
+	"quay.io/openshift-release-dev/ocp-v4.0-art-dev",
+}
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate configuration for clients of the installed registry.",
+	// Keep stdout clean so the output can be piped to oc apply
+	PersistentPreRun: quietPreRun,
+}
+
+// generateICSPCmd represents the generate icsp command
+var generateICSPCmd = &cobra.Command{
+	Use:   "icsp",
+	Short: "Generate an ImageContentSourcePolicy pointing OpenShift nodes at the installed registry.",
+	Run: func(cmd *cobra.Command, args []string) {
+		generateMirrorManifest("operator.openshift.io/v1alpha1", "ImageContentSourcePolicy", "repositoryDigestMirrors")
+	},
+}
+
+// generateIDMSCmd represents the generate idms command
+var generateIDMSCmd = &cobra.Command{
+	Use:   "idms",
+	Short: "Generate an ImageDigestMirrorSet pointing OpenShift nodes at the installed registry.",
+	Run: func(cmd *cobra.Command, args []string) {
+		generateMirrorManifest("config.openshift.io/v1", "ImageDigestMirrorSet", "imageDigestMirrors")
+	},
+}
+
+func init() {
+
+	// Add generate command
+	rootCmd.AddCommand(generateCmd)
+	generateCmd.AddCommand(generateICSPCmd)
+	generateCmd.AddCommand(generateIDMSCmd)
+
+	for _, cmd := range []*cobra.Command{generateICSPCmd, generateIDMSCmd} {
+		cmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+		cmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+		cmd.Flags().StringVarP(&mirrorTo, "to", "", "", "The repository an OpenShift release was mirrored to. This defaults to <quayHostname>/ocp4/openshift4")
+		cmd.Flags().StringArrayVarP(&mirrorMappings, "mapping", "", nil, "An additional source=mirror repository pair, e.g. registry.redhat.io/rhel8=<quayHostname>/rhel8. May be repeated.")
+		cmd.Flags().StringVarP(&manifestName, "name", "", "mirror-registry", "The name of the generated manifest. This defaults to mirror-registry")
+		cmd.Flags().StringVarP(&generateOutput, "output", "o", "", "The file to write the manifest to. This defaults to stdout")
+	}
+}
+
+// generateMirrorManifest writes a manifest of kind listing every source repository with its mirror under field
+func generateMirrorManifest(apiVersion, kind, field string) {
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+	if mirrorTo == "" {
+		mirrorTo = quayHostname + "/ocp4/openshift4"
+	}
+
+	mirrors, err := repositoryMirrors()
+	check(err)
+
+	var entries []map[string]interface{}
+	var sources []string
+	for source := range mirrors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		entries = append(entries, map[string]interface{}{"source": source, "mirrors": mirrors[source]})
+	}
+
+	manifest := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": manifestName},
+		"spec":       map[string]interface{}{field: entries},
+	}
+	data, err := yaml.Marshal(manifest)
+	check(err)
+
+	if generateOutput == "" {
+		fmt.Print(string(data))
+		return
+	}
+	if _, err := os.Stat(generateOutput); err == nil {
+		log.Warnf("%s already exists and will be overwritten", generateOutput)
+	}
+	err = ioutil.WriteFile(generateOutput, data, 0644)
+	check(err)
+	log.Printf("%s written to %s, apply it with oc apply -f %s", kind, generateOutput, generateOutput)
+}
+
+// repositoryMirrors returns the mirrors of every source repository, the release repositories mirror to --to
+func repositoryMirrors() (map[string][]string, error) {
+	mirrors := map[string][]string{}
+	for _, source := range releaseSources {
+		mirrors[source] = []string{mirrorTo}
+	}
+	for _, mapping := range mirrorMappings {
+		kv := strings.SplitN(mapping, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New("Invalid --mapping " + mapping + ", expected source=mirror")
+		}
+		mirrors[kv[0]] = append(mirrors[kv[0]], kv[1])
+	}
+	return mirrors, nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// blackboxExporter is the address of the blackbox exporter probing the health endpoint and certificate of the registry
+var blackboxExporter string
+
+// blackboxModule is the blackbox exporter module the health endpoint is probed with
+var blackboxModule string
+
+// nodeExporterPort is the port of the node exporter on the target, reporting its disk usage
+var nodeExporterPort int
+
+// monitoringExporters holds whether or not the Postgres and Redis exporters of --with-monitoring are scraped
+var monitoringExporters bool
+
+// certExpiryDays is how many days before the certificate expires an alert is raised
+var certExpiryDays int
+
+// diskFreePercent is the free space of a filesystem of the target below which an alert is raised
+var diskFreePercent int
+
+// gcBacklog is the number of pending garbage collection queue items above which an alert is raised
+var gcBacklog int
+
+// scrapeConfigFile and alertRulesFile are the files generate monitoring writes to --output
+const (
+	scrapeConfigFile = "mirror-registry-scrape.yml"
+	alertRulesFile   = "mirror-registry-rules.yml"
+)
+
+// scrapeConfig is a Prometheus scrape config
+type scrapeConfig struct {
+	JobName        string                   `yaml:"job_name"`
+	MetricsPath    string                   `yaml:"metrics_path,omitempty"`
+	Params         map[string][]string      `yaml:"params,omitempty"`
+	StaticConfigs  []staticConfig           `yaml:"static_configs"`
+	RelabelConfigs []map[string]interface{} `yaml:"relabel_configs,omitempty"`
+}
+
+// staticConfig is a list of Prometheus scrape targets with their labels
+type staticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// alertRuleGroup is a group of a Prometheus rule file
+type alertRuleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+// alertRule is a Prometheus alerting rule
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// generateMonitoringCmd represents the generate monitoring command
+var generateMonitoringCmd = &cobra.Command{
+	Use:   "monitoring",
+	Short: "Generate Prometheus scrape configs and alert rules for the installed registry, for an existing monitoring stack.",
+	Run: func(cmd *cobra.Command, args []string) {
+		generateMonitoring()
+	},
+}
+
+func init() {
+
+	// Add monitoring command
+	generateCmd.AddCommand(generateMonitoringCmd)
+
+	generateMonitoringCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	generateMonitoringCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+	generateMonitoringCmd.Flags().BoolVarP(&monitoringExporters, "exporters", "", true, "Whether or not to scrape the Postgres and Redis exporters of --with-monitoring. Disable it for an external database and Redis. This defaults to true")
+	generateMonitoringCmd.Flags().StringVarP(&blackboxExporter, "blackboxExporter", "", "localhost:9115", "The address of the blackbox exporter that probes the health endpoint and the certificate of the registry. This defaults to localhost:9115")
+	generateMonitoringCmd.Flags().StringVarP(&blackboxModule, "blackboxModule", "", "http_2xx", "The blackbox exporter module the health endpoint is probed with. It must trust the CA of the registry. This defaults to http_2xx")
+	generateMonitoringCmd.Flags().IntVarP(&nodeExporterPort, "nodeExporterPort", "", 9100, "The port of the node exporter on the target, which reports its disk usage. This defaults to 9100")
+	generateMonitoringCmd.Flags().IntVarP(&certExpiryDays, "certExpiryDays", "", 14, "Alert when the certificate of the registry expires in fewer days. This defaults to 14")
+	generateMonitoringCmd.Flags().IntVarP(&diskFreePercent, "diskFreePercent", "", 10, "Alert when a filesystem of the target has less free space, in percent. This defaults to 10")
+	generateMonitoringCmd.Flags().IntVarP(&gcBacklog, "gcBacklog", "", 100, "Alert when more namespace or repository garbage collection items are pending for an hour. This defaults to 100")
+	generateMonitoringCmd.Flags().StringVarP(&generateOutput, "output", "o", "", "The directory to write "+scrapeConfigFile+" and "+alertRulesFile+" to. This defaults to stdout")
+}
+
+func generateMonitoring() {
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+	if certExpiryDays < 1 || diskFreePercent < 1 || diskFreePercent > 99 || gcBacklog < 1 {
+		check(errors.New("--certExpiryDays and --gcBacklog must be positive and --diskFreePercent between 1 and 99"))
+	}
+
+	scrape, err := yaml.Marshal(map[string][]scrapeConfig{"scrape_configs": monitoringScrapeConfigs()})
+	check(err)
+	rules, err := yaml.Marshal(map[string][]alertRuleGroup{"groups": {{Name: "mirror-registry", Rules: monitoringAlertRules()}}})
+	check(err)
+
+	if generateOutput == "" {
+		fmt.Printf("# %s, merge into the scrape_configs of prometheus.yml\n%s---\n# %s, add to the rule_files of prometheus.yml\n%s", scrapeConfigFile, scrape, alertRulesFile, rules)
+		return
+	}
+	err = os.MkdirAll(generateOutput, 0755)
+	check(err)
+	for file, data := range map[string][]byte{scrapeConfigFile: scrape, alertRulesFile: rules} {
+		err = ioutil.WriteFile(path.Join(generateOutput, file), data, 0644)
+		check(err)
+	}
+	log.Printf("Scrape configs written to %s and alert rules to %s", path.Join(generateOutput, scrapeConfigFile), path.Join(generateOutput, alertRulesFile))
+}
+
+// monitoringJob returns the job name of a component of the registry in the generated scrape configs
+func monitoringJob(component string) string {
+	return "mirror-registry-" + component
+}
+
+// monitoringScrapeConfigs returns the scrape configs of the metrics endpoints of --with-monitoring, the node exporter of
+// the target and a blackbox probe of the health endpoint of the registry
+func monitoringScrapeConfigs() []scrapeConfig {
+	host := hostOnly(targetHostname)
+	labels := map[string]string{"instance": host}
+	configs := []scrapeConfig{
+		{JobName: monitoringJob("quay"), StaticConfigs: []staticConfig{{Targets: []string{withPort(host, "9091")}, Labels: labels}}},
+	}
+	if monitoringExporters {
+		configs = append(configs,
+			scrapeConfig{JobName: monitoringJob("postgres"), StaticConfigs: []staticConfig{{Targets: []string{withPort(host, "9187")}, Labels: labels}}},
+			scrapeConfig{JobName: monitoringJob("redis"), StaticConfigs: []staticConfig{{Targets: []string{withPort(host, "9121")}, Labels: labels}}},
+		)
+	}
+	configs = append(configs,
+		scrapeConfig{JobName: monitoringJob("node"), StaticConfigs: []staticConfig{{Targets: []string{withPort(host, fmt.Sprint(nodeExporterPort))}, Labels: labels}}},
+		scrapeConfig{
+			JobName:       monitoringJob("health"),
+			MetricsPath:   "/probe",
+			Params:        map[string][]string{"module": {blackboxModule}},
+			StaticConfigs: []staticConfig{{Targets: []string{"https://" + quayHostname + "/health/instance"}}},
+			RelabelConfigs: []map[string]interface{}{
+				{"source_labels": []string{"__address__"}, "target_label": "__param_target"},
+				{"source_labels": []string{"__param_target"}, "target_label": "instance"},
+				{"target_label": "__address__", "replacement": blackboxExporter},
+			},
+		},
+	)
+	return configs
+}
+
+// monitoringAlertRules returns the alerts for the registry being down, its certificate expiring, the disks of the
+// target filling up and a garbage collection backlog
+func monitoringAlertRules() []alertRule {
+	down := fmt.Sprintf(`probe_success{job="%s"} == 0 or up{job="%s"} == 0`, monitoringJob("health"), monitoringJob("quay"))
+	if monitoringExporters {
+		down += fmt.Sprintf(` or pg_up{job="%s"} == 0 or redis_up{job="%s"} == 0`, monitoringJob("postgres"), monitoringJob("redis"))
+	}
+	filesystems := fmt.Sprintf(`job="%s",fstype!~"tmpfs|overlay|squashfs"`, monitoringJob("node"))
+	return []alertRule{
+		{
+			Alert:  "MirrorRegistryDown",
+			Expr:   down,
+			For:    "5m",
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "The mirror registry " + quayHostname + " is down",
+				"description": "The health endpoint of Quay, Quay itself or its database or Redis has not responded for 5 minutes.",
+			},
+		},
+		{
+			Alert:  "MirrorRegistryCertificateExpiring",
+			Expr:   fmt.Sprintf(`probe_ssl_earliest_cert_expiry{job="%s"} - time() < %d * 86400`, monitoringJob("health"), certExpiryDays),
+			For:    "1h",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "The certificate of the mirror registry " + quayHostname + " expires soon",
+				"description": fmt.Sprintf("The certificate expires in less than %d days, renew it with mirror-registry cert rotate.", certExpiryDays),
+			},
+		},
+		{
+			Alert:  "MirrorRegistryDiskFilling",
+			Expr:   fmt.Sprintf(`node_filesystem_avail_bytes{%[1]s} / node_filesystem_size_bytes{%[1]s} * 100 < %[2]d`, filesystems, diskFreePercent),
+			For:    "15m",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "A filesystem of the mirror registry host " + hostOnly(targetHostname) + " is filling up",
+				"description": fmt.Sprintf("{{ $labels.mountpoint }} has less than %d%% free space. Prune unused images with mirror-registry prune or grow the storage.", diskFreePercent),
+			},
+		},
+		{
+			Alert:  "MirrorRegistryDiskFullIn24h",
+			Expr:   fmt.Sprintf(`predict_linear(node_filesystem_avail_bytes{%s}[6h], 24 * 3600) < 0`, filesystems),
+			For:    "1h",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "A filesystem of the mirror registry host " + hostOnly(targetHostname) + " will be full within a day",
+				"description": "{{ $labels.mountpoint }} fills up at a rate that exhausts it within 24 hours.",
+			},
+		},
+		{
+			Alert:  "MirrorRegistryGCBacklog",
+			Expr:   fmt.Sprintf(`sum by (queue_name) (quay_queue_items_available_unlocked{job="%s",queue_name=~"namespacegc|repositorygc"}) > %d`, monitoringJob("quay"), gcBacklog),
+			For:    "1h",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Garbage collection of the mirror registry " + quayHostname + " is falling behind",
+				"description": "{{ $value }} items of the {{ $labels.queue_name }} queue have been pending for an hour, so deleted content does not free storage.",
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// caInstallConfig holds whether or not to print the CA as the additionalTrustBundle of an install-config.yaml
+var caInstallConfig bool
+
+// getCACmd represents the get-ca command
+var getCACmd = &cobra.Command{
+	Use:   "get-ca",
+	Short: "Print the CA clients must trust to pull from the registry.",
+	// Keep stdout clean so the CA can be redirected to a file
+	PersistentPreRun: quietPreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		getCA()
+	},
+}
+
+func init() {
+
+	// Add get-ca command
+	rootCmd.AddCommand(getCACmd)
+
+	getCACmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	getCACmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	getCACmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	getCACmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	getCACmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	getCACmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	getCACmd.Flags().StringVarP(&generateOutput, "output", "o", "", "The file to write the CA to. This defaults to stdout")
+	getCACmd.Flags().BoolVarP(&caInstallConfig, "install-config", "", false, "Print the CA as an additionalTrustBundle block, indented for install-config.yaml")
+}
+
+func getCA() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	ca, err := runRemoteCommand(registryCAScript())
+	check(err)
+	if caInstallConfig {
+		ca = additionalTrustBundle(ca)
+	}
+
+	if generateOutput == "" {
+		fmt.Print(ca)
+		return
+	}
+	err = ioutil.WriteFile(generateOutput, []byte(ca), 0644)
+	check(err)
+	log.Printf("CA of %s written to %s", targetHostname, generateOutput)
+}
+
+// additionalTrustBundle formats PEM certificates as the additionalTrustBundle field of install-config.yaml
+func additionalTrustBundle(pem string) string {
+	var bundle strings.Builder
+	bundle.WriteString("additionalTrustBundle: |\n")
+	for _, line := range strings.Split(strings.TrimSpace(pem), "\n") {
+		bundle.WriteString("  " + strings.TrimSpace(line) + "\n")
+	}
+	return bundle.String()
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// credentialsFromTarget holds whether or not to read the credentials kept on the target instead of the local ones
+var credentialsFromTarget bool
+
+// getCredentialsCmd represents the get-credentials command
+var getCredentialsCmd = &cobra.Command{
+	Use:   "get-credentials",
+	Short: "Print the init user credentials and the URL of the registry.",
+	Run: func(cmd *cobra.Command, args []string) {
+		getCredentials()
+	},
+}
+
+func init() {
+
+	// Add get-credentials command
+	rootCmd.AddCommand(getCredentialsCmd)
+
+	getCredentialsCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	getCredentialsCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	getCredentialsCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	getCredentialsCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	getCredentialsCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	getCredentialsCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	getCredentialsCmd.Flags().BoolVarP(&credentialsFromTarget, "from-target", "", false, "Read the credentials kept on the target even if the control host has them")
+	getCredentialsCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the credentials as JSON")
+}
+
+func getCredentials() {
+
+	// Prefer the credentials of the control host, the target keeps a copy for other control hosts
+	source := credentialsFile(targetHostname)
+	credentials, err := loadCredentials(targetHostname)
+	check(err)
+	if credentialsFromTarget || credentials["initPassword"] == "" {
+		err = loadSSHKeys()
+		check(err)
+		log.Infof("Reading the init credentials from %s", targetHostname)
+		credentials, err = loadTargetCredentials()
+		check(err)
+		source = targetHostname + ":" + targetCredentialsFile()
+	}
+	if credentials["initPassword"] == "" {
+		check(errors.New("No init credentials are stored for " + targetHostname + " on this host or the target. Set a new password with reset-password"))
+	}
+	registerSecret(credentials["initPassword"])
+
+	// Installs before the URL was stored use the default SERVER_HOSTNAME
+	hostname := credentials["quayHostname"]
+	if hostname == "" {
+		hostname = withPort(targetHostname, "8443")
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(map[string]string{
+			"host":     targetHostname,
+			"url":      "https://" + hostname,
+			"user":     credentials["initUser"],
+			"password": credentials["initPassword"],
+			"source":   source,
+		}, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+		return
+	}
+	log.WithField(revealSecrets, true).Printf("Quay is available at %s with credentials (%s, %s), stored in %s", "https://"+hostname, credentials["initUser"], credentials["initPassword"], source)
+}
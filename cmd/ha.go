@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// haMode holds whether or not to install Quay on several nodes behind a load balancer
+var haMode bool
+
+// haInventory is the path of the ansible inventory listing the quay and loadbalancer hosts of an HA install
+var haInventory string
+
+// haproxyImage is the HAProxy image run on the load balancer of an HA install
+var haproxyImage string
+
+// s3SecretKeyEnv is the environment variable the secret key of the shared object storage is read from
+const s3SecretKeyEnv = "MIRROR_REGISTRY_S3_SECRET_KEY"
+
+// inventoryGroup is a group of a YAML ansible inventory
+type inventoryGroup struct {
+	Hosts    yaml.Node                 `yaml:"hosts"`
+	Vars     map[string]interface{}    `yaml:"vars"`
+	Children map[string]inventoryGroup `yaml:"children"`
+}
+
+// haTopology is the part of the inventory the installer needs to know about
+type haTopology struct {
+	Quay         []string
+	LoadBalancer string
+	Target       string // address of the first quay host
+	Vars         map[string]interface{}
+}
+
+// loadHAInventory reads and validates the --inventory of an HA install. The first quay host becomes the
+// target of the installer's own checks and the load balancer provides the default --quayHostname.
+func loadHAInventory() (haTopology, error) {
+	var topology haTopology
+	if haInventory == "" {
+		return topology, errors.New("--ha requires --inventory")
+	}
+	data, err := ioutil.ReadFile(haInventory)
+	if err != nil {
+		return topology, errors.New("Could not read --inventory: " + err.Error())
+	}
+	var inventory map[string]inventoryGroup
+	if err := yaml.Unmarshal(data, &inventory); err != nil {
+		return topology, errors.New("Could not parse --inventory " + haInventory + ": " + err.Error())
+	}
+	all := inventory["all"]
+	topology.Vars = all.Vars
+	groups := map[string]inventoryGroup{}
+	for name, group := range inventory {
+		groups[name] = group
+	}
+	for name, group := range all.Children {
+		groups[name] = group
+	}
+	topology.Quay = inventoryHosts(groups["quay"])
+	topology.Target = inventoryAddress(groups["quay"], 0)
+	loadBalancers := inventoryHosts(groups["loadbalancer"])
+
+	if len(topology.Quay) < 2 {
+		return topology, errors.New("The quay group of --inventory must list at least 2 hosts")
+	}
+	if len(loadBalancers) != 1 {
+		return topology, errors.New("The loadbalancer group of --inventory must list exactly 1 host")
+	}
+	topology.LoadBalancer = loadBalancers[0]
+	for _, host := range topology.Quay {
+		if host == topology.LoadBalancer {
+			return topology, errors.New("The load balancer " + host + " cannot also be a quay host, both listen on port 8443")
+		}
+	}
+	if stringVar(topology.Vars, "s3_host") == "" || stringVar(topology.Vars, "s3_bucket") == "" || stringVar(topology.Vars, "s3_access_key") == "" {
+		return topology, errors.New("--ha requires shared object storage, set s3_host, s3_bucket and s3_access_key in the vars of --inventory")
+	}
+	if stringVar(topology.Vars, "s3_secret_key") == "" && os.Getenv(s3SecretKeyEnv) == "" {
+		return topology, errors.New("--ha requires the secret key of the shared object storage in $" + s3SecretKeyEnv)
+	}
+	return topology, nil
+}
+
+// validateHA checks the install options that an HA install depends on
+func validateHA() error {
+	switch {
+	case pgHost == "":
+		return errors.New("--ha requires a shared PostgreSQL database, set --pgHost")
+	case redisHost == "":
+		return errors.New("--ha requires a shared Redis server, set --redisHost")
+	case sslCert == "" || sslKey == "":
+		return errors.New("--ha requires --sslCert and --sslKey for the load balancer hostname, every node must serve the same certificate")
+	case enableCertAutorenew:
+		return errors.New("--enable-cert-autorenew cannot be used with --ha")
+	case restoreFrom != "":
+		return errors.New("restore does not support HA installs")
+	}
+	return nil
+}
+
+// playbookInventory returns the ansible-playbook inventory arguments
+func playbookInventory() string {
+	if haMode {
+		return "/runner/env/inventory.yaml -u " + targetUsername
+	}
+	return targetUsername + "@" + targetHostname + ","
+}
+
+// haInventoryMountFlag returns the podman flag mounting the HA inventory into the execution environment
+func haInventoryMountFlag() (string, error) {
+	if !haMode {
+		return "", nil
+	}
+	abs, err := filepath.Abs(haInventory)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(" -v %s:/runner/env/inventory.yaml:Z", hostMountPath(abs)), nil
+}
+
+// haVars returns the extra-vars of an HA install
+func haVars() string {
+	if !haMode {
+		return ""
+	}
+	return " haproxy_image=" + haproxyImage
+}
+
+// stringVar returns an inventory variable as a string
+func stringVar(vars map[string]interface{}, name string) string {
+	if value, ok := vars[name]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// inventoryAddress returns the ansible_host of the i-th host of a group, or its name
+func inventoryAddress(group inventoryGroup, i int) string {
+	if group.Hosts.Kind != yaml.MappingNode || len(group.Hosts.Content) < 2*i+2 {
+		return ""
+	}
+	var vars map[string]interface{}
+	group.Hosts.Content[2*i+1].Decode(&vars)
+	if address := stringVar(vars, "ansible_host"); address != "" {
+		return address
+	}
+	return group.Hosts.Content[2*i].Value
+}
+
+// inventoryHosts returns the hosts of a group in the order of the inventory file, the first quay host creates the init user
+func inventoryHosts(group inventoryGroup) []string {
+	var hosts []string
+	if group.Hosts.Kind == yaml.MappingNode {
+		for i := 0; i < len(group.Hosts.Content); i += 2 {
+			hosts = append(hosts, group.Hosts.Content[i].Value)
+		}
+	}
+	return hosts
+}
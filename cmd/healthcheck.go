@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// skipHealthcheck holds whether or not to report the install as successful without waiting for Quay to serve requests
+var skipHealthcheck bool
+
+// healthcheckTimeout is the number of seconds to wait for Quay to serve requests after the install playbook
+var healthcheckTimeout int
+
+// healthcheckInterval is the delay between the attempts of the post-install health check
+const healthcheckInterval = 10 * time.Second
+
+// waitForInstall polls the Quay instance health endpoint and, when a password is given, signs in as the user until both
+// succeed or the timeout passes. Quay reports healthy before its workers finished starting, so the sign-in confirms the
+// registry actually serves requests backed by its database.
+func waitForInstall(hostname, username, password string, timeout time.Duration) error {
+	log.Printf("Waiting up to %s for Quay to serve requests at https://%s", timeout, hostname)
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkQuayHealth(hostname)
+		if err == nil && password != "" {
+			if _, _, err = signIn(hostname, username, password); err != nil {
+				err = fmt.Errorf("login failed: %s", err.Error())
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(healthcheckInterval).After(deadline) {
+			return fmt.Errorf("Quay did not serve requests within %s: %s", timeout, err.Error())
+		}
+		log.Debugf("Quay is not ready yet (%s), retrying in %s", err.Error(), healthcheckInterval)
+		time.Sleep(healthcheckInterval)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validHostname matches RFC 1123 hostnames, which is what clients accept in a registry reference and a certificate
+var validHostname = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// ipv6Mode holds whether or not the target only has IPv6 connectivity, so Quay must be published on IPv6
+var ipv6Mode bool
+
+// hostOnly returns the host of a host[:port] value. IPv6 literals are returned without brackets, and take a port
+// only when bracketed, as in [fd00::1]:8443.
+func hostOnly(hostport string) string {
+	if strings.HasPrefix(hostport, "[") {
+		if end := strings.Index(hostport, "]"); end > 0 {
+			return hostport[1:end]
+		}
+	}
+	if net.ParseIP(hostport) != nil {
+		return hostport
+	}
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+// portOf returns the port of a host[:port] value, or "" if it has none
+func portOf(hostport string) string {
+	if _, port, err := net.SplitHostPort(hostport); err == nil {
+		return port
+	}
+	return ""
+}
+
+// withPort adds the port to a host that has none, bracketing IPv6 literals
+func withPort(hostport, port string) string {
+	if portOf(hostport) != "" {
+		return hostport
+	}
+	return net.JoinHostPort(hostOnly(hostport), port)
+}
+
+// isIPv6 reports whether the host of a host[:port] value is an IPv6 literal
+func isIPv6(hostport string) bool {
+	ip := net.ParseIP(hostOnly(hostport))
+	return ip != nil && ip.To4() == nil
+}
+
+// ipv6Vars returns the extra-vars publishing Quay on IPv6
+func ipv6Vars() string {
+	if !ipv6Mode {
+		return ""
+	}
+	return " ipv6_mode=true"
+}
+
+// scpHost returns the host of the target as scp expects it, IPv6 literals must be bracketed
+func scpHost() string {
+	if isIPv6(targetHostname) {
+		return "[" + hostOnly(targetHostname) + "]"
+	}
+	return hostOnly(targetHostname)
+}
+
+// validateQuayHostname checks that quayHostname is a legal hostname or IP address with an optional port
+func validateQuayHostname() error {
+	host, port := hostOnly(quayHostname), portOf(quayHostname)
+	if net.ParseIP(host) == nil && (len(host) > 253 || !validHostname.MatchString(host)) {
+		return errors.New("Invalid --quayHostname " + quayHostname + ", " + host + " is not a valid hostname. Use letters, digits, - and . only")
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return errors.New("Invalid --quayHostname " + quayHostname + ", the port must be between 1 and 65535")
+		}
+	}
+	return nil
+}
+
+// hostnameCheck resolves the host of quayHostname on the control host and on the target and compares the addresses
+// with those of the target. A mismatch is only a warning since Quay may sit behind a load balancer.
+func hostnameCheck(targetAddresses, targetResolved []string) preflightCheck {
+	name := "hostname"
+	if err := validateQuayHostname(); err != nil {
+		return preflightCheck{Name: name, Result: "FAIL", Detail: err.Error()}
+	}
+	host := hostOnly(quayHostname)
+	if net.ParseIP(host) != nil {
+		targetResolved = []string{host}
+	}
+	if len(targetResolved) == 0 {
+		return preflightCheck{Name: name, Result: "FAIL", Detail: host + " does not resolve on the target, add it to DNS or /etc/hosts"}
+	}
+	local, err := net.LookupHost(host)
+	if err != nil {
+		return preflightCheck{Name: name, Result: "WARN", Detail: fmt.Sprintf("%s resolves on the target to %s but not on the control host, clients may not reach it", host, strings.Join(targetResolved, ", "))}
+	}
+	sort.Strings(local)
+	for _, address := range targetResolved {
+		for _, own := range targetAddresses {
+			if net.ParseIP(address).Equal(net.ParseIP(own)) {
+				return preflightCheck{Name: name, Result: "PASS", Detail: fmt.Sprintf("%s resolves to %s, an address of the target", host, address)}
+			}
+		}
+	}
+	return preflightCheck{Name: name, Result: "WARN", Detail: fmt.Sprintf("%s resolves to %s, which is not an address of the target. This is only expected behind a load balancer", host, strings.Join(local, ", "))}
+}
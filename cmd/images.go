@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// archiveSkipImages are the images that are pulled instead of loaded from the image archive bundled with the installer
+var archiveSkipImages []string
+
+// imageOverrideFlags maps the image flags to the members of the image archive holding their default images
+var imageOverrideFlags = []struct {
+	flag string
+	name string
+}{
+	{"quayImage", "quay"},
+	{"redisImage", "redis"},
+	{"postgresImage", "postgres"},
+}
+
+// validateImageOverrides checks the image flags and returns the images set on the command line or in the config file
+func validateImageOverrides(flags *pflag.FlagSet) ([]string, error) {
+	var overridden []string
+	for _, image := range imageOverrideFlags {
+		flag := flags.Lookup(image.flag)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		reference := flag.Value.String()
+		if reference == "" || strings.ContainsAny(reference, " \t\"'") {
+			return nil, errors.New("Invalid --" + image.flag + " " + reference + ", expected an image reference")
+		}
+		// The Quay version is read from the tag
+		if image.name == "quay" && (strings.Contains(reference, "@") || !strings.Contains(reference[strings.LastIndex(reference, "/")+1:], ":")) {
+			return nil, errors.New("Invalid --quayImage " + reference + ", the Quay image must be referenced by tag, e.g. registry.example.com/quay/quay-rhel8:v3.8.1")
+		}
+		log.Infof("Deploying %s instead of the %s image of this release", reference, image.name)
+		overridden = append(overridden, image.name)
+	}
+	return overridden, nil
+}
+
+// loadsFromArchive reports whether an image is loaded from the image archive rather than pulled
+func loadsFromArchive(name string) bool {
+	for _, skipped := range archiveSkipImages {
+		if skipped == name {
+			return false
+		}
+	}
+	return true
+}
+
+// imageOverrideVars returns the extra-var listing the images the playbook does not load from the image archive
+func imageOverrideVars() string {
+	if len(archiveSkipImages) == 0 {
+		return ""
+	}
+	return " archive_skip_images=" + strings.Join(archiveSkipImages, ",")
+}
@@ -3,16 +3,19 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq" // pg driver
 	"github.com/sethvargo/go-password/password"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // These variables are set at build time via ldflags
@@ -21,6 +24,7 @@ var pauseImage string
 var quayImage string
 var redisImage string
 var postgresImage string
+var clairImage string
 
 // imageArchivePath is the optional location of the OCI image archive containing required install images
 var imageArchivePath string
@@ -40,6 +44,9 @@ var sslKey string
 // sslCheckSkip holds whether or not to check the SSL certificate
 var sslCheckSkip bool
 
+// enableCertAutorenew holds whether or not to install a timer on the target that renews the self-signed certificate
+var enableCertAutorenew bool
+
 // targetHostname is the hostname of the server you wish to install Quay on
 var targetHostname string
 
@@ -52,6 +59,12 @@ var initUser string
 // initPassword is the password of the initial user.
 var initPassword string
 
+// initPasswordStdin holds whether or not to read the password of the initial user from stdin
+var initPasswordStdin bool
+
+// initPasswordEnv is the environment variable the init password is read from and passed to the playbook in
+const initPasswordEnv = "MIRROR_REGISTRY_INIT_PASSWORD"
+
 // quayHostname is the value to set SERVER_HOSTNAME in the Quay config.yaml
 var quayHostname string
 
@@ -67,6 +80,30 @@ var quayStorage string
 // pgStorage is the directory where all the Postgres data is stored
 var pgStorage string
 
+// quayLogLevel is the log level of the Quay application
+var quayLogLevel string
+
+// usePodmanSecrets holds whether or not the database and Redis passwords are passed as podman secrets
+var usePodmanSecrets bool
+
+// networkMode is the network mode of the ansible-runner container
+var networkMode string
+
+// podNetworkMode is the network mode of the Quay pod on the target
+var podNetworkMode string
+
+// ansibleTimeout is the ansible connection and privilege escalation timeout in seconds
+var ansibleTimeout int
+
+// sshConnectTimeout is the SSH ConnectTimeout in seconds, 0 uses ansibleTimeout
+var sshConnectTimeout int
+
+// sshPort is the port sshd listens on on the target host
+var sshPort int
+
+// sshControlPersist is the SSH ControlPersist setting used by ansible
+var sshControlPersist string
+
 // additionalArgs are arguments that you would like to append to the end of the ansible-playbook call (used mostly for development)
 var additionalArgs string
 
@@ -75,7 +112,7 @@ var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install Quay and its required dependencies.",
 	Run: func(cmd *cobra.Command, args []string) {
-		install()
+		install(cmd.Flags())
 	},
 }
 
@@ -84,63 +121,426 @@ func init() {
 	// Add install command
 	rootCmd.AddCommand(installCmd)
 
+	installCmd.Flags().StringVarP(&installConfigFile, "config", "", "", "The path of a YAML file declaring install options, keyed by flag name. Flags given on the command line take precedence.")
 	installCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target you wish to install Quay to. This defaults to $HOST")
 	installCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
-	installCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key. This defaults to ~/.ssh/quay_installer")
+	installCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	installCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	installCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	installCmd.Flags().StringVarP(&eeArchivePath, "eeArchive", "", "", "The path of execution-environment.tar. This defaults to the directory of the mirror-registry binary")
+	installCmd.Flags().BoolVarP(&skipArchiveChecksum, "skipArchiveChecksum", "", false, "Use an execution environment or image archive that does not match the SHA-256 digests this release was built with, e.g. a custom build.")
 
 	installCmd.Flags().StringVarP(&sslCert, "sslCert", "", "", "The path to the SSL certificate Quay should use")
 	installCmd.Flags().StringVarP(&sslKey, "sslKey", "", "", "The path to the SSL key Quay should use")
 	installCmd.Flags().BoolVarP(&sslCheckSkip, "sslCheckSkip", "", false, "Whether or not to check the certificate hostname against the SERVER_HOSTNAME in config.yaml.")
+	installCmd.Flags().BoolVarP(&enableCertAutorenew, "enable-cert-autorenew", "", false, "Install a systemd timer on the target that renews the self-signed certificate before it expires. Cannot be combined with --sslCert/--sslKey.")
 
 	installCmd.Flags().StringVarP(&initUser, "initUser", "", "init", "The username of the initial user. This defaults to init.")
-	installCmd.Flags().StringVarP(&initPassword, "initPassword", "", "", "The password of the initial user. If not specified, this will be randomly generated.")
+	installCmd.Flags().StringVarP(&initPassword, "initPassword", "", "", "The password of the initial user. Can also be set with $MIRROR_REGISTRY_INIT_PASSWORD or --initPassword-stdin. If not specified, this will be randomly generated.")
+	installCmd.Flags().BoolVarP(&initPasswordStdin, "initPassword-stdin", "", false, "Read the password of the initial user from stdin.")
+	installCmd.Flags().StringVarP(&secretKeyFile, "secretKey", "", "", "The path of a file containing the SECRET_KEY to reuse, e.g. when restoring a backup. Can also be set with $MIRROR_REGISTRY_SECRET_KEY. If not specified, the key of an existing install is kept or a new one is randomly generated.")
+	installCmd.Flags().StringVarP(&databaseSecretKeyFile, "databaseSecretKey", "", "", "The path of a file containing the DATABASE_SECRET_KEY to reuse, e.g. when restoring a backup. Can also be set with $MIRROR_REGISTRY_DATABASE_SECRET_KEY. If not specified, the key of an existing install is kept or a new one is randomly generated.")
+	installCmd.Flags().StringVarP(&oidcIssuer, "oidcIssuer", "", "", "The OIDC issuer URL (e.g. https://sso.example.com/auth/realms/quay) to log into Quay with. Requires --oidcClientID and --oidcClientSecret.")
+	installCmd.Flags().StringVarP(&oidcClientID, "oidcClientID", "", "", "The client ID of Quay in the OIDC provider")
+	installCmd.Flags().StringVarP(&oidcClientSecret, "oidcClientSecret", "", "", "The client secret of Quay in the OIDC provider. Can also be set with $MIRROR_REGISTRY_OIDC_CLIENT_SECRET.")
+	installCmd.Flags().StringSliceVarP(&oidcScopes, "oidcScopes", "", []string{"openid"}, "Comma separated scopes Quay requests from the OIDC provider. This defaults to openid")
+	installCmd.Flags().StringVarP(&oidcCA, "oidcCA", "", "", "The path of the PEM CA bundle that signed the certificate of the OIDC provider, if Quay does not trust it already")
+	installCmd.Flags().StringVarP(&oidcServiceName, "oidcServiceName", "", "Single Sign-On", "The name of the OIDC provider shown on the Quay login page. This defaults to Single Sign-On")
+	installCmd.Flags().BoolVarP(&resetInitPassword, "resetInitPassword", "", false, "Set a new password for the init user when re-running install against an existing install. Without it the password of the original install is kept.")
 	installCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The value to set SERVER_HOSTNAME in the Quay config.yaml. This defaults to <targetHostname>:8443")
 
+	installCmd.Flags().StringVarP(&httpProxy, "httpProxy", "", "", "The proxy Quay uses for outgoing HTTP connections, e.g. for repository mirroring (e.g. http://proxy.example.com:3128)")
+	installCmd.Flags().StringVarP(&httpsProxy, "httpsProxy", "", "", "The proxy Quay uses for outgoing HTTPS connections, e.g. for repository mirroring (e.g. http://proxy.example.com:3128)")
+	installCmd.Flags().StringVarP(&noProxy, "noProxy", "", "", "Comma separated list of hosts Quay connects to without a proxy. localhost, 127.0.0.1 and the quayHostname are always added.")
+
+	installCmd.Flags().StringVarP(&pgHost, "pgHost", "", "", "The host of an existing PostgreSQL server to use instead of deploying the bundled Postgres container")
+	installCmd.Flags().IntVarP(&pgPort, "pgPort", "", 5432, "The port of the external PostgreSQL server. This defaults to 5432")
+	installCmd.Flags().StringVarP(&pgUser, "pgUser", "", "", "The user Quay connects to the external PostgreSQL server with")
+	installCmd.Flags().StringVarP(&pgPassword, "pgPassword", "", "", "The password of --pgUser. Can also be set with $MIRROR_REGISTRY_PG_PASSWORD.")
+	installCmd.Flags().StringVarP(&pgDatabase, "pgDatabase", "", "quay", "The database Quay uses on the external PostgreSQL server. This defaults to quay")
+
+	installCmd.Flags().StringVarP(&redisHost, "redisHost", "", "", "The host of an existing Redis server to use instead of deploying the bundled Redis container")
+	installCmd.Flags().IntVarP(&redisPort, "redisPort", "", 6379, "The port of the external Redis server. This defaults to 6379")
+	installCmd.Flags().StringVarP(&redisPassword, "redisPassword", "", "", "The password of the external Redis server. Can also be set with $MIRROR_REGISTRY_REDIS_PASSWORD.")
+
+	installCmd.Flags().BoolVarP(&acme, "acme", "", false, "Obtain a trusted certificate for the quayHostname from an ACME CA such as Let's Encrypt and renew it with a timer on the target.")
+	installCmd.Flags().StringVarP(&acmeEmail, "acmeEmail", "", "", "The contact email address of the ACME account.")
+	installCmd.Flags().StringVarP(&acmeServer, "acmeServer", "", "letsencrypt", "The ACME CA, as a directory URL or an acme.sh short name. This defaults to letsencrypt")
+	installCmd.Flags().StringVarP(&acmeDNSProvider, "acmeDNSProvider", "", "", "The acme.sh DNS API to answer a DNS-01 challenge with, e.g. dns_cf. This defaults to an HTTP-01 challenge on port 80 of the target")
+	installCmd.Flags().StringVarP(&acmeEnvFile, "acmeEnvFile", "", "", "The path of an env file with the credentials of --acmeDNSProvider, e.g. CF_Token=...")
+	installCmd.Flags().StringVarP(&acmeImage, "acmeImage", "", "docker.io/neilpang/acme.sh:latest", "The acme.sh image run on the target. This defaults to docker.io/neilpang/acme.sh:latest")
+	installCmd.Flags().BoolVarP(&withClair, "with-clair", "", false, "Deploy the Clair security scanner alongside Quay to scan images for vulnerabilities. Clair stores its data in the bundled Postgres.")
+	installCmd.Flags().StringVarP(&quayImage, "quayImage", "", quayImage, "The Quay image to deploy, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	installCmd.Flags().StringVarP(&redisImage, "redisImage", "", redisImage, "The Redis image to deploy, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	installCmd.Flags().StringVarP(&postgresImage, "postgresImage", "", postgresImage, "The Postgres image to deploy, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	installCmd.Flags().StringVarP(&clairImage, "clairImage", "", clairImage, "The Clair image deployed with --with-clair")
+	installCmd.Flags().BoolVarP(&withMonitoring, "with-monitoring", "", false, "Expose Prometheus metrics of Quay on port 9091 and of the bundled Postgres and Redis on ports 9187 and 9121 of the target.")
+	installCmd.Flags().BoolVarP(&monitoringStack, "monitoringStack", "", false, "Also deploy a Prometheus and Grafana pod scraping the metrics of --with-monitoring, with Grafana on port 3000 of the target and dashboards of the registry provisioned.")
+	installCmd.Flags().StringVarP(&postgresExporterImage, "postgresExporterImage", "", "quay.io/prometheuscommunity/postgres-exporter:latest", "The Postgres exporter image deployed with --with-monitoring. This defaults to quay.io/prometheuscommunity/postgres-exporter:latest")
+	installCmd.Flags().StringVarP(&redisExporterImage, "redisExporterImage", "", "docker.io/oliver006/redis_exporter:latest", "The Redis exporter image deployed with --with-monitoring. This defaults to docker.io/oliver006/redis_exporter:latest")
+	installCmd.Flags().StringVarP(&prometheusImage, "prometheusImage", "", "quay.io/prometheus/prometheus:latest", "The Prometheus image deployed with --monitoringStack. This defaults to quay.io/prometheus/prometheus:latest")
+	installCmd.Flags().StringVarP(&grafanaImage, "grafanaImage", "", "docker.io/grafana/grafana:latest", "The Grafana image deployed with --monitoringStack. This defaults to docker.io/grafana/grafana:latest")
+
+	installCmd.Flags().BoolVarP(&haMode, "ha", "", false, "Install Quay on the quay hosts of --inventory behind an HAProxy load balancer. Requires --pgHost, --redisHost, --sslCert/--sslKey and S3 compatible storage.")
+	installCmd.Flags().StringVarP(&haInventory, "inventory", "", "", "The path of the YAML ansible inventory of an HA install, with quay and loadbalancer groups and the s3_* storage vars")
+	installCmd.Flags().StringVarP(&haproxyImage, "haproxyImage", "", "docker.io/library/haproxy:lts", "The HAProxy image run on the load balancer of an HA install. This defaults to docker.io/library/haproxy:lts")
+
 	installCmd.Flags().StringVarP(&imageArchivePath, "image-archive", "i", "", "An archive containing images")
+	installCmd.Flags().BoolVarP(&verifySignatures, "verify-signatures", "", false, "Verify the cosign signatures of the images against --signatureKey before deploying them: the detached signature of the image archive, or the images in their registries when there is none. Requires cosign on the control host.")
+	installCmd.Flags().StringVarP(&signatureKey, "signatureKey", "", "", "The path of the cosign public key --verify-signatures checks the images against. This defaults to redhat-release.pub in the directory of the mirror-registry binary")
+	installCmd.Flags().BoolVarP(&onlineInstall, "online", "", false, "Pull the images on the target instead of loading them from the image archive, even if one is next to the binary.")
+	installCmd.Flags().StringVarP(&pullSecret, "pullSecret", "", "", "The path of the pull secret the target pulls the images of an --online install with, e.g. from console.redhat.com")
 	installCmd.Flags().BoolVarP(&askBecomePass, "askBecomePass", "", false, "Whether or not to ask for sudo password during SSH connection.")
+	installCmd.Flags().StringVarP(&runtimeName, "runtime", "", "", "The container engine running the execution environment on the control host, podman or docker. Quay always runs on podman on the target. This defaults to podman if installed, docker otherwise")
+	installCmd.Flags().StringVarP(&targetArch, "arch", "", "", "The CPU architecture of the target, amd64, arm64, ppc64le or s390x, selecting the image archive image-archive-<arch>.tar next to the binary. This defaults to the architecture detected on the target")
+	installCmd.Flags().StringVarP(&networkMode, "networkMode", "", "host", "The network mode of the ansible-runner container (host, slirp4netns or bridge). This defaults to host")
+	installCmd.Flags().IntVarP(&ansibleTimeout, "ansibleTimeout", "", 10, "Seconds ansible waits for SSH connections and privilege escalation prompts. For high-latency links (e.g. satellite) 60 is recommended. This defaults to 10")
+	installCmd.Flags().IntVarP(&sshConnectTimeout, "sshConnectTimeout", "", 0, "Seconds SSH waits to establish a connection. For high-latency links 30 is recommended. This defaults to --ansibleTimeout")
+	installCmd.Flags().StringVarP(&sshControlPersist, "sshControlPersist", "", "60s", "How long idle SSH control connections are kept open (e.g. 60s, 10m). For high-latency links 10m is recommended to avoid reconnecting. This defaults to 60s")
+	installCmd.Flags().IntVarP(&retries, "retries", "", 3, "How many times SSH connections to the target and loading the execution environment are retried after a transient failure, e.g. an unreachable host or a DNS failure. This defaults to 3")
+	installCmd.Flags().IntVarP(&retryDelay, "retryDelay", "", 5, "Seconds before the first retry, doubled for every further one. This defaults to 5")
+	installCmd.Flags().StringVarP(&podNetworkMode, "podNetworkMode", "", "", "The network mode of the Quay pod on the target (host, slirp4netns or bridge). In host mode Quay listens on port 8443 directly. This defaults to the podman default")
+	installCmd.Flags().BoolVarP(&usePodmanSecrets, "usePodmanSecrets", "", false, "Pass the Postgres and Redis passwords to the containers as podman secrets instead of environment variables. Requires podman 3.1 on the target, older versions fall back to environment variables.")
 	installCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
 	installCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder where quay persistent storage data is saved. This defaults to a Podman named volume 'quay-storage'. Root is required to uninstall.")
+	installCmd.Flags().StringVarP(&storageBackend, "storage", "", "local", "Where Quay stores image blobs, local for --quayStorage, s3 for a bucket of S3 compatible object storage such as MinIO, ODF or AWS S3, azure for Azure Blob storage or gcs for Google Cloud Storage. This defaults to local")
+	installCmd.Flags().StringVarP(&s3Endpoint, "s3-endpoint", "", "", "The URL of the S3 compatible object storage of --storage s3, e.g. https://minio.example.com:9000")
+	installCmd.Flags().StringVarP(&s3Bucket, "s3-bucket", "", "", "The existing bucket Quay stores image blobs in with --storage s3")
+	installCmd.Flags().StringVarP(&s3AccessKey, "s3-access-key", "", "", "The access key of the S3 compatible object storage")
+	installCmd.Flags().StringVarP(&s3SecretKey, "s3-secret-key", "", "", "The secret key of --s3-access-key. Can also be set with $"+s3SecretKeyEnv)
+	installCmd.Flags().StringVarP(&s3Region, "s3-region", "", "us-east-1", "The region of the bucket, used to sign requests and by Quay for AWS S3. This defaults to us-east-1")
+	installCmd.Flags().StringVarP(&azureAccountName, "azure-account-name", "", "", "The Azure storage account of --storage azure")
+	installCmd.Flags().StringVarP(&azureAccountKey, "azure-account-key", "", "", "An access key of --azure-account-name. Can also be set with $"+azureAccountKeyEnv)
+	installCmd.Flags().StringVarP(&azureContainer, "azure-container", "", "", "The existing blob container Quay stores image blobs in with --storage azure")
+	installCmd.Flags().StringVarP(&gcsBucket, "gcs-bucket", "", "", "The existing Google Cloud Storage bucket Quay stores image blobs in with --storage gcs")
+	installCmd.Flags().StringVarP(&gcsAccessKey, "gcs-access-key", "", "", "The access ID of a Google Cloud Storage HMAC key")
+	installCmd.Flags().StringVarP(&gcsSecretKey, "gcs-secret-key", "", "", "The secret of --gcs-access-key. Can also be set with $"+gcsSecretKeyEnv)
 	installCmd.Flags().StringVarP(&pgStorage, "pgStorage", "", "pg-storage", "The folder where postgres persistent storage data is saved. This defaults to a Podman named volume 'pg-storage'. Root is required to uninstall.")
+	installCmd.Flags().StringVarP(&pgTuningProfile, "pgTuningProfile", "", "", "A preset of Postgres settings for heavy mirroring workloads, small, medium or large for targets with about 4, 16 or 64 GiB of memory. This defaults to the settings of the Postgres image")
+	installCmd.Flags().IntVarP(&pgMaxConnections, "pgMaxConnections", "", 0, "The max_connections of the bundled Postgres, overriding --pgTuningProfile.")
+	installCmd.Flags().StringVarP(&pgSharedBuffers, "pgSharedBuffers", "", "", "The shared_buffers of the bundled Postgres, e.g. 1GB, overriding --pgTuningProfile.")
+	installCmd.Flags().StringVarP(&pgEffectiveCacheSize, "pgEffectiveCacheSize", "", "", "The effective_cache_size of the bundled Postgres, e.g. 4GB, overriding --pgTuningProfile.")
+	installCmd.Flags().StringVarP(&pgWorkMem, "pgWorkMem", "", "", "The work_mem of the bundled Postgres, e.g. 8MB, overriding --pgTuningProfile.")
+	installCmd.Flags().StringVarP(&pgMaintenanceWorkMem, "pgMaintenanceWorkMem", "", "", "The maintenance_work_mem of the bundled Postgres, e.g. 256MB, overriding --pgTuningProfile.")
 	installCmd.Flags().StringVarP(&additionalArgs, "additionalArgs", "", "", "Additional arguments you would like to append to the ansible-playbook call. Used mostly for development.")
+	installCmd.Flags().StringVarP(&logFile, "logfile", "", "", "The file the full playbook output is saved to, kept for troubleshooting. This defaults to ~/.mirror-registry/logs/<timestamp>.log")
+	installCmd.Flags().DurationVarP(&playbookTimeout, "timeout", "", 0, "How long the playbook may run, e.g. 90m. A playbook still running then is killed and the task it hung in is reported. This defaults to no limit")
+	installCmd.Flags().StringVarP(&quayLogLevel, "quayLogLevel", "", "INFO", "The log level of the Quay application (DEBUG, INFO or WARNING). This defaults to INFO")
+	installCmd.Flags().StringVarP(&defaultOrgQuota, "defaultOrgQuota", "", "", "The storage quota of every organization and user without a quota of its own, e.g. 200Gi. Pushes beyond it are rejected.")
+	installCmd.Flags().BoolVarP(&quotaBackfill, "quotaBackfill", "", true, "Whether or not Quay counts content pushed before quotas were enabled. This defaults to true")
+	installCmd.Flags().StringArrayVarP(&orgQuotas, "orgQuota", "", nil, "Create an organization with a storage quota after install, as name=size (e.g. team-a=500Gi). Sizes accept Ki, Mi, Gi, Ti and Pi suffixes. Can be repeated, and re-applied on re-runs.")
+	installCmd.Flags().StringArrayVarP(&organizations, "organization", "", nil, "Create an organization after install. Can be repeated.")
+	installCmd.Flags().StringArrayVarP(&repositories, "repository", "", nil, "Create a repository after install, as org/repo or org/repo=public|private. Its organization is created if needed. Repositories are private unless stated otherwise. Can be repeated.")
+	installCmd.Flags().StringVarP(&authMode, "auth", "", "database", "How users log into Quay, database, ldap or oidc. This defaults to database")
+	installCmd.Flags().StringVarP(&ldapURI, "ldapURI", "", "", "The URI of the LDAP server, e.g. ldaps://ldap.example.com. Requires --auth ldap.")
+	installCmd.Flags().StringVarP(&ldapBindDN, "ldapBindDN", "", "", "The DN Quay binds as to search the directory, e.g. uid=quay,ou=services,dc=example,dc=com")
+	installCmd.Flags().StringVarP(&ldapBindPassword, "ldapBindPassword", "", "", "The password of --ldapBindDN. Can also be set with $MIRROR_REGISTRY_LDAP_BIND_PASSWORD.")
+	installCmd.Flags().StringVarP(&ldapBaseDN, "ldapBaseDN", "", "", "The DN below which users are searched, e.g. ou=people,dc=example,dc=com")
+	installCmd.Flags().StringVarP(&ldapUserFilter, "ldapUserFilter", "", "", "An LDAP filter users must match to log in, e.g. (memberOf=cn=quay-users,ou=groups,dc=example,dc=com)")
+	installCmd.Flags().StringVarP(&ldapUIDAttr, "ldapUidAttr", "", "uid", "The attribute holding the username. This defaults to uid")
+	installCmd.Flags().StringVarP(&ldapEmailAttr, "ldapEmailAttr", "", "mail", "The attribute holding the email address. This defaults to mail")
+	installCmd.Flags().StringVarP(&smtpHost, "smtpHost", "", "", "The mail server Quay sends notifications and password reset emails through. Requires --smtpSender.")
+	installCmd.Flags().IntVarP(&smtpPort, "smtpPort", "", 587, "The port of the mail server. This defaults to 587")
+	installCmd.Flags().StringVarP(&smtpUser, "smtpUser", "", "", "The user Quay authenticates to the mail server with. If not set, Quay sends without authentication")
+	installCmd.Flags().StringVarP(&smtpPassword, "smtpPassword", "", "", "The password of --smtpUser. Can also be set with $MIRROR_REGISTRY_SMTP_PASSWORD.")
+	installCmd.Flags().StringVarP(&smtpSender, "smtpSender", "", "", "The address Quay sends emails from, e.g. quay@example.com")
+	installCmd.Flags().BoolVarP(&smtpTLS, "smtpTLS", "", true, "Whether or not Quay uses STARTTLS with the mail server. This defaults to true")
+	installCmd.Flags().BoolVarP(&userCreation, "userCreation", "", true, "Whether or not users can create their own accounts in Quay. This defaults to true")
+	installCmd.Flags().BoolVarP(&anonymousAccess, "anonymousAccess", "", true, "Whether or not public repositories can be pulled without logging in. This defaults to true")
+	installCmd.Flags().BoolVarP(&repoMirror, "repoMirror", "", true, "Whether or not repositories can mirror an external registry. This defaults to true")
+	installCmd.Flags().BoolVarP(&proxyCache, "proxyCache", "", false, "Whether or not organizations can act as a pull-through cache of an external registry.")
+	installCmd.Flags().StringArrayVarP(&proxyCaches, "proxy-cache", "", nil, "Configure an organization as a pull-through cache of an upstream registry after install, as upstream=registry[,org=name][,username=user,password=password][,expiration=24h][,insecure=true]. Implies --proxyCache. Can be repeated.")
+	installCmd.Flags().StringSliceVarP(&superUsers, "superusers", "", nil, "Comma separated users granted superuser rights in addition to the init user, e.g. LDAP or OIDC users that administer Quay.")
+	installCmd.Flags().StringSliceVarP(&extraUsers, "users", "", nil, "Create additional Quay users after install, as comma separated user:password pairs (e.g. team-a:secret1,team-b:secret2). Re-runs reset their passwords to the given ones.")
+	installCmd.Flags().BoolVarP(&createAPIToken, "createApiToken", "", false, "Create an OAuth application and an access token with admin scopes for API automation after install. The token is stored in the credentials file.")
+	installCmd.Flags().BoolVarP(&ipv6Mode, "ipv6", "", false, "Publish Quay on IPv6 for targets with IPv6 connectivity only. IPv6 literals in --targetHostname and --quayHostname are supported without it, with the port written as [fd00::1]:8443")
+	installCmd.Flags().BoolVarP(&fipsMode, "fips", "", false, "Install for a target running in FIPS mode. The target is checked, Quay is configured for FIPS and Postgres stores passwords with SCRAM-SHA-256.")
+	installCmd.Flags().BoolVarP(&rollbackOnFailure, "rollback-on-failure", "", false, "Remove the services, containers, quayRoot and storage the install playbook created when it fails. Not applied when the target already had a Quay install.")
+	installCmd.Flags().BoolVarP(&resumeInstall, "resume", "", false, "Skip the phases an interrupted install of the same target with the same options completed, such as loading the execution environment and transferring the images.")
+	installCmd.Flags().BoolVarP(&forceUnlock, "force-unlock", "", false, "Remove the locks a previous run left on the control host and the target, e.g. after it was killed. Only use it when no other run is active.")
+	installCmd.Flags().BoolVarP(&skipHealthcheck, "skip-healthcheck", "", false, "Report the install as successful when the playbook finishes, without waiting for Quay to serve requests.")
+	installCmd.Flags().IntVarP(&healthcheckTimeout, "healthcheckTimeout", "", 300, "The number of seconds to wait after the playbook for Quay to report healthy and accept a login of the init user. This defaults to 300")
+	installCmd.Flags().BoolVarP(&interactiveInstall, "interactive", "", false, "Prompt for the target, SSH, storage, TLS and init user options, then show the plan and ask to proceed. Options given as flags or in --config are the defaults.")
+	installCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Print the checks and the playbook command that would run, without loading the execution environment or connecting to the target.")
+	installCmd.Flags().StringVarP(&reportFile, "reportFile", "", "", "The path of the install report. A .yaml/.yml extension writes YAML, anything else JSON. This defaults to ~/.mirror-registry/logs/<timestamp>-install-report.json")
 
 }
 
-func install() {
+func install(flags *pflag.FlagSet) {
 
 	var err error
 	log.Printf("Install has begun")
 
+	if installConfigFile != "" {
+		err = applyConfigFile(flags, installConfigFile)
+		check(err)
+	}
+
+	if interactiveInstall {
+		err = runInstallWizard(flags)
+		check(err)
+	}
+
 	log.Debug("Ansible Execution Environment Image: " + eeImage)
 	log.Debug("Pause Image: " + pauseImage)
 	log.Debug("Quay Image: " + quayImage)
 	log.Debug("Redis Image: " + redisImage)
 	log.Debug("Postgres Image: " + postgresImage)
+	log.Debug("Clair Image: " + clairImage)
 
-	// Load execution environment
-	err = loadExecutionEnvironment()
+	// An HA install runs the installer's own checks against the first quay host of the inventory
+	if haMode {
+		err = validateHA()
+		check(err)
+		topology, err := loadHAInventory()
+		check(err)
+		targetHostname = topology.Target
+		if user := stringVar(topology.Vars, "ansible_user"); user != "" {
+			targetUsername = user
+		}
+		if quayHostname == "" {
+			quayHostname = withPort(topology.LoadBalancer, "8443")
+		}
+		log.Infof("Installing Quay on %s behind the load balancer %s", strings.Join(topology.Quay, ", "), topology.LoadBalancer)
+	}
+
+	err = checkContainerizedExecution()
+	check(err)
+
+	err = validateNetworkModes()
+	check(err)
+
+	err = loadContainerRuntime()
+	check(withExitCode(exitPodman, err))
+
+	err = validateAnsibleConnection()
+	check(err)
+
+	// Certificate renewal only applies to the generated self-signed certificate
+	if enableCertAutorenew && (sslCert != "" || sslKey != "") {
+		check(errors.New("--enable-cert-autorenew cannot be used with a user-provided certificate (--sslCert/--sslKey)"))
+	}
+	if rollbackOnFailure && haMode {
+		check(errors.New("--rollback-on-failure cannot be used with --ha"))
+	}
+
+	quotas, err := parseOrgQuotas(orgQuotas)
+	check(err)
+	quotaExtraVars, err := quotaVars(quotas)
+	check(err)
+	orgs, err := parseOrganizations(organizations)
+	check(err)
+	repos, err := parseRepositories(repositories)
+	check(err)
+	users, err := parseUsers(extraUsers)
+	check(err)
+	caches, err := parseProxyCaches(proxyCaches)
+	check(err)
+	if len(caches) > 0 {
+		proxyCache = true
+	}
+	superUserExtraVars, err := superUserVars()
+	check(err)
+
+	quayLogLevel, err = validateLogLevel(quayLogLevel)
+	check(err)
+
+	// Secrets are handed to the playbook through the environment to keep them out of the command line
+	secretEnv, err := loadSecretKeys()
+	check(err)
+
+	err = loadInitPassword()
+	check(err)
+
+	err = validateOIDC()
+	check(err)
+	if oidcClientSecret != "" {
+		secretEnv[oidcClientSecretEnv] = oidcClientSecret
+	}
+
+	err = validateLDAP()
+	check(err)
+	if ldapBindPassword != "" {
+		secretEnv[ldapBindPasswordEnv] = ldapBindPassword
+	}
+
+	err = validateSMTP()
+	check(err)
+	if smtpPassword != "" {
+		secretEnv[smtpPasswordEnv] = smtpPassword
+	}
+
+	err = validateExternalPostgres()
+	check(err)
+
+	tuning, err := resolvePGTuning()
+	check(err)
+
+	err = validateClair()
+	check(err)
+
+	err = validateMonitoring()
+	check(err)
+	var grafanaAdminPassword string
+	if monitoringStack {
+		grafanaAdminPassword, err = grafanaPassword()
+		check(err)
+		registerSecret(grafanaAdminPassword)
+		secretEnv[grafanaPasswordEnv] = grafanaAdminPassword
+	}
+	if pgHost != "" {
+		secretEnv[pgPasswordEnv] = pgPassword
+	}
+
+	err = validateExternalRedis()
+	check(err)
+	if redisPassword != "" {
+		secretEnv[redisPasswordEnv] = redisPassword
+	}
+	if haMode && os.Getenv(s3SecretKeyEnv) != "" {
+		secretEnv[s3SecretKeyEnv] = os.Getenv(s3SecretKeyEnv)
+	}
+
+	err = validateStorage()
+	check(err)
+	if name, value := storageSecretEnv(); name != "" {
+		secretEnv[name] = value
+	}
+
+	// Record the install in a report, including when it fails
+	if reportFile == "" {
+		reportFile = defaultReportFile()
+	}
+	report := newInstallReport(flags)
+	if !dryRun {
+		exitHooks = append(exitHooks, report.finish)
+	}
+
+	// Track the completed phases so an interrupted install can be resumed
+	state, err := loadInstallState(targetHostname, installFingerprint(flags))
 	check(err)
 
+	// Load execution environment
+	report.startPhase("load-execution-environment")
+	if state.done("execution-environment") && eeRuntime.imageExists(eeImage) {
+		log.Info("Skipping loading the execution environment, the interrupted install completed it")
+	} else if !skipForDryRun("load the execution environment " + eeImage) {
+		err = loadExecutionEnvironment()
+		check(withExitCode(exitPodman, err))
+		err = state.complete("execution-environment")
+		check(err)
+	}
+
 	// Set quayHostname if not already set
 	if quayHostname == "" {
-		quayHostname = targetHostname + ":8443"
+		quayHostname = withPort(targetHostname, "8443")
 	}
+	err = validateQuayHostname()
+	check(err)
+
+	err = validateProxies()
+	check(err)
+	if httpProxy != "" {
+		secretEnv[httpProxyEnv] = httpProxy
+	}
+	if httpsProxy != "" {
+		secretEnv[httpsProxyEnv] = httpsProxy
+	}
+
+	err = validateACME()
+	check(err)
+
+	err = validateFIPS()
+	check(err)
+
+	err = validateOnline()
+	check(err)
+
+	overriddenImages, err := validateImageOverrides(flags)
+	check(err)
 
 	// Load the SSL certificate and the key
-	err = loadCerts(sslCert, sslKey, strings.Split(quayHostname, ":")[0], sslCheckSkip)
+	report.startPhase("load-certificates")
+	err = loadCerts(sslCert, sslKey, hostOnly(quayHostname), sslCheckSkip)
 	check(err)
 
 	// Check that SSH key is present, and generate if not
-	err = loadSSHKeys()
+	report.startPhase("load-ssh-keys")
+	if !skipForDryRun("check the SSH key " + sshKey + " and generate it for local installs if missing") {
+		err = loadSSHKeys()
+		check(withExitCode(exitSSH, err))
+	}
+
+	// Keep other runs off the control host and the target until this one is done
+	if !skipForDryRun("lock the control host and " + targetHostname) {
+		release, err := acquireLock("install")
+		check(err)
+		defer release()
+	}
+
+	// The images have to match the architecture of the target
+	err = detectTargetArch()
 	check(err)
 
+	// Check the container runtime on the target
+	report.startPhase("preflight")
+	if !skipForDryRun("check cgroups, podman and the OCI runtime on " + targetHostname) {
+		runtime, err := gatherRuntimeFacts()
+		check(err)
+		report.Runtime = &runtime
+		err = checkRuntimeCompatibility(runtime)
+		check(withExitCode(exitPreflight, err))
+		err = checkFIPSMode(runtime)
+		check(withExitCode(exitPreflight, err))
+		checkPodmanSecretsSupport(runtime)
+	}
+	if storageBackend == "local" && !skipForDryRun("check whether --quayStorage "+quayStorage+" is on NFS that is safe for Quay") {
+		err = checkNFSStorage()
+		check(withExitCode(exitPreflight, err))
+	}
+	if storageBackend != "local" && !skipForDryRun("check that "+storageDescription()+" is writable") {
+		log.Infof("Checking access to %s", storageDescription())
+		err = checkStorage()
+		check(withExitCode(exitPreflight, err))
+	}
+
+	// Detect a previous install, its init user keeps the password it was created with
+	var existing existingInstall
+	if !skipForDryRun("detect an existing install and init user on " + targetHostname + ", the plan assumes a new install") {
+		existing, err = detectExistingInstall()
+		check(err)
+	}
+	report.Existing = &existing
+	if rollbackOnFailure && existing.Config {
+		log.Warnf("%s already has a Quay install, --rollback-on-failure will not remove it if the install fails", targetHostname)
+	}
+
+	// Fail before the playbook rather than deep inside it when a port is taken
+	if !skipForDryRun("check that ports " + strings.Join(installPorts(), ", ") + " are free on " + targetHostname) {
+		err = checkPortConflicts()
+		check(withExitCode(exitPreflight, err))
+	}
+	if existing.InitUser {
+		log.Infof("Init user %s already exists on %s", initUser, targetHostname)
+		if !resetInitPassword && initPassword != "" {
+			log.Warn("Ignoring --initPassword because the init user already exists, use --resetInitPassword to change it")
+			initPassword = ""
+		}
+	}
+	// A restore brings back the users of the backed up install, and LDAP or OIDC users come from the identity provider
+	createInitUser := !existing.InitUser && restoreFrom == "" && authMode == "database"
+	keepInitPassword := !createInitUser && !resetInitPassword
+
 	// Handle Image Archive Defaulting
+	report.startPhase("load-image-archive")
 	var imageArchiveMountFlag string
-	if imageArchivePath == "" {
-		executableDir, err := os.Executable()
+	if imageArchivePath == "" && !onlineInstall {
+		defaultArchivePath, err := defaultImageArchive()
 		check(err)
-		defaultArchivePath := path.Join(path.Dir(executableDir), "image-archive.tar")
-		if pathExists(defaultArchivePath) {
+		if defaultArchivePath != "" {
 			imageArchivePath = defaultArchivePath
+			// The bundled archive holds the images of this release, overridden images are pulled instead
+			archiveSkipImages = overriddenImages
 		}
 	} else {
 		if !pathExists(imageArchivePath) {
@@ -148,10 +548,23 @@ func install() {
 		}
 	}
 
+	if verifySignatures && !skipForDryRun("verify the signatures of the images with cosign") {
+		err = verifyImageSignatures()
+		check(err)
+	}
+
 	if imageArchivePath != "" {
-		imageArchiveMountFlag = fmt.Sprintf("-v %s:/runner/image-archive.tar", imageArchivePath)
+		imageArchiveMountFlag = fmt.Sprintf("-v %s:/runner/image-archive.tar", hostMountPath(imageArchivePath))
 		log.Info("Found image archive at " + imageArchivePath)
-		if isLocalInstall() {
+		if state.done("images") {
+			log.Info("Skipping unpacking and loading the image archive, the interrupted install completed it")
+		} else if !skipForDryRun("verify the checksum of " + imageArchivePath + " and check the free disk space needed to unpack and load it") {
+			err = verifyArchive(imageArchivePath, imageArchiveDigest)
+			check(err)
+			err = checkImageArchiveDiskSpace(imageArchivePath)
+			check(withExitCode(exitPreflight, err))
+		}
+		if isLocalInstall() && !state.done("images") && !skipForDryRun("unpack "+imageArchivePath+" and load the images into local podman storage") {
 			log.Printf("Unpacking image archive from %s", imageArchivePath)
 			cmd := exec.Command("tar", "-xvf", imageArchivePath)
 			if verbose {
@@ -172,46 +585,52 @@ func install() {
 			}
 			log.Debug("Importing Pause with command: ", pauseImport)
 			err = pauseImport.Run()
-			check(err)
+			check(withExitCode(exitPodman, err))
 
 			// Load Redis image
-			redisArchivePath := path.Join(path.Dir(executableDir), "redis.tar")
-			log.Printf("Loading redis image archive from %s", redisArchivePath)
-			statement = getImageMetadata("redis", redisImage, redisArchivePath)
-			redisImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				redisImport.Stderr = os.Stderr
-				redisImport.Stdout = os.Stdout
+			if loadsFromArchive("redis") {
+				redisArchivePath := path.Join(path.Dir(executableDir), "redis.tar")
+				log.Printf("Loading redis image archive from %s", redisArchivePath)
+				statement = getImageMetadata("redis", redisImage, redisArchivePath)
+				redisImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					redisImport.Stderr = os.Stderr
+					redisImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Redis with command: ", redisImport)
+				err = redisImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Redis with command: ", redisImport)
-			err = redisImport.Run()
-			check(err)
 
 			// Load Postgres image
-			postgresArchivePath := path.Join(path.Dir(executableDir), "postgres.tar")
-			log.Printf("Loading postgres image archive from %s", postgresArchivePath)
-			statement = getImageMetadata("postgres", postgresImage, postgresArchivePath)
-			postgresImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				postgresImport.Stderr = os.Stderr
-				postgresImport.Stdout = os.Stdout
+			if loadsFromArchive("postgres") {
+				postgresArchivePath := path.Join(path.Dir(executableDir), "postgres.tar")
+				log.Printf("Loading postgres image archive from %s", postgresArchivePath)
+				statement = getImageMetadata("postgres", postgresImage, postgresArchivePath)
+				postgresImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					postgresImport.Stderr = os.Stderr
+					postgresImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Postgres with command: ", postgresImport)
+				err = postgresImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Postgres with command: ", postgresImport)
-			err = postgresImport.Run()
-			check(err)
 
 			// Load Quay image
-			quayArchivePath := path.Join(path.Dir(executableDir), "quay.tar")
-			log.Printf("Loading Quay image archive from %s", quayArchivePath)
-			statement = getImageMetadata("quay", quayImage, quayArchivePath)
-			quayImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				quayImport.Stderr = os.Stderr
-				quayImport.Stdout = os.Stdout
+			if loadsFromArchive("quay") {
+				quayArchivePath := path.Join(path.Dir(executableDir), "quay.tar")
+				log.Printf("Loading Quay image archive from %s", quayArchivePath)
+				statement = getImageMetadata("quay", quayImage, quayArchivePath)
+				quayImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					quayImport.Stderr = os.Stderr
+					quayImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Quay with command: ", quayImport)
+				err = quayImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Quay with command: ", quayImport)
-			err = quayImport.Run()
-			check(err)
 		}
 		log.Infof("Attempting to set SELinux rules on image archive")
 		cmd := exec.Command("chcon", "-Rt", "svirt_sandbox_file_t", imageArchivePath)
@@ -224,21 +643,22 @@ func install() {
 		}
 	}
 
-	// Generate password if none provided
-	if initPassword == "" {
+	// Generate password if none provided, unless the existing init user keeps its password
+	if initPassword == "" && !keepInitPassword {
 		initPassword, err = password.Generate(32, 10, 0, false, false)
 		check(err)
 	}
+	if initPassword != "" {
+		secretEnv[initPasswordEnv] = initPassword
+	}
 
 	// Set quayHostname if not already set
 	if quayHostname == "" {
-		quayHostname = targetHostname + ":8443"
+		quayHostname = withPort(targetHostname, "8443")
 	}
 
 	// Add port if not present
-	if !strings.Contains(quayHostname, ":") {
-		quayHostname = quayHostname + ":8443"
-	}
+	quayHostname = withPort(quayHostname, "8443")
 
 	// Set askBecomePass flag if true
 	var askBecomePassFlag string
@@ -257,38 +677,336 @@ func install() {
 		if err != nil {
 			check(errors.New("Unable to get absolute path of " + sslKey))
 		}
-		sslCertKeyFlag = fmt.Sprintf(" -v %s:/runner/certs/quay.cert:Z -v %s:/runner/certs/quay.key:Z", sslCertAbs, sslKeyAbs)
+		sslCertKeyFlag = fmt.Sprintf(" -v %s:/runner/certs/quay.cert:Z -v %s:/runner/certs/quay.key:Z", hostMountPath(sslCertAbs), hostMountPath(sslKeyAbs))
+	}
+
+	// Collect files written by the playbook, such as the init user access token
+	outputDir, err := ioutil.TempDir("", "mirror-registry-output")
+	check(err)
+	defer keepOnFailure(outputDir, "the files written by the playbook")()
+
+	inventoryMountFlag, err := haInventoryMountFlag()
+	check(err)
+	oidcCAMountFlag, err := oidcMountFlag()
+	check(err)
+	acmeEnvMountFlag, err := acmeMountFlag()
+	check(err)
+	pullSecretFlag, err := pullSecretMountFlag()
+	check(err)
+	playbook := "install_mirror_appliance.yml"
+	if haMode {
+		playbook = "install_ha_mirror_appliance.yml"
 	}
 
+	// Mount the SSH key in a way the local podman can read it, or pass the SSH password
+	sshPodmanFlags, sshAnsibleFlags, cleanup, err := sshAuthFlags()
+	check(err)
+	defer cleanup()
+
 	// Run playbook
-	log.Printf("Running install playbook. This may take some time. To see playbook output run the installer with -v (verbose) flag.")
+	report.startPhase("playbook")
+	log.Printf("Running install playbook. This may take some time. To see the full playbook output run the installer with -v (verbose) flag.")
 	quayVersion := strings.Split(quayImage, ":")[1]
-	podmanCmd := fmt.Sprintf(`podman run `+
+	pinned := pinImages()
+	report.recordImages(pinned)
+	podmanCmd := fmt.Sprintf(eeRuntime.name()+` run `+
 		`--rm --interactive --tty `+
 		`--workdir /runner/project `+
-		`--net host `+
+		fmt.Sprintf("--net %s ", networkMode)+
 		imageArchiveMountFlag+ // optional image archive flag
 		sslCertKeyFlag+ // optional ssl cert/key flag
-		` -v %s:/runner/env/ssh_key `+
+		inventoryMountFlag+ // optional HA inventory flag
+		acmeEnvMountFlag+ // optional ACME DNS provider credentials flag
+		oidcCAMountFlag+ // optional OIDC provider CA flag
+		pullSecretFlag+ // optional pull secret flag
+		fmt.Sprintf(" -v %s:/runner/output:Z", hostMountPath(outputDir))+
+		` %s`+
 		`-e RUNNER_OMIT_EVENTS=False `+
 		`-e RUNNER_ONLY_FAILED_EVENTS=False `+
 		`-e ANSIBLE_HOST_KEY_CHECKING=False `+
 		`-e ANSIBLE_CONFIG=/runner/project/ansible.cfg `+
+		ansibleConnectionEnv()+
+		secretEnvFlags(secretEnv)+
 		fmt.Sprintf("-e ANSIBLE_NOCOLOR=%t ", noColor)+
 		`--quiet `+
 		`--name ansible_runner_instance `+
 		fmt.Sprintf("%s ", eeImage)+
-		`ansible-playbook -i %s@%s, --private-key /runner/env/ssh_key -e "init_user=%s init_password=%s quay_image=%s quay_version=%s redis_image=%s postgres_image=%s pause_image=%s quay_hostname=%s local_install=%s quay_root=%s quay_storage=%s pg_storage=%s" install_mirror_appliance.yml %s %s`,
-		sshKey, targetUsername, targetHostname, initUser, initPassword, quayImage, quayVersion, redisImage, postgresImage, pauseImage, quayHostname, strconv.FormatBool(isLocalInstall()), quayRoot, quayStorage, pgStorage, askBecomePassFlag, additionalArgs)
+		`ansible-playbook -i %s %s -e "init_user=%s create_init_user=%t quay_image=%s quay_version=%s redis_image=%s postgres_image=%s pause_image=%s quay_hostname=%s local_install=%s quay_root=%s quay_storage=%s pg_storage=%s enable_cert_autorenew=%t pod_network_mode=%s use_podman_secrets=%t quay_log_level=%s oidc_issuer=%s oidc_client_id=%s oidc_service_name='%s' quay_no_proxy=%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s" %s %s %s`,
+		sshPodmanFlags, playbookInventory(), sshAnsibleFlags, initUser, createInitUser, quayImage, quayVersion, redisImage, postgresImage, pauseImage, quayHostname, strconv.FormatBool(isLocalInstall()), quayRoot, quayStorage, pgStorage, enableCertAutorenew, podNetworkMode, usePodmanSecrets, quayLogLevel, oidcIssuer, oidcClientID, oidcServiceName, noProxy, externalPostgresVars(), externalRedisVars(), storageVars(), haVars(), clairVars(), monitoringVars(), quotaExtraVars, acmeVars(), superUserExtraVars, ldapVars(), oidcVars(), smtpVars(), fipsVars(), pgTuningVars(tuning), ipv6Vars(), onlineVars(), featureVars(), imageOverrideVars(), state.playbookVars(), playbook, askBecomePassFlag, additionalArgs)
+
+	playbookCmd := newPlaybookCommand(podmanCmd, secretEnv)
+	if dryRun {
+		printDryRunCommand(playbookCmd)
+		skipForDryRun("wait for https://" + quayHostname + " to serve requests and apply the requested organization quotas, organizations, repositories, proxy caches, users, API token and password reset")
+		return
+	}
+
+	if state.playbookDone() {
+		log.Info("Skipping the install playbook, the interrupted install completed all of its phases")
+	} else {
+		log.Debug("Running command: " + playbookCmd.String())
+		cmd := playbookCmd.exec()
+		output, closeLog, err := openPlaybookLog(playbookOutput(), playbookCmd)
+		check(err)
+		defer closeLog()
+		cmd.Stderr = os.Stderr
+		cmd.Stdout = output
+		cmd.Stdin = os.Stdin
+		err = runPlaybook(cmd)
+		// Record the phases the playbook got through, also when it failed
+		if err := state.completePlaybookPhases(outputDir); err != nil {
+			log.Warnf("Could not save the install state: %s", err.Error())
+		}
+		// Only what this install created is removed, never a previous install
+		if err != nil && rollbackOnFailure && !existing.Config {
+			rollbackInstall(output, askBecomePassFlag)
+			state.finish(nil)
+		}
+		check(withExitCode(exitPlaybook, err))
+	}
 
-	log.Debug("Running command: " + podmanCmd)
-	cmd := exec.Command("bash", "-c", podmanCmd)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	err = cmd.Run()
+	// Keep the init credentials, access token and secret keys for later API calls and reinstalls
+	credentials := map[string]string{"initUser": initUser, "quayHostname": quayHostname}
+	if !keepInitPassword {
+		credentials["initPassword"] = initPassword
+	}
+	if grafanaAdminPassword != "" {
+		credentials["grafanaPassword"] = grafanaAdminPassword
+	}
+	for file, key := range map[string]string{"init_access_token": "initAccessToken", "secret_key": "secretKey", "database_secret_key": "databaseSecretKey"} {
+		if value, err := ioutil.ReadFile(path.Join(outputDir, file)); err == nil {
+			credentials[key] = string(value)
+		}
+	}
+	_, err = saveCredentials(targetHostname, credentials)
 	check(err)
 
+	report.startPhase("health-check")
+	if skipHealthcheck {
+		log.Warn("Not waiting for Quay to serve requests, --skip-healthcheck is set")
+		report.HealthCheck = "skipped"
+	} else {
+		// Only a password set by this install is known to be current, a reset is applied through the API below
+		loginPassword := ""
+		if createInitUser {
+			loginPassword = initPassword
+		}
+		if err := waitForInstall(quayHostname, initUser, loginPassword, time.Duration(healthcheckTimeout)*time.Second); err != nil {
+			report.HealthCheck = "unhealthy: " + err.Error()
+			check(withExitCode(exitHealthcheck, fmt.Errorf("%s. Check the containers on %s with podman ps and journalctl, or rerun with --skip-healthcheck", err.Error(), targetHostname)))
+		}
+		report.HealthCheck = "healthy"
+	}
+
+	// Reset the password of the existing init user through the API
+	if existing.InitUser && resetInitPassword {
+		report.startPhase("reset-init-password")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if credentials["initAccessToken"] == "" {
+			check(errors.New("Cannot reset the init user password, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+		}
+		err = setUserPassword(newQuayAPIClient(quayHostname, credentials["initAccessToken"]), initUser, initPassword)
+		check(err)
+		_, err = saveCredentials(targetHostname, map[string]string{"initPassword": initPassword})
+		check(err)
+		log.Infof("Password of init user %s was reset", initUser)
+	}
+
+	// Apply organization quotas through the API
+	if len(quotas) > 0 {
+		report.startPhase("org-quotas")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if credentials["initAccessToken"] == "" {
+			check(errors.New("Cannot apply organization quotas, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+		}
+		api := newQuayAPIClient(quayHostname, credentials["initAccessToken"])
+		var failed []string
+		for _, quota := range quotas {
+			result, err := applyOrgQuota(api, quota)
+			if err != nil {
+				log.Errorf("Organization %s: %s", quota.Name, err.Error())
+				result = "failed: " + err.Error()
+				failed = append(failed, quota.Name)
+			} else {
+				log.Infof("Organization %s: %s", quota.Name, result)
+			}
+			report.OrgQuotas = append(report.OrgQuotas, orgQuotaResult{orgQuota: quota, Result: result})
+		}
+		if len(failed) > 0 {
+			check(errors.New("Failed to apply quotas for organizations: " + strings.Join(failed, ", ")))
+		}
+	}
+
+	// Create organizations and repositories through the API so mirroring can start right away
+	if len(orgs) > 0 || len(repos) > 0 {
+		report.startPhase("organizations")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if credentials["initAccessToken"] == "" {
+			check(errors.New("Cannot create organizations and repositories, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+		}
+		api := newQuayAPIClient(quayHostname, credentials["initAccessToken"])
+		var failed []string
+		for _, org := range orgs {
+			result, err := applyOrganization(api, org)
+			if err != nil {
+				log.Errorf("Organization %s: %s", org, err.Error())
+				result = "failed: " + err.Error()
+				failed = append(failed, org)
+			} else {
+				log.Infof("Organization %s: %s", org, result)
+			}
+			report.Organizations = append(report.Organizations, namespaceResult{Name: org, Result: result})
+		}
+		for _, repo := range repos {
+			name := repo.Namespace + "/" + repo.Name
+			result, err := applyRepository(api, repo)
+			if err != nil {
+				log.Errorf("Repository %s: %s", name, err.Error())
+				result = "failed: " + err.Error()
+				failed = append(failed, name)
+			} else {
+				log.Infof("Repository %s: %s", name, result)
+			}
+			report.Repositories = append(report.Repositories, namespaceResult{Name: name, Result: result})
+		}
+		if len(failed) > 0 {
+			check(errors.New("Failed to create organizations and repositories: " + strings.Join(failed, ", ")))
+		}
+	}
+
+	// Configure the proxy cache organizations through the API
+	if len(caches) > 0 {
+		report.startPhase("proxy-caches")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if credentials["initAccessToken"] == "" {
+			check(errors.New("Cannot configure proxy caches, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+		}
+		api := newQuayAPIClient(quayHostname, credentials["initAccessToken"])
+		var failed []string
+		for _, cache := range caches {
+			result, err := applyProxyCache(api, cache)
+			if err != nil {
+				log.Errorf("Proxy cache %s: %s", cache.Organization, err.Error())
+				result = "failed: " + err.Error()
+				failed = append(failed, cache.Organization)
+			} else {
+				log.Infof("Proxy cache %s: %s", cache.Organization, result)
+			}
+			report.ProxyCaches = append(report.ProxyCaches, namespaceResult{Name: cache.Organization, Result: result})
+		}
+		if len(failed) > 0 {
+			check(errors.New("Failed to configure proxy caches: " + strings.Join(failed, ", ")))
+		}
+	}
+
+	// Create the additional users through the API
+	if len(users) > 0 {
+		report.startPhase("users")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if credentials["initAccessToken"] == "" {
+			check(errors.New("Cannot create users, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+		}
+		api := newQuayAPIClient(quayHostname, credentials["initAccessToken"])
+		var failed []string
+		for _, user := range users {
+			result, err := applyUser(api, user)
+			if err != nil {
+				log.Errorf("User %s: %s", user.Name, err.Error())
+				result = "failed: " + err.Error()
+				failed = append(failed, user.Name)
+			} else {
+				log.Infof("User %s: %s", user.Name, result)
+			}
+			report.Users = append(report.Users, namespaceResult{Name: user.Name, Result: result})
+		}
+		if len(failed) > 0 {
+			check(errors.New("Failed to create users: " + strings.Join(failed, ", ")))
+		}
+	}
+
+	// Create an access token for API automation
+	if createAPIToken {
+		report.startPhase("api-token")
+		credentials, err := loadCredentials(targetHostname)
+		check(err)
+		if initPassword == "" {
+			initPassword = credentials["initPassword"]
+		}
+		if initPassword == "" {
+			check(errors.New("Cannot create an API token, the init user password is unknown. Pass it with --initPassword --resetInitPassword"))
+		}
+		clientID, err := ensureAPIApplication(newQuayAPIClient(quayHostname, credentials["initAccessToken"]))
+		check(err)
+		token, err := createAccessToken(quayHostname, initUser, initPassword, clientID, apiTokenScopes)
+		check(err)
+		file, err := saveCredentials(targetHostname, map[string]string{"apiToken": token, "apiTokenClientID": clientID})
+		check(err)
+		report.APIToken = "created for application " + apiTokenApplication + " in organization " + apiTokenOrganization
+		log.Infof("API access token stored in %s", file)
+	}
+
+	// The state of an HA install is not kept per target, so its images are not recorded
+	if !haMode {
+		if err := recordImageIDs(pinned); err != nil {
+			log.Warnf("Could not read the image IDs of the containers on %s: %s", targetHostname, err.Error())
+		}
+	}
+	report.finish(nil)
+	if haMode {
+		pinned = nil
+	}
+	state.finish(pinned)
+	recordRegistry(currentRegistry(quayVersion), false)
+
+	// Keep a copy of the init credentials on the target for get-credentials from other control hosts
+	if authMode == "database" && initPassword != "" && !haMode {
+		err = saveTargetCredentials(map[string]string{"initUser": initUser, "initPassword": initPassword, "quayHostname": quayHostname})
+		if err != nil {
+			log.Warnf("Could not store the init credentials on %s: %s", targetHostname, err.Error())
+		}
+	}
+
 	log.Printf("Quay installed successfully, config data is stored in %s", quayRoot)
-	log.Printf("Quay is available at %s with credentials (%s, %s)", "https://"+quayHostname, initUser, initPassword)
+	if authMode != "database" {
+		log.Printf("Quay is available at %s, log in with your %s credentials", "https://"+quayHostname, strings.ToUpper(authMode))
+	} else if keepInitPassword {
+		log.Printf("Quay is available at %s, credentials unchanged from the original install (user %s)", "https://"+quayHostname, initUser)
+	} else {
+		log.WithField(revealSecrets, true).Printf("Quay is available at %s with credentials (%s, %s)", "https://"+quayHostname, initUser, initPassword)
+	}
+	if monitoringStack {
+		log.Printf("Grafana is available at http://%s, log in as admin with the grafanaPassword stored in %s", withPort(hostOnly(targetHostname), "3000"), credentialsFile(targetHostname))
+	}
+}
+
+// loadInitPassword reads the init password from stdin with --initPassword-stdin or from $MIRROR_REGISTRY_INIT_PASSWORD
+// when --initPassword is not given, so automation can keep it out of shell history and the process list
+func loadInitPassword() error {
+	if initPasswordStdin {
+		if initPassword != "" {
+			return errors.New("--initPassword and --initPassword-stdin cannot be combined")
+		}
+		if askBecomePass {
+			return errors.New("--initPassword-stdin cannot be combined with --askBecomePass, which prompts on stdin")
+		}
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.New("Could not read the init password from stdin: " + err.Error())
+		}
+		initPassword = strings.TrimRight(string(data), "\r\n")
+		if initPassword == "" {
+			return errors.New("--initPassword-stdin was given but stdin is empty")
+		}
+	} else if initPassword == "" {
+		initPassword = os.Getenv(initPasswordEnv)
+	}
+	if initPassword != "" && len(initPassword) < 8 {
+		return errors.New("The init password must be at least 8 characters long")
+	}
+	return nil
 }
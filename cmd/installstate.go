@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// resumeInstall holds whether or not to skip the phases an interrupted install of the same target already completed
+var resumeInstall bool
+
+// playbookPhases are the phases of the install playbook tracked in the install state, in the order they run
+var playbookPhases = []string{"images", "services", "config"}
+
+// fingerprintIgnoredFlags are the flags that do not change what is installed, so they may differ when resuming
+var fingerprintIgnoredFlags = map[string]bool{
+	"resume":     true,
+	"dry-run":    true,
+	"logfile":    true,
+	"reportFile": true,
+	"config":     true,
+	"verbose":    true,
+	"no-color":   true,
+	"log-format": true,
+}
+
+// installState records the phases an install of a target completed, so a failed install can be resumed, and the
+// images the components were deployed with
+type installState struct {
+	Fingerprint string                   `json:"fingerprint,omitempty"`
+	Completed   []string                 `json:"completed,omitempty"`
+	Images      map[string]deployedImage `json:"images,omitempty"`
+	UpdatedAt   time.Time                `json:"updatedAt"`
+
+	host string
+}
+
+// installStateFile returns the path of the install state kept for a target host
+func installStateFile(host string) string {
+	return path.Join(os.Getenv("HOME"), ".mirror-registry", "state", hostOnly(host)+".json")
+}
+
+// installFingerprint hashes the install options, secrets excluded, to tell whether a resumed install asks for the same thing
+func installFingerprint(flags *pflag.FlagSet) string {
+	var options []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !secretFlags[f.Name] && !fingerprintIgnoredFlags[f.Name] {
+			options = append(options, f.Name+"="+f.Value.String())
+		}
+	})
+	sort.Strings(options)
+	sum := sha256.Sum256([]byte(strings.Join(options, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// readInstallState returns the install state kept for host, or nil if there is none
+func readInstallState(host string) (*installState, error) {
+	data, err := ioutil.ReadFile(installStateFile(host))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &installState{host: host}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// loadInstallState returns the state of the interrupted install of host with --resume, and an empty state otherwise.
+// The state of an install with other options is discarded, since skipping its phases would mix both installs. The
+// recorded images are kept either way.
+func loadInstallState(host, fingerprint string) (*installState, error) {
+	state := &installState{Fingerprint: fingerprint, host: host}
+	previous, err := readInstallState(host)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		state.Images = previous.Images
+	}
+	if !resumeInstall {
+		return state, nil
+	}
+	if haMode {
+		return nil, errors.New("--resume cannot be used with --ha")
+	}
+
+	if previous == nil || len(previous.Completed) == 0 {
+		log.Infof("No interrupted install of %s to resume, running all phases", host)
+		return state, nil
+	}
+	if previous.Fingerprint != fingerprint {
+		log.Warnf("The options differ from the interrupted install of %s, running all phases", host)
+		return state, nil
+	}
+	state.Completed = previous.Completed
+	log.Infof("Resuming the install of %s, completed phases: %s", host, strings.Join(state.Completed, ", "))
+	return state, nil
+}
+
+// done reports whether the phase was completed
+func (s *installState) done(phase string) bool {
+	for _, completed := range s.Completed {
+		if completed == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// complete records the phase as completed and saves the state
+func (s *installState) complete(phase string) error {
+	if s.done(phase) || dryRun {
+		return nil
+	}
+	s.Completed = append(s.Completed, phase)
+	return s.save()
+}
+
+// save writes the state to the install state file of the target
+func (s *installState) save() error {
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := installStateFile(s.host)
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// completePlaybookPhases records the phases the playbook marked as completed in its output directory
+func (s *installState) completePlaybookPhases(outputDir string) error {
+	for _, phase := range playbookPhases {
+		if pathExists(path.Join(outputDir, "phases", phase)) {
+			if err := s.complete(phase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// playbookVars returns the extra-var skipping the completed playbook phases
+func (s *installState) playbookVars() string {
+	var skipped []string
+	for _, phase := range playbookPhases {
+		if s.done(phase) {
+			skipped = append(skipped, phase)
+		}
+	}
+	if len(skipped) == 0 {
+		return ""
+	}
+	return " skip_phases=" + strings.Join(skipped, ",")
+}
+
+// playbookDone reports whether every phase of the playbook was completed
+func (s *installState) playbookDone() bool {
+	for _, phase := range playbookPhases {
+		if !s.done(phase) {
+			return false
+		}
+	}
+	return true
+}
+
+// finish records the deployed images once the install succeeded and forgets the completed phases, so the next install
+// starts from scratch. Without images the state is removed.
+func (s *installState) finish(images map[string]deployedImage) {
+	if dryRun {
+		return
+	}
+	if len(images) == 0 {
+		if err := os.Remove(installStateFile(s.host)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Could not remove the install state: %s", err.Error())
+		}
+		return
+	}
+	s.Fingerprint = ""
+	s.Completed = nil
+	s.Images = images
+	if err := s.save(); err != nil {
+		log.Warnf("Could not record the deployed images in the install state: %s", err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// authMode is how users log into Quay, either database or ldap
+var authMode string
+
+// ldapURI is the ldap:// or ldaps:// URI of the directory server
+var ldapURI string
+
+// ldapBindDN is the DN Quay binds as to search the directory
+var ldapBindDN string
+
+// ldapBindPassword is the password of ldapBindDN
+var ldapBindPassword string
+
+// ldapBaseDN is the DN below which users are searched
+var ldapBaseDN string
+
+// ldapUserFilter is an additional LDAP filter users must match to log in
+var ldapUserFilter string
+
+// ldapUIDAttr is the attribute holding the username
+var ldapUIDAttr string
+
+// ldapEmailAttr is the attribute holding the email address
+var ldapEmailAttr string
+
+// ldapBindPasswordEnv is the environment variable holding the LDAP bind password
+const ldapBindPasswordEnv = "MIRROR_REGISTRY_LDAP_BIND_PASSWORD"
+
+// validateLDAP checks the LDAP flags and that the directory server is reachable from the control host
+func validateLDAP() error {
+	if ldapBindPassword == "" {
+		ldapBindPassword = os.Getenv(ldapBindPasswordEnv)
+	}
+	switch authMode {
+	case "database", "oidc":
+		if ldapURI != "" || ldapBindDN != "" || ldapBaseDN != "" {
+			return errors.New("The --ldap flags require --auth ldap")
+		}
+		return nil
+	case "ldap":
+	default:
+		return errors.New("Invalid --auth " + authMode + ", expected database, ldap or oidc")
+	}
+
+	if ldapURI == "" || ldapBaseDN == "" {
+		return errors.New("--auth ldap requires --ldapURI and --ldapBaseDN")
+	}
+	if ldapBindDN != "" && ldapBindPassword == "" {
+		return errors.New("--ldapBindDN requires --ldapBindPassword or $" + ldapBindPasswordEnv)
+	}
+	address, err := ldapAddress()
+	if err != nil {
+		return err
+	}
+	for _, value := range []struct{ name, value string }{
+		{"--ldapBindDN", ldapBindDN},
+		{"--ldapBaseDN", ldapBaseDN},
+		{"--ldapUserFilter", ldapUserFilter},
+		{"--ldapUidAttr", ldapUIDAttr},
+		{"--ldapEmailAttr", ldapEmailAttr},
+	} {
+		if strings.ContainsAny(value.value, "\"'`$\\") {
+			return errors.New(value.name + " must not contain quotes, backslashes or $")
+		}
+	}
+	if ldapUserFilter != "" && (!strings.HasPrefix(ldapUserFilter, "(") || !strings.HasSuffix(ldapUserFilter, ")")) {
+		return errors.New("Invalid --ldapUserFilter " + ldapUserFilter + ", expected a filter in parentheses")
+	}
+
+	if err := validateExternalUsers(); err != nil {
+		return err
+	}
+
+	if skipForDryRun("test the connection to LDAP server " + address) {
+		return nil
+	}
+	log.Infof("Testing connection to LDAP server %s", address)
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("Could not connect to LDAP server %s: %s", address, err.Error())
+	}
+	conn.Close()
+	log.Info("LDAP connection check succeeded")
+	return nil
+}
+
+// ldapAddress returns the host:port of --ldapURI
+func ldapAddress() (string, error) {
+	uri, err := url.Parse(ldapURI)
+	if err != nil || (uri.Scheme != "ldap" && uri.Scheme != "ldaps") || uri.Hostname() == "" {
+		return "", errors.New("Invalid --ldapURI " + ldapURI + ", expected ldap://host[:port] or ldaps://host[:port]")
+	}
+	port := uri.Port()
+	if port == "" {
+		port = map[string]string{"ldap": "389", "ldaps": "636"}[uri.Scheme]
+	}
+	return net.JoinHostPort(uri.Hostname(), port), nil
+}
+
+// ldapVars returns the extra-vars configuring LDAP authentication, the bind password is passed through the environment
+func ldapVars() string {
+	if authMode != "ldap" {
+		return ""
+	}
+	return fmt.Sprintf(" auth_type=LDAP ldap_uri=%s ldap_admin_dn='%s' ldap_base_dn='%s' ldap_user_filter='%s' ldap_uid_attr=%s ldap_email_attr=%s", ldapURI, ldapBindDN, ldapBaseDN, ldapUserFilter, ldapUIDAttr, ldapEmailAttr)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// forceUnlock holds whether or not to remove the locks of another run before starting
+var forceUnlock bool
+
+// targetLockFile is the marker on the target naming the run operating on it
+const targetLockFile = "$HOME/.mirror-registry.lock"
+
+// lockInfo describes the run holding the lock of the control host
+type lockInfo struct {
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// localLockFile returns the path of the lock of the control host, which runs one execution environment at a time
+func localLockFile() string {
+	return path.Join(os.Getenv("HOME"), ".mirror-registry", "lock")
+}
+
+// processAlive reports whether a process with the given PID runs on the control host
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// acquireLock takes the lock of the control host and the marker on the target for operation, so a second run fails
+// instead of racing on the ansible_runner_instance container and the target. The locks are released when the command
+// exits, also when it fails, or when the returned function is called.
+func acquireLock(operation string) (func(), error) {
+	if forceUnlock {
+		log.Warnf("Removing the locks of the control host and of %s, make sure no other run is active", targetHostname)
+		if err := os.Remove(localLockFile()); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if _, err := runRemoteCommand("rm -f " + targetLockFile + "\n"); err != nil {
+			return nil, fmt.Errorf("could not remove the lock on %s: %w", targetHostname, err)
+		}
+	}
+
+	if err := acquireLocalLock(operation); err != nil {
+		return nil, err
+	}
+	releaseLocal := func() { os.Remove(localLockFile()) }
+
+	// The marker is created atomically with noclobber, it names the control host since another one may hold it
+	marker := fmt.Sprintf("%s %s %d %s", operation, getFQDN(), os.Getpid(), time.Now().Format(time.RFC3339))
+	out, err := runRemoteCommand(`if ( set -C; echo '` + marker + `' > ` + targetLockFile + ` ) 2>/dev/null; then echo acquired; else echo "held $(cat ` + targetLockFile + `)"; fi
+`)
+	if err != nil {
+		releaseLocal()
+		return nil, fmt.Errorf("could not lock %s: %w", targetHostname, err)
+	}
+	if fields := strings.Fields(out); len(fields) == 0 || fields[0] != "acquired" {
+		releaseLocal()
+		held := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(out), "held"))
+		return nil, fmt.Errorf("Another operation is in progress on %s (%s). If no other run is active, re-run with --force-unlock", targetHostname, held)
+	}
+
+	released := false
+	release := func() {
+		if released {
+			return
+		}
+		released = true
+		// Only remove the marker if it is still ours
+		if _, err := runRemoteCommand(`if [ "$(cat ` + targetLockFile + ` 2>/dev/null)" = '` + marker + `' ]; then rm -f ` + targetLockFile + `; fi
+`); err != nil {
+			log.Warnf("Could not remove the lock on %s, the next run needs --force-unlock: %s", targetHostname, err.Error())
+		}
+		releaseLocal()
+	}
+	exitHooks = append(exitHooks, func(error) { release() })
+	return release, nil
+}
+
+// acquireLocalLock creates the lock of the control host, replacing a lock whose process is gone
+func acquireLocalLock(operation string) error {
+	file := localLockFile()
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(lockInfo{Operation: operation, Target: targetHostname, PID: os.Getpid(), StartedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			defer f.Close()
+			_, err = f.Write(data)
+			return err
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		var holder lockInfo
+		content, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(content, &holder)
+		}
+		if err == nil && processAlive(holder.PID) {
+			return fmt.Errorf("Another operation is in progress: %s of %s started at %s by process %d. If no other run is active, re-run with --force-unlock", holder.Operation, holder.Target, holder.StartedAt.Format(time.RFC3339), holder.PID)
+		}
+		log.Warnf("Removing the stale lock %s of a run that is no longer active", file)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("could not create the lock %s", file)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// logFile is the file the full playbook output is saved to
+var logFile string
+
+// openPlaybookLog creates the --logfile, writes the command about to run into it and returns a writer sending
+// the playbook output both to out and to the file with secrets masked. The returned function closes the file.
+func openPlaybookLog(out io.Writer, command *playbookCommand) (io.Writer, func(), error) {
+	if logFile == "" {
+		logFile = path.Join(os.Getenv("HOME"), ".mirror-registry", "logs", time.Now().Format("20060102-150405")+".log")
+	}
+	if err := os.MkdirAll(path.Dir(logFile), 0700); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(f, "# mirror-registry %s %s\n# %s\n", releaseVersion, time.Now().Format(time.RFC3339), command)
+
+	// Point at the log and anything else worth attaching to a support case when the run fails
+	exitHooks = append(exitHooks, func(error) {
+		log.Errorf("The full playbook output was saved to %s", logFile)
+	})
+	log.Debug("Saving the playbook output to " + logFile)
+	return redactWriter{io.MultiWriter(out, f)}, func() { f.Close() }, nil
+}
+
+// keepOnFailure removes dir once the command finished, or keeps it and logs where it is if the command fails
+func keepOnFailure(dir, description string) func() {
+	exitHooks = append(exitHooks, func(error) {
+		log.Errorf("Kept %s in %s", description, dir)
+	})
+	return func() { os.RemoveAll(dir) }
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// logsComponent is the deployed service whose logs are shown
+var logsComponent string
+
+// logsFollow holds whether or not to keep streaming new log lines
+var logsFollow bool
+
+// logsLines is the number of past log lines shown, 0 for all
+var logsLines int
+
+// logsSince limits the logs to those newer than a timestamp or a duration such as 1h
+var logsSince string
+
+// logsJournal holds whether or not to show the journal of the systemd unit instead of the container logs
+var logsJournal bool
+
+// logComponents maps the components of the logs command to their containers and systemd units
+var logComponents = map[string]string{
+	"quay":       "quay-app",
+	"postgres":   "quay-postgres",
+	"redis":      "quay-redis",
+	"clair":      "quay-clair",
+	"mirror":     "quay-mirror",
+	"prometheus": "quay-prometheus",
+	"grafana":    "quay-grafana",
+}
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show or follow the logs of a Quay, Postgres, Redis, Clair, repository mirror worker, Prometheus or Grafana service on the target.",
+	Run: func(cmd *cobra.Command, args []string) {
+		logs()
+	},
+}
+
+func init() {
+
+	// Add logs command
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	logsCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	logsCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	logsCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	logsCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	logsCmd.Flags().StringVarP(&logsComponent, "component", "", "quay", "The service to show the logs of, quay, postgres, redis, clair, mirror, prometheus or grafana. This defaults to quay")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep streaming new log lines until interrupted")
+	logsCmd.Flags().IntVarP(&logsLines, "lines", "n", 100, "The number of past log lines to show, 0 for all. This defaults to 100")
+	logsCmd.Flags().StringVarP(&logsSince, "since", "", "", "Only show logs newer than a timestamp such as 2024-01-01T12:00:00 or a duration such as 1h")
+	logsCmd.Flags().BoolVarP(&logsJournal, "journal", "", false, "Show the journal of the systemd unit, including its starts, stops and restarts, instead of the container logs")
+}
+
+func logs() {
+
+	name, ok := logComponents[logsComponent]
+	if !ok {
+		check(errors.New("Invalid --component " + logsComponent + ", must be one of quay, postgres, redis, clair, mirror, prometheus or grafana"))
+	}
+	if logsLines < 0 {
+		check(errors.New("--lines must not be negative"))
+	}
+
+	err := loadSSHKeys()
+	check(err)
+
+	log.Debugf("Streaming the logs of %s from %s", name, targetHostname)
+	cmd := remoteCommand(logsScript(name))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = sshFailure(cmd.Run())
+	if err != nil && !isSSHFailure(err) {
+		err = fmt.Errorf("could not read the logs of %s on %s: %w", name, targetHostname, err)
+	}
+	check(err)
+}
+
+// logsScript runs podman logs or journalctl for a container and its systemd unit on the target, replacing the shell
+// so that the output is streamed as it is written
+func logsScript(name string) string {
+	var podmanArgs, journalArgs []string
+	if logsLines > 0 {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--tail %d", logsLines))
+		journalArgs = append(journalArgs, fmt.Sprintf("-n %d", logsLines))
+	} else {
+		journalArgs = append(journalArgs, "-n all")
+	}
+	if logsSince != "" {
+		podmanArgs = append(podmanArgs, "--since "+shellQuote(logsSince))
+		// journalctl takes durations as relative times such as -1h
+		since := logsSince
+		if _, err := time.ParseDuration(since); err == nil {
+			since = "-" + since
+		}
+		journalArgs = append(journalArgs, "--since "+shellQuote(since))
+	}
+	if logsFollow {
+		podmanArgs = append(podmanArgs, "--follow")
+		journalArgs = append(journalArgs, "--follow")
+	}
+
+	if logsJournal {
+		return remotePreamble() + fmt.Sprintf(`if [ ! -f "$UNIT_DIR/%[1]s.service" ]; then
+    echo "%[1]s is not installed on this host" >&2
+    exit 1
+fi
+if [ "$(id -u)" = 0 ]; then UNIT="-u %[1]s.service"; else UNIT="--user-unit %[1]s.service"; fi
+exec journalctl --no-pager $UNIT %[2]s
+`, name, strings.Join(journalArgs, " "))
+	}
+	return remotePreamble() + fmt.Sprintf(`if ! podman container exists %[1]s; then
+    echo "There is no %[1]s container on this host, see the journal of its unit with --journal" >&2
+    exit 1
+fi
+exec podman logs %[2]s %[1]s
+`, name, strings.Join(podmanArgs, " "))
+}
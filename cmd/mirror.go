@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// releaseVersionToMirror is the OpenShift release mirrored by mirror release, e.g. 4.12.3
+var releaseVersionToMirror string
+
+// releaseArch is the architecture of the OpenShift release to mirror
+var releaseArch string
+
+// mirrorTo is the repository the release is mirrored to
+var mirrorTo string
+
+// pullSecretFile is the path of the pull secret for the source registries of the release
+var pullSecretFile string
+
+// systemCABundles are the locations of the system trust store on common distributions
+var systemCABundles = []string{
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/certs/ca-certificates.crt",
+}
+
+// mirrorCmd represents the mirror command
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Mirror content into the installed registry.",
+}
+
+// mirrorReleaseCmd represents the mirror release command
+var mirrorReleaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Mirror an OpenShift release into the installed registry with oc adm release mirror.",
+	Run: func(cmd *cobra.Command, args []string) {
+		mirrorRelease()
+	},
+}
+
+func init() {
+
+	// Add mirror command
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.AddCommand(mirrorReleaseCmd)
+
+	mirrorReleaseCmd.Flags().StringVarP(&releaseVersionToMirror, "version", "", "", "The OpenShift release to mirror, e.g. 4.12.3")
+	mirrorReleaseCmd.Flags().StringVarP(&releaseArch, "arch", "", "x86_64", "The architecture of the release. This defaults to x86_64")
+	mirrorReleaseCmd.Flags().StringVarP(&mirrorTo, "to", "", "", "The repository to mirror the release to. This defaults to <quayHostname>/ocp4/openshift4")
+	mirrorReleaseCmd.Flags().StringVarP(&pullSecretFile, "pullSecret", "", "", "The path of the pull secret for quay.io, downloaded from console.redhat.com")
+	mirrorReleaseCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on, used to look up the stored init credentials. This defaults to $HOST")
+	mirrorReleaseCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	mirrorReleaseCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	mirrorReleaseCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	mirrorReleaseCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	mirrorReleaseCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+	mirrorReleaseCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	mirrorReleaseCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Print the images that would be mirrored without pushing them.")
+	mirrorReleaseCmd.MarkFlagRequired("version")
+	mirrorReleaseCmd.MarkFlagRequired("pullSecret")
+}
+
+func mirrorRelease() {
+
+	if _, err := exec.LookPath("oc"); err != nil {
+		check(errors.New("mirror release requires the oc client on the control host"))
+	}
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+	if mirrorTo == "" {
+		mirrorTo = quayHostname + "/ocp4/openshift4"
+	}
+
+	workDir, err := ioutil.TempDir("", "mirror-registry-mirror")
+	check(err)
+	defer os.RemoveAll(workDir)
+
+	// Log in to the registry with the init credentials stored at install time
+	authFile := path.Join(workDir, "auth.json")
+	err = writeMirrorAuth(authFile)
+	check(err)
+
+	cmd := exec.Command("oc", "adm", "release", "mirror",
+		"-a", authFile,
+		"--from=quay.io/openshift-release-dev/ocp-release:"+releaseVersionToMirror+"-"+releaseArch,
+		"--to="+mirrorTo,
+		"--to-release-image="+mirrorTo+":"+releaseVersionToMirror+"-"+releaseArch,
+	)
+	if dryRun {
+		cmd.Args = append(cmd.Args, "--dry-run")
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	// Trust the CA of the registry in addition to the system CAs
+	err = loadSSHKeys()
+	check(err)
+	caBundle := path.Join(workDir, "ca-bundle.crt")
+	if err := writeMirrorCABundle(caBundle); err != nil {
+		log.Warnf("Could not fetch the certificate authority of %s, relying on the system trust store: %s", quayHostname, err.Error())
+	} else {
+		cmd.Env = append(cmd.Env, "SSL_CERT_FILE="+caBundle)
+	}
+
+	log.Printf("Mirroring OpenShift %s (%s) to %s. This may take some time.", releaseVersionToMirror, releaseArch, mirrorTo)
+	log.Debug("Running command: ", cmd)
+	err = cmd.Run()
+	check(err)
+
+	log.Printf("OpenShift %s mirrored to %s, use the imageContentSources printed above in install-config.yaml", releaseVersionToMirror, mirrorTo)
+}
+
+// writeMirrorAuth writes the pull secret merged with the init credentials of the registry
+func writeMirrorAuth(file string) error {
+	data, err := ioutil.ReadFile(pullSecretFile)
+	if err != nil {
+		return errors.New("Could not read --pullSecret: " + err.Error())
+	}
+	data, err = withRegistryAuth(data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// writeMirrorCABundle writes the system CAs followed by the root CA of the registry, or its certificate chain if
+// the install uses a user-provided certificate
+func writeMirrorCABundle(file string) error {
+	ca, err := runRemoteCommand(registryCAScript())
+	if err != nil {
+		return err
+	}
+	var bundle []string
+	for _, system := range systemCABundles {
+		if data, err := ioutil.ReadFile(system); err == nil {
+			bundle = append(bundle, string(data))
+			break
+		}
+	}
+	bundle = append(bundle, ca)
+	return ioutil.WriteFile(file, []byte(strings.Join(bundle, "\n")), 0600)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sethvargo/go-password/password"
+)
+
+// withMonitoring holds whether or not to expose the Quay, Postgres and Redis metrics endpoints on the target
+var withMonitoring bool
+
+// monitoringStack holds whether or not to deploy a Prometheus and Grafana pod scraping the metrics endpoints
+var monitoringStack bool
+
+// postgresExporterImage is the Prometheus exporter image run next to the bundled Postgres
+var postgresExporterImage string
+
+// redisExporterImage is the Prometheus exporter image run next to the bundled Redis
+var redisExporterImage string
+
+// prometheusImage is the Prometheus image of the monitoring stack
+var prometheusImage string
+
+// grafanaImage is the Grafana image of the monitoring stack
+var grafanaImage string
+
+// grafanaPasswordEnv is the environment variable the Grafana admin password is passed to the playbook in
+const grafanaPasswordEnv = "MIRROR_REGISTRY_GRAFANA_PASSWORD"
+
+// monitoringPorts returns the ports of the metrics endpoints, the exporters only run next to the bundled Postgres and
+// Redis
+func monitoringPorts() []string {
+	ports := []string{"9091"}
+	if pgHost == "" {
+		ports = append(ports, "9187")
+	}
+	if redisHost == "" {
+		ports = append(ports, "9121")
+	}
+	return ports
+}
+
+// monitoringStackPorts are the ports Prometheus and Grafana listen on
+var monitoringStackPorts = []string{"9090", "3000"}
+
+// validateMonitoring checks that the metrics endpoints can be exposed with the requested install options
+func validateMonitoring() error {
+	if monitoringStack && !withMonitoring {
+		return errors.New("--monitoringStack scrapes the metrics endpoints of --with-monitoring, which is not set")
+	}
+	if withMonitoring && haMode {
+		return errors.New("--with-monitoring cannot be used with --ha, scrape the metrics of the Quay hosts with an existing Prometheus")
+	}
+	return nil
+}
+
+// grafanaPassword returns the Grafana admin password stored for the target, or a new one. Grafana only reads the
+// password when it first initializes its database, so a reinstall keeps the stored one.
+func grafanaPassword() (string, error) {
+	credentials, err := loadCredentials(targetHostname)
+	if err != nil {
+		return "", err
+	}
+	if credentials["grafanaPassword"] != "" {
+		return credentials["grafanaPassword"], nil
+	}
+	return password.Generate(32, 10, 0, false, false)
+}
+
+// monitoringImageVars returns the monitoring image extra-vars shared by the install and upgrade playbooks
+func monitoringImageVars() string {
+	return fmt.Sprintf(" postgres_exporter_image=%s redis_exporter_image=%s prometheus_image=%s grafana_image=%s", postgresExporterImage, redisExporterImage, prometheusImage, grafanaImage)
+}
+
+// monitoringVars returns the monitoring extra-vars of the install playbook
+func monitoringVars() string {
+	if !withMonitoring {
+		return ""
+	}
+	return fmt.Sprintf(" enable_monitoring=true monitoring_stack=%t", monitoringStack) + monitoringImageVars()
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// quayStorageUID is the user the Quay container writes to the storage as
+const quayStorageUID = "1001"
+
+// nfsCheckScript prints "nfs <check> <result>" facts when the nearest existing directory of a storage path is on
+// NFS: its mount, the owner of a file root creates, whether fsync and locking work, and the virt_use_nfs boolean
+func nfsCheckScript(location string) string {
+	if !strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "~") {
+		return ""
+	}
+	return `d=` + location + `
+while [ ! -d "$d" ]; do d=$(dirname "$d"); done
+if [ "$(stat -f -c %T "$d" 2>/dev/null)" = nfs ]; then
+  if [ "$(id -u)" = 0 ]; then s=""; elif sudo -n true 2>/dev/null; then s="sudo -n"; else s=none; fi
+  f="$d/.mirror-registry-nfs-$$"
+  echo "nfs mount $(findmnt -n -o SOURCE,FSTYPE,OPTIONS --target "$d" | head -1)"
+  if [ "$s" = none ]; then echo "nfs owner unknown"; s=""; elif $s touch "$f" 2>/dev/null; then echo "nfs owner $($s stat -c %u "$f")"; else echo "nfs owner denied"; fi
+  if $s dd if=/dev/zero of="$f" bs=4k count=1 conv=fsync 2>/dev/null; then echo "nfs fsync ok"; else echo "nfs fsync failed"; fi
+  if $s flock -w 10 "$f" true 2>/dev/null; then echo "nfs lock ok"; else echo "nfs lock failed"; fi
+  $s rm -f "$f"
+  echo "nfs virt_use_nfs $(getsebool virt_use_nfs 2>/dev/null | awk '{print $3}')"
+fi
+`
+}
+
+// quayStorageNFSScript returns nfsCheckScript for --quayStorage, unless Quay stores blobs in object storage
+func quayStorageNFSScript() string {
+	if storageBackend != "local" {
+		return ""
+	}
+	return nfsCheckScript(quayStorage)
+}
+
+// nfsChecks checks the NFS facts of nfsCheckScript for the known causes of lost or corrupted blobs: soft mounts, locks
+// that are local to the client, root squashing, failing fsync and SELinux denying containers the mount
+func nfsChecks(facts map[string][]string, selinux string) []preflightCheck {
+	var checks []preflightCheck
+	add := func(name, result, detail string) {
+		checks = append(checks, preflightCheck{Name: name, Result: result, Detail: detail})
+	}
+	mount := facts["nfs mount"]
+	if len(mount) != 3 {
+		return nil
+	}
+	options := map[string]string{}
+	for _, option := range strings.Split(mount[2], ",") {
+		parts := strings.SplitN(option, "=", 2)
+		options[parts[0]] = ""
+		if len(parts) == 2 {
+			options[parts[0]] = parts[1]
+		}
+	}
+
+	version := options["vers"]
+	if major, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0]); major < 4 {
+		add("nfs", "WARN", fmt.Sprintf("%s is mounted with NFS %s, which needs rpc-statd for locking, NFS 4.1 or later is recommended", mount[0], version))
+	} else {
+		add("nfs", "PASS", fmt.Sprintf("%s is mounted with NFS %s", mount[0], version))
+	}
+
+	_, soft := options["soft"]
+	_, nolock := options["nolock"]
+	switch {
+	case soft:
+		add("nfs mount", "FAIL", "soft mounts fail writes with I/O errors when the server is slow, which leaves truncated blobs, mount with hard")
+	case nolock || (options["local_lock"] != "" && options["local_lock"] != "none"):
+		add("nfs mount", "FAIL", "locks are local to the target and not seen by the server, remove nolock and local_lock from the mount options")
+	default:
+		add("nfs mount", "PASS", "hard mount with server side locking")
+	}
+
+	switch owner := strings.Join(facts["nfs owner"], " "); owner {
+	case "0":
+		add("nfs squash", "PASS", "root is not squashed")
+	case "denied":
+		add("nfs squash", "FAIL", "root cannot write to the mount, the export squashes root to a user without write access. Export it with no_root_squash, or create --quayStorage on the server owned by uid "+quayStorageUID)
+	case "", "unknown":
+		add("nfs squash", "WARN", "could not check whether root is squashed, sudo requires a password")
+	default:
+		add("nfs squash", "WARN", "root is squashed to uid "+owner+", so the installer cannot change the owner of --quayStorage. Create it on the server owned by uid "+quayStorageUID+" with mode 0770, or export it with no_root_squash")
+	}
+
+	if strings.Join(facts["nfs fsync"], " ") != "ok" {
+		add("nfs fsync", "FAIL", "fsync of a test file failed, Quay cannot persist blobs safely")
+	} else {
+		add("nfs fsync", "PASS", "fsync succeeded, the export must also use sync on the server")
+	}
+
+	if strings.Join(facts["nfs lock"], " ") != "ok" {
+		add("nfs lock", "FAIL", "locking a test file failed, start rpc-statd on the target for NFS 3 or check the lock service of the server")
+	} else {
+		add("nfs lock", "PASS", "file locking works")
+	}
+
+	if selinux == "Enforcing" || selinux == "Permissive" {
+		if strings.Join(facts["nfs virt_use_nfs"], " ") == "on" {
+			add("nfs selinux", "PASS", "virt_use_nfs is on")
+		} else {
+			add("nfs selinux", "WARN", "virt_use_nfs is off, the install turns it on since NFS cannot be relabeled for the containers")
+		}
+	}
+	return checks
+}
+
+// parseNFSFacts returns the "nfs <check> <result>" facts of nfsCheckScript
+func parseNFSFacts(out string) map[string][]string {
+	facts := map[string][]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "nfs" {
+			facts["nfs "+fields[1]] = fields[2:]
+		}
+	}
+	return facts
+}
+
+// checkNFSStorage fails the install when --quayStorage is on an NFS mount that fails a check, and logs the warnings
+func checkNFSStorage() error {
+	script := quayStorageNFSScript()
+	if script == "" {
+		return nil
+	}
+	out, err := runRemoteCommand(script + `echo "selinux $(getenforce 2>/dev/null || echo Disabled)"
+`)
+	if err != nil {
+		return err
+	}
+	selinux := ""
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "selinux ") {
+			selinux = strings.TrimPrefix(line, "selinux ")
+		}
+	}
+	var failures []string
+	for _, c := range nfsChecks(parseNFSFacts(out), selinux) {
+		switch c.Result {
+		case "FAIL":
+			failures = append(failures, c.Name+": "+c.Detail)
+		case "WARN":
+			log.Warnf("%s: %s", c.Name, c.Detail)
+		default:
+			log.Debugf("%s: %s", c.Name, c.Detail)
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New("--quayStorage " + quayStorage + " is on NFS that is not safe for Quay, " + strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// oidcIssuer is the URL of the OIDC provider used to log into Quay
+var oidcIssuer string
+
+// oidcClientID is the client ID of Quay in the OIDC provider
+var oidcClientID string
+
+// oidcClientSecret is the client secret of Quay in the OIDC provider
+var oidcClientSecret string
+
+// oidcServiceName is the name of the OIDC provider shown on the login page
+var oidcServiceName string
+
+// oidcScopes are the scopes Quay requests when users log in
+var oidcScopes []string
+
+// oidcCA is the path of the PEM CA bundle that signed the certificate of the OIDC provider
+var oidcCA string
+
+// oidcClientSecretEnv is the environment variable holding the OIDC client secret
+const oidcClientSecretEnv = "MIRROR_REGISTRY_OIDC_CLIENT_SECRET"
+
+// validateOIDC checks that the OIDC flags are either all unset or complete, and normalizes the issuer URL
+func validateOIDC() error {
+	if oidcClientSecret == "" {
+		oidcClientSecret = os.Getenv(oidcClientSecretEnv)
+	}
+	if oidcIssuer == "" && oidcClientID == "" && oidcClientSecret == "" {
+		if authMode == "oidc" {
+			return errors.New("--auth oidc requires --oidcIssuer, --oidcClientID and --oidcClientSecret")
+		}
+		if oidcCA != "" {
+			return errors.New("--oidcCA requires --oidcIssuer")
+		}
+		return nil
+	}
+
+	var missing []string
+	for _, flag := range []struct{ name, value string }{
+		{"--oidcIssuer", oidcIssuer},
+		{"--oidcClientID", oidcClientID},
+		{"--oidcClientSecret", oidcClientSecret},
+	} {
+		if flag.value == "" {
+			missing = append(missing, flag.name)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("OIDC login requires --oidcIssuer, --oidcClientID and --oidcClientSecret, missing " + strings.Join(missing, ", "))
+	}
+
+	issuer, err := url.Parse(oidcIssuer)
+	if err != nil || issuer.Scheme != "https" || issuer.Host == "" {
+		return errors.New("Invalid --oidcIssuer " + oidcIssuer + ", expected an https URL")
+	}
+	if strings.ContainsAny(oidcClientID, " \t\"'") {
+		return errors.New("Invalid --oidcClientID " + oidcClientID)
+	}
+	if strings.ContainsAny(oidcServiceName, "\"'`$\\") {
+		return errors.New("--oidcServiceName must not contain quotes, backslashes or $")
+	}
+	for _, scope := range oidcScopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\"'`$\\") {
+			return errors.New("Invalid --oidcScopes " + strings.Join(oidcScopes, ","))
+		}
+	}
+	if authMode == "oidc" {
+		if err := validateExternalUsers(); err != nil {
+			return err
+		}
+	}
+
+	// Quay requires the issuer to end with a slash
+	if !strings.HasSuffix(oidcIssuer, "/") {
+		oidcIssuer += "/"
+	}
+
+	var pool *x509.CertPool
+	if oidcCA != "" {
+		pem, err := ioutil.ReadFile(oidcCA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("--oidcCA " + oidcCA + " does not contain a PEM certificate")
+		}
+	}
+	if !skipForDryRun("fetch the OIDC discovery document of " + oidcIssuer) {
+		if err := checkOIDCDiscovery(pool); err != nil {
+			// The control host may not reach the provider even though the target does
+			log.Warnf("Could not fetch the OIDC discovery document of %s, make sure the target can reach it: %s", oidcIssuer, err.Error())
+		}
+	}
+	log.Infof("Quay will allow logging in with %s at %s", oidcServiceName, oidcIssuer)
+	return nil
+}
+
+// checkOIDCDiscovery fetches the discovery document of the issuer, trusting the given CAs or the system ones if nil
+func checkOIDCDiscovery(pool *x509.CertPool) error {
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	resp, err := client.Get(oidcIssuer + ".well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("the provider returned " + resp.Status)
+	}
+	return nil
+}
+
+// oidcMountFlag returns the podman flag mounting the CA of the OIDC provider into the execution environment
+func oidcMountFlag() (string, error) {
+	if oidcCA == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(oidcCA)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(" -v %s:/runner/certs/oidc-ca.pem:Z", hostMountPath(abs)), nil
+}
+
+// oidcVars returns the extra-vars of the OIDC options beyond the issuer and client
+func oidcVars() string {
+	if oidcIssuer == "" {
+		return ""
+	}
+	vars := fmt.Sprintf(" oidc_scopes=%s oidc_ca=%t", strings.Join(oidcScopes, ","), oidcCA != "")
+	if authMode == "oidc" {
+		vars += " auth_type=OIDC"
+	}
+	return vars
+}
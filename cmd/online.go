@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// onlineInstall holds whether or not the target pulls the images instead of loading them from the image archive
+var onlineInstall bool
+
+// pullSecret is the path of the pull secret the target pulls the images with
+var pullSecret string
+
+// imageRegistry returns the registry host of an image reference, docker.io for short names
+func imageRegistry(reference string) string {
+	parts := strings.SplitN(reference, "/", 2)
+	if len(parts) == 2 && strings.ContainsAny(parts[0], ".:") {
+		return parts[0]
+	}
+	return "docker.io"
+}
+
+// validateOnline checks the online install flags and warns about the registries the pull secret has no credentials for
+func validateOnline() error {
+	if !onlineInstall {
+		if pullSecret != "" {
+			return errors.New("--pullSecret requires --online")
+		}
+		return nil
+	}
+	if imageArchivePath != "" {
+		return errors.New("--online cannot be used with --image-archive, the images are pulled on the target")
+	}
+
+	auths := map[string]json.RawMessage{}
+	if pullSecret != "" {
+		data, err := ioutil.ReadFile(pullSecret)
+		if err != nil {
+			return err
+		}
+		var secret struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}
+		if err := json.Unmarshal(data, &secret); err != nil || len(secret.Auths) == 0 {
+			return errors.New("Invalid --pullSecret " + pullSecret + ", expected a JSON pull secret with auths")
+		}
+		auths = secret.Auths
+	}
+
+	images := []string{quayImage, redisImage, postgresImage, pauseImage}
+	if withClair {
+		images = append(images, clairImage)
+	}
+	warned := map[string]bool{}
+	for _, image := range images {
+		registry := imageRegistry(image)
+		if _, ok := auths[registry]; !ok && !warned[registry] {
+			log.Warnf("No credentials for %s in --pullSecret, %s is pulled anonymously", registry, image)
+			warned[registry] = true
+		}
+	}
+	log.Info("Online install, the images are pulled on the target")
+	return nil
+}
+
+// pullSecretMountFlag returns the volume mounting --pullSecret into the execution environment
+func pullSecretMountFlag() (string, error) {
+	if pullSecret == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(pullSecret)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(" -v %s:/runner/certs/pull-secret.json:Z", hostMountPath(abs)), nil
+}
+
+// onlineVars returns the extra-var making the target pull with the pull secret
+func onlineVars() string {
+	if pullSecret == "" {
+		return ""
+	}
+	return " pull_secret=true"
+}
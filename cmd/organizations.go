@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// organizations are the organizations requested with --organization
+var organizations []string
+
+// repositories are the org/repo=visibility repositories requested with --repository
+var repositories []string
+
+// quayRepository is a parsed --repository value
+type quayRepository struct {
+	Namespace  string
+	Name       string
+	Visibility string
+}
+
+// namespaceResult records what happened when creating an organization or repository
+type namespaceResult struct {
+	Name   string `json:"name" yaml:"name"`
+	Result string `json:"result" yaml:"result"`
+}
+
+// parseOrganizations validates the --organization values
+func parseOrganizations(values []string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		name := strings.TrimSpace(value)
+		if len(name) < 2 || !validNamespace.MatchString(name) {
+			return nil, errors.New("Invalid organization name " + name + " in --organization")
+		}
+		if !seen[name] {
+			names = append(names, name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
+
+// parseRepositories validates the --repository values. The visibility defaults to private.
+func parseRepositories(values []string) ([]quayRepository, error) {
+	var repos []quayRepository
+	seen := map[string]bool{}
+	for _, value := range values {
+		path, visibility := value, "private"
+		if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+			path, visibility = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		}
+		if visibility != "public" && visibility != "private" {
+			return nil, errors.New("Invalid visibility " + visibility + " in --repository " + value + ", expected public or private")
+		}
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) != 2 || len(parts[0]) < 2 || !validNamespace.MatchString(parts[0]) || parts[1] == "" {
+			return nil, errors.New("Invalid --repository " + value + ", expected org/repo or org/repo=public|private")
+		}
+		for _, component := range strings.Split(parts[1], "/") {
+			if !validNamespace.MatchString(component) {
+				return nil, errors.New("Invalid repository name " + parts[1] + " in --repository")
+			}
+		}
+		if seen[path] {
+			return nil, errors.New("Repository " + path + " is given more than once in --repository")
+		}
+		seen[path] = true
+		repos = append(repos, quayRepository{Namespace: parts[0], Name: parts[1], Visibility: visibility})
+	}
+	return repos, nil
+}
+
+// applyOrganization creates the organization if it does not exist yet
+func applyOrganization(api *quayAPIClient, name string) (string, error) {
+	status, err := api.do("GET", "/organization/"+name, nil, nil)
+	if status == http.StatusNotFound {
+		if _, err := api.do("POST", "/organization/", map[string]string{"name": name}, nil); err != nil {
+			return "", err
+		}
+		return "created", nil
+	} else if err != nil {
+		return "", err
+	}
+	return "already exists", nil
+}
+
+// applyRepository creates the repository, and its organization if needed, or updates its visibility
+func applyRepository(api *quayAPIClient, repo quayRepository) (string, error) {
+	var actions []string
+	if repo.Namespace != initUser {
+		result, err := applyOrganization(api, repo.Namespace)
+		if err != nil {
+			return "", err
+		}
+		if result == "created" {
+			actions = append(actions, "organization created")
+		}
+	}
+
+	endpoint := fmt.Sprintf("/repository/%s/%s", repo.Namespace, repo.Name)
+	var existing struct {
+		IsPublic bool `json:"is_public"`
+	}
+	status, err := api.do("GET", endpoint, nil, &existing)
+	switch {
+	case status == http.StatusNotFound:
+		body := map[string]string{"namespace": repo.Namespace, "repository": repo.Name, "visibility": repo.Visibility, "description": "", "repo_kind": "image"}
+		if _, err := api.do("POST", "/repository", body, nil); err != nil {
+			return "", err
+		}
+		actions = append(actions, repo.Visibility+" repository created")
+	case err != nil:
+		return "", err
+	case existing.IsPublic != (repo.Visibility == "public"):
+		if _, err := api.do("POST", endpoint+"/changevisibility", map[string]string{"visibility": repo.Visibility}, nil); err != nil {
+			return "", err
+		}
+		actions = append(actions, "visibility changed to "+repo.Visibility)
+	default:
+		actions = append(actions, "already "+repo.Visibility)
+	}
+	return strings.Join(actions, ", "), nil
+}
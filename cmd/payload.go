@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// payloadMagic ends a mirror-registry binary the release build appended the execution environment to.
+// The trailer is the size of the archive as 20 digits, its SHA-256 in hex and the magic.
+const payloadMagic = "MIRROR-REGISTRY-EE"
+
+// payloadTrailerSize is the length of the trailer following the appended execution environment
+const payloadTrailerSize = 20 + 64 + len(payloadMagic)
+
+// eePayload locates the execution environment appended to the binary
+type eePayload struct {
+	offset int64
+	size   int64
+	sum    string
+}
+
+// findEEPayload returns the execution environment appended to executable, or nil if it has none
+func findEEPayload(executable string) (*eePayload, error) {
+	f, err := os.Open(executable)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(payloadTrailerSize) {
+		return nil, nil
+	}
+
+	trailer := make([]byte, payloadTrailerSize)
+	if _, err := f.ReadAt(trailer, info.Size()-int64(payloadTrailerSize)); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(string(trailer), payloadMagic) {
+		return nil, nil
+	}
+	size, err := strconv.ParseInt(string(trailer[:20]), 10, 64)
+	offset := info.Size() - int64(payloadTrailerSize) - size
+	if err != nil || size <= 0 || offset < 0 {
+		return nil, fmt.Errorf("the execution environment appended to %s is corrupt, download the installer again", executable)
+	}
+	return &eePayload{offset: offset, size: size, sum: string(trailer[20:84])}, nil
+}
+
+// eeCacheDir returns the directory the appended execution environment is extracted to
+func eeCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "mirror-registry"), nil
+}
+
+// extractEEPayload extracts the execution environment appended to the binary to the cache directory on first use and
+// returns its path, or "" if the binary has none. The archive is only kept if its checksum matches.
+func extractEEPayload() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	payload, err := findEEPayload(executable)
+	if err != nil || payload == nil {
+		return "", err
+	}
+	dir, err := eeCacheDir()
+	if err != nil {
+		return "", err
+	}
+	archive := path.Join(dir, "execution-environment-"+payload.sum+".tar")
+	if info, err := os.Stat(archive); err == nil && info.Size() == payload.size {
+		log.Debug("Using the execution environment extracted to " + archive)
+		return archive, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := checkLocalDiskSpace([]diskNeed{{dir: dir, what: "extract the execution environment", kib: payload.size / 1024}}); err != nil {
+		return "", err
+	}
+	log.Infof("Extracting the execution environment to %s", dir)
+	src, err := os.Open(executable)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile(dir, "execution-environment-*.tar.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), io.NewSectionReader(src, payload.offset, payload.size))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != payload.sum {
+		return "", fmt.Errorf("the execution environment appended to %s does not match its checksum (%s, expected %s), download the installer again", executable, sum, payload.sum)
+	}
+	if err := os.Rename(tmp.Name(), archive); err != nil {
+		return "", err
+	}
+
+	// Only the execution environment of this release is kept
+	if stale, err := filepath.Glob(path.Join(dir, "execution-environment-*.tar")); err == nil {
+		for _, file := range stale {
+			if file != archive {
+				os.Remove(file)
+			}
+		}
+	}
+	return archive, nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pgTuningProfile is the preset of Postgres settings, small, medium or large, the image defaults are kept if empty
+var pgTuningProfile string
+
+// pgMaxConnections is the max_connections of the bundled Postgres, 0 keeps the value of the profile
+var pgMaxConnections int
+
+// pgSharedBuffers is the shared_buffers of the bundled Postgres
+var pgSharedBuffers string
+
+// pgEffectiveCacheSize is the effective_cache_size of the bundled Postgres
+var pgEffectiveCacheSize string
+
+// pgWorkMem is the work_mem of the bundled Postgres
+var pgWorkMem string
+
+// pgMaintenanceWorkMem is the maintenance_work_mem of the bundled Postgres
+var pgMaintenanceWorkMem string
+
+// pgTuning are the Postgres settings of a tuning profile
+type pgTuning struct {
+	MaxConnections     int
+	SharedBuffers      string
+	EffectiveCacheSize string
+	WorkMem            string
+	MaintenanceWorkMem string
+}
+
+// pgTuningProfiles are the presets of --pgTuningProfile, sized for targets with about 4, 16 and 64 GiB of memory
+var pgTuningProfiles = map[string]pgTuning{
+	"small":  {MaxConnections: 100, SharedBuffers: "256MB", EffectiveCacheSize: "1GB", WorkMem: "4MB", MaintenanceWorkMem: "64MB"},
+	"medium": {MaxConnections: 200, SharedBuffers: "1GB", EffectiveCacheSize: "4GB", WorkMem: "8MB", MaintenanceWorkMem: "256MB"},
+	"large":  {MaxConnections: 400, SharedBuffers: "4GB", EffectiveCacheSize: "16GB", WorkMem: "16MB", MaintenanceWorkMem: "1GB"},
+}
+
+// pgMemorySize matches a Postgres memory setting with its unit, e.g. 512MB
+var pgMemorySize = regexp.MustCompile(`^[1-9][0-9]*(kB|MB|GB|TB)$`)
+
+// pgTuningRequested reports whether any Postgres tuning option is set
+func pgTuningRequested() bool {
+	return pgTuningProfile != "" || pgMaxConnections != 0 || pgSharedBuffers != "" || pgEffectiveCacheSize != "" || pgWorkMem != "" || pgMaintenanceWorkMem != ""
+}
+
+// resolvePGTuning returns the settings of --pgTuningProfile overridden by the individual tuning flags. Settings left
+// empty keep the defaults of the Postgres image.
+func resolvePGTuning() (pgTuning, error) {
+	var tuning pgTuning
+	if !pgTuningRequested() {
+		return tuning, nil
+	}
+	if pgHost != "" {
+		return tuning, errors.New("The Postgres tuning options only apply to the bundled Postgres and cannot be used with --pgHost")
+	}
+	if pgTuningProfile != "" {
+		profile, ok := pgTuningProfiles[pgTuningProfile]
+		if !ok {
+			return tuning, errors.New("Invalid --pgTuningProfile " + pgTuningProfile + ", must be one of small, medium or large")
+		}
+		tuning = profile
+	}
+	if pgMaxConnections != 0 {
+		if pgMaxConnections < 20 || pgMaxConnections > 10000 {
+			return tuning, fmt.Errorf("Invalid --pgMaxConnections %d, must be between 20 and 10000", pgMaxConnections)
+		}
+		tuning.MaxConnections = pgMaxConnections
+	}
+	for _, setting := range []struct {
+		flag  string
+		value string
+		field *string
+	}{
+		{"pgSharedBuffers", pgSharedBuffers, &tuning.SharedBuffers},
+		{"pgEffectiveCacheSize", pgEffectiveCacheSize, &tuning.EffectiveCacheSize},
+		{"pgWorkMem", pgWorkMem, &tuning.WorkMem},
+		{"pgMaintenanceWorkMem", pgMaintenanceWorkMem, &tuning.MaintenanceWorkMem},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		if !pgMemorySize.MatchString(setting.value) {
+			return tuning, errors.New("Invalid --" + setting.flag + " " + setting.value + ", expected a size with a kB, MB, GB or TB unit, e.g. 512MB")
+		}
+		*setting.field = setting.value
+	}
+	return tuning, nil
+}
+
+// pgTuningVars returns the Postgres tuning extra-vars of the install playbook, as comma separated name=value settings
+func pgTuningVars(tuning pgTuning) string {
+	var settings []string
+	if tuning.MaxConnections != 0 {
+		settings = append(settings, fmt.Sprintf("max_connections=%d", tuning.MaxConnections))
+	}
+	for name, value := range map[string]string{"shared_buffers": tuning.SharedBuffers, "effective_cache_size": tuning.EffectiveCacheSize, "work_mem": tuning.WorkMem, "maintenance_work_mem": tuning.MaintenanceWorkMem} {
+		if value != "" {
+			settings = append(settings, name+"="+value)
+		}
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	sort.Strings(settings)
+	return " pg_tuning=" + strings.Join(settings, ",")
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// playbookFailure is a task that failed on a host, as read from the playbook output
+type playbookFailure struct {
+	Task    string
+	Host    string
+	Message string
+}
+
+// playbookFailures collects the failed tasks of a playbook run for the summary printed when it fails
+type playbookFailures struct {
+	failures []playbookFailure
+}
+
+// handle records failed results and forgets those ansible ignores
+func (f *playbookFailures) handle(event ansibleEvent) {
+	switch {
+	case event.Kind == "result" && (event.Status == "fatal" || event.Status == "failed" || event.Status == "unreachable"):
+		f.failures = append(f.failures, playbookFailure{Task: event.Task, Host: event.Host, Message: failureMessage(event.Result)})
+	case event.Kind == "output" && event.Line == "...ignoring" && len(f.failures) > 0:
+		f.failures = f.failures[:len(f.failures)-1]
+	}
+}
+
+// report logs the failed tasks with their hosts and error messages, pointing at the playbook log for the full output
+func (f *playbookFailures) report() {
+	if len(f.failures) == 0 {
+		log.Errorf("The playbook failed without a failed task, see the full output in %s", logFile)
+		return
+	}
+	for _, failure := range f.failures {
+		log.Errorf("Task \"%s\" failed on %s: %s", failure.Task, failure.Host, failure.Message)
+	}
+	log.Errorf("See the full playbook output in %s", logFile)
+}
+
+// failureMessage returns the error message of the JSON result of a failed task: its msg, the stderr of a command, or
+// the reason a host is unreachable. Other results are returned as they are, shortened.
+func failureMessage(result string) string {
+	var fields struct {
+		Msg    string `json:"msg"`
+		Stderr string `json:"stderr"`
+		Reason string `json:"reason"`
+	}
+	message := result
+	if err := json.Unmarshal([]byte(result), &fields); err == nil {
+		switch {
+		case fields.Stderr != "" && (fields.Msg == "" || fields.Msg == "non-zero return code"):
+			message = fields.Stderr
+		case fields.Msg != "":
+			message = fields.Msg
+		case fields.Reason != "":
+			message = fields.Reason
+		}
+	}
+	message = strings.Join(strings.Fields(message), " ")
+	if len(message) > 500 {
+		message = message[:500] + "..."
+	}
+	if message == "" {
+		return "no error message"
+	}
+	return message
+}
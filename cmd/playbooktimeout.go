@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// playbookTimeout is how long a playbook may run before its execution environment container is killed, 0 for no limit
+var playbookTimeout time.Duration
+
+// playbookTask keeps the task a playbook is running, read when the playbook times out
+type playbookTask struct {
+	sync.Mutex
+	name    string
+	started time.Time
+}
+
+// handle records the task of a task event
+func (t *playbookTask) handle(event ansibleEvent) {
+	if event.Kind != "task" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.name, t.started = event.Task, time.Now()
+}
+
+// describe names the running task and how long it ran
+func (t *playbookTask) describe() string {
+	t.Lock()
+	defer t.Unlock()
+	if t.name == "" {
+		return "before the first task started"
+	}
+	return fmt.Sprintf("while running task \"%s\" for %s", t.name, elapsed(t.started))
+}
+
+// runPlaybook runs the command of a playbook whose output is already attached, and summarizes the failed tasks when it
+// fails. With --timeout, a playbook still running when it expires, e.g. waiting on a sudo prompt or a dead SSH
+// connection, has its container logs appended to the playbook log and the ansible_runner_instance container killed,
+// and fails naming the task it hung in.
+func runPlaybook(cmd *exec.Cmd) error {
+	failures := &playbookFailures{}
+	task := &playbookTask{}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, &ansibleEventWriter{handle: func(event ansibleEvent) {
+		failures.handle(event)
+		task.handle(event)
+	}})
+	err := runPlaybookWithTimeout(cmd, task)
+	if err != nil {
+		failures.report()
+	}
+	return err
+}
+
+// runPlaybookWithTimeout runs the command of a playbook, killing it when it runs longer than --timeout
+func runPlaybookWithTimeout(cmd *exec.Cmd, task *playbookTask) error {
+	if playbookTimeout <= 0 {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(playbookTimeout):
+	}
+
+	hung := task.describe()
+	log.Errorf("The playbook did not finish within %s, it hung %s", playbookTimeout, hung)
+	collectRunnerLogs(hung)
+	if out, err := exec.Command(eeRuntime.name(), "kill", "ansible_runner_instance").CombinedOutput(); err != nil {
+		log.Warnf("Could not kill the ansible_runner_instance container: %s", string(out))
+	}
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+	}
+	return withExitCode(exitPlaybook, fmt.Errorf("the playbook timed out after %s %s, raise --timeout if it needs longer", playbookTimeout, hung))
+}
+
+// collectRunnerLogs appends the logs of the ansible_runner_instance container to the playbook log
+func collectRunnerLogs(hung string) {
+	out, err := exec.Command(eeRuntime.name(), "logs", "--tail", "200", "ansible_runner_instance").CombinedOutput()
+	if err != nil {
+		log.Warnf("Could not collect the logs of the ansible_runner_instance container: %s", string(out))
+		return
+	}
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Warnf("Could not save the logs of the ansible_runner_instance container: %s", err.Error())
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "\n# Timed out after %s %s, last lines of the ansible_runner_instance container:\n", playbookTimeout, hung)
+	redactWriter{f}.Write(out)
+}
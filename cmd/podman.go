@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// minPodmanVersion is the oldest podman the installer supports on the control host and on the target
+const minPodmanVersion = "3.0"
+
+// localPodmanFacts describes the podman running the execution environment on the control host
+type localPodmanFacts struct {
+	Version     string
+	Rootless    bool
+	UIDMappings int
+}
+
+// gatherLocalPodmanFacts collects the version, rootless mode and user namespace mappings of the local podman
+func gatherLocalPodmanFacts() (localPodmanFacts, error) {
+	var facts localPodmanFacts
+	if _, err := exec.LookPath("podman"); err != nil && os.Getenv("CONTAINER_HOST") == "" {
+		return facts, fmt.Errorf("podman was not found on the control host, install podman %s or later", minPodmanVersion)
+	}
+	out, err := exec.Command("podman", "info", "--format", "{{.Version.Version}} {{.Host.Security.Rootless}} {{len .Host.IDMappings.UIDMap}}").Output()
+	if err != nil {
+		return facts, fmt.Errorf("could not query podman on the control host: %s", err.Error())
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return facts, fmt.Errorf("could not query podman on the control host: unexpected output %q", strings.TrimSpace(string(out)))
+	}
+	facts.Version = fields[0]
+	facts.Rootless = fields[1] == "true"
+	facts.UIDMappings, _ = strconv.Atoi(fields[2])
+	return facts, nil
+}
+
+// checkLocalPodman fails with the minimum requirement when the local podman cannot run the execution environment
+// with the requested --networkMode, rather than letting podman fail with a raw error
+func checkLocalPodman() error {
+	facts, err := gatherLocalPodmanFacts()
+	if err != nil {
+		return err
+	}
+	mode := "rootful"
+	if facts.Rootless {
+		mode = "rootless"
+	}
+	log.Debugf("Control host runs %s podman %s with %d UID mappings", mode, facts.Version, facts.UIDMappings)
+
+	switch {
+	case !versionAtLeast(facts.Version, minPodmanVersion):
+		return fmt.Errorf("podman %s on the control host is not supported, at least podman %s is required", facts.Version, minPodmanVersion)
+	case facts.Rootless && facts.UIDMappings < 2:
+		// Without subordinate IDs the layers of the execution environment owned by other users cannot be unpacked
+		return errors.New("rootless podman on the control host has no subordinate UIDs, add a range for " + os.Getenv("USER") + " to /etc/subuid and /etc/subgid and run podman system migrate")
+	case networkMode == "bridge" && facts.Rootless && !versionAtLeast(facts.Version, "4.0"):
+		return fmt.Errorf("--networkMode bridge requires podman 4.0 when running rootless, found podman %s; use host or slirp4netns", facts.Version)
+	}
+	return nil
+}
+
+// podmanRuntime runs the execution environment with podman
+type podmanRuntime struct{}
+
+func (podmanRuntime) name() string {
+	return "podman"
+}
+
+func (podmanRuntime) check() error {
+	return checkLocalPodman()
+}
+
+func (podmanRuntime) importCommand() string {
+	return "/usr/bin/podman image import"
+}
+
+func (podmanRuntime) graphRoot() (string, error) {
+	return localGraphRoot()
+}
+
+func (podmanRuntime) rootless() bool {
+	return isRootlessPodman()
+}
+
+func (podmanRuntime) imageExists(reference string) bool {
+	return exec.Command("podman", "image", "exists", reference).Run() == nil
+}
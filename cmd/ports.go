@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+)
+
+// installPorts returns the ports the install binds on the target. Postgres, Redis and Clair only bind ports of
+// the target when the Quay pod uses the host network, the metrics endpoints and the monitoring stack always do.
+func installPorts() []string {
+	ports := []string{portOf(quayHostname)}
+	if haMode || podNetworkMode == "host" {
+		ports = []string{"8443"}
+		if podNetworkMode == "host" {
+			if pgHost == "" {
+				ports = append(ports, "5432")
+			}
+			if redisHost == "" {
+				ports = append(ports, "6379")
+			}
+			if withClair {
+				ports = append(ports, "8081")
+			}
+		}
+	}
+	if withMonitoring {
+		ports = append(ports, monitoringPorts()...)
+	}
+	if monitoringStack {
+		ports = append(ports, monitoringStackPorts...)
+	}
+	return ports
+}
+
+// portCheckScript prints "port <port> free" or "port <port> used <process>" for every port. The process is only
+// known when the target user may see it, which sudo helps with.
+func portCheckScript(ports []string) string {
+	return `for port in ` + strings.Join(ports, " ") + `; do
+  line=$( (sudo -n ss -Hltnp "sport = :$port" 2>/dev/null || ss -Hltnp "sport = :$port" 2>/dev/null) | head -n 1)
+  if [ -z "$line" ]; then echo "port $port free"; continue; fi
+  process=$(echo "$line" | grep -o 'users:(("[^"]*",pid=[0-9]*' | sed 's/users:(("//; s/",pid=/ pid /')
+  echo "port $port used ${process:-an unknown process}"
+done
+`
+}
+
+// checkPortConflicts fails when a port the install binds is already in use on the target, unless Quay itself holds it
+func checkPortConflicts() error {
+	ports := installPorts()
+	out, err := runRemoteCommand(`if [ "$(podman container inspect --format '{{.State.Running}}' quay-app 2>/dev/null)" = true ]; then echo "quay running"; exit 0; fi
+` + portCheckScript(ports))
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "quay":
+			log.Debugf("Quay is running on %s, its ports are expected to be in use", targetHostname)
+			return nil
+		case len(fields) > 3 && fields[0] == "port" && fields[2] == "used":
+			conflicts = append(conflicts, "port "+fields[1]+" is in use by "+strings.Join(fields[3:], " "))
+		}
+	}
+	if len(conflicts) > 0 {
+		return errors.New("Cannot install on " + targetHostname + ", " + strings.Join(conflicts, ", ") + ". Stop the process or choose another port with --quayHostname")
+	}
+	log.Infof("Ports %s are free on %s", strings.Join(ports, ", "), targetHostname)
+	return nil
+}
@@ -0,0 +1,435 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// minFreeDiskKiB is the free space required below quayRoot, quayStorage and pgStorage on the target
+const minFreeDiskKiB = 10 * 1024 * 1024
+
+// storageLocations are the flags naming where the install keeps data on the target, in the order they are checked
+var storageLocations = []string{"quayRoot", "quayStorage", "pgStorage"}
+
+// preflightCmd represents the preflight command
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that a target host is ready for an install.",
+	Run: func(cmd *cobra.Command, args []string) {
+		preflight()
+	},
+}
+
+// preflightCheck is the outcome of a single preflight check
+type preflightCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+func init() {
+
+	// Add preflight command
+	rootCmd.AddCommand(preflightCmd)
+
+	preflightCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target you wish to install Quay to. This defaults to $HOST")
+	preflightCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	preflightCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	preflightCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	preflightCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	preflightCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The value to set SERVER_HOSTNAME in the Quay config.yaml, which is checked to resolve to the target. This defaults to <targetHostname>:8443")
+	preflightCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	preflightCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder where quay persistent storage data is saved. This defaults to a Podman named volume 'quay-storage'. Root is required to uninstall.")
+	preflightCmd.Flags().StringVarP(&pgStorage, "pgStorage", "", "pg-storage", "The folder where postgres persistent storage data is saved. This defaults to a Podman named volume 'pg-storage'. Root is required to uninstall.")
+	preflightCmd.Flags().StringVarP(&storageBackend, "storage", "", "local", "Where Quay stores image blobs, local, s3, azure or gcs. With object storage, the bucket or container is checked to be writable with the credentials of the backend. This defaults to local")
+	preflightCmd.Flags().StringVarP(&s3Endpoint, "s3-endpoint", "", "", "The URL of the S3 compatible object storage, e.g. https://minio.example.com:9000")
+	preflightCmd.Flags().StringVarP(&s3Bucket, "s3-bucket", "", "", "The bucket Quay stores image blobs in")
+	preflightCmd.Flags().StringVarP(&s3AccessKey, "s3-access-key", "", "", "The access key of the S3 compatible object storage")
+	preflightCmd.Flags().StringVarP(&s3SecretKey, "s3-secret-key", "", "", "The secret key of --s3-access-key. Can also be set with $"+s3SecretKeyEnv)
+	preflightCmd.Flags().StringVarP(&s3Region, "s3-region", "", "us-east-1", "The region of the bucket. This defaults to us-east-1")
+	preflightCmd.Flags().StringVarP(&azureAccountName, "azure-account-name", "", "", "The Azure storage account of --storage azure")
+	preflightCmd.Flags().StringVarP(&azureAccountKey, "azure-account-key", "", "", "An access key of --azure-account-name. Can also be set with $"+azureAccountKeyEnv)
+	preflightCmd.Flags().StringVarP(&azureContainer, "azure-container", "", "", "The existing blob container Quay stores image blobs in with --storage azure")
+	preflightCmd.Flags().StringVarP(&gcsBucket, "gcs-bucket", "", "", "The existing Google Cloud Storage bucket Quay stores image blobs in with --storage gcs")
+	preflightCmd.Flags().StringVarP(&gcsAccessKey, "gcs-access-key", "", "", "The access ID of a Google Cloud Storage HMAC key")
+	preflightCmd.Flags().StringVarP(&gcsSecretKey, "gcs-secret-key", "", "", "The secret of --gcs-access-key. Can also be set with $"+gcsSecretKeyEnv)
+	preflightCmd.Flags().StringVarP(&authMode, "auth", "", "database", "How users log into Quay, database or ldap. With ldap, the target must reach --ldapURI. This defaults to database")
+	preflightCmd.Flags().StringVarP(&ldapURI, "ldapURI", "", "", "The URI of the LDAP server the target must reach, e.g. ldaps://ldap.example.com")
+	preflightCmd.Flags().BoolVarP(&fipsMode, "fips", "", false, "Check that the target runs in FIPS mode")
+	preflightCmd.Flags().StringVarP(&runtimeName, "runtime", "", "", "The container engine running the execution environment on the control host, podman or docker. This defaults to podman if installed, docker otherwise")
+	preflightCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the results as JSON")
+}
+
+func preflight() {
+
+	var checks []preflightCheck
+	add := func(name, result, detail string) {
+		checks = append(checks, preflightCheck{Name: name, Result: result, Detail: detail})
+	}
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	credential := sshKey
+	if sshPasswordAuth {
+		check(loadSSHPassword())
+		credential = "a password"
+	}
+
+	// The execution environment runs on the control host
+	if err := loadContainerRuntime(); err != nil {
+		add("control", "FAIL", err.Error())
+	} else {
+		add("control", "PASS", eeRuntime.name()+" can run the execution environment")
+	}
+
+	// Gather everything else in a single SSH session once the target is reachable
+	_, err := runRemoteCommand("true\n")
+	if err != nil {
+		add("ssh", "FAIL", fmt.Sprintf("cannot connect as %s with %s: %s", targetUsername, credential, err.Error()))
+	} else {
+		add("ssh", "PASS", "connected as "+targetUsername)
+		checks = append(checks, targetPreflightChecks()...)
+	}
+
+	// Object storage is checked from the control host with the credentials Quay will use
+	if err := validateStorage(); err != nil {
+		add("storage", "FAIL", err.Error())
+	} else if storageBackend != "local" {
+		if err := checkStorage(); err != nil {
+			add("storage", "FAIL", err.Error())
+		} else {
+			add("storage", "PASS", storageDescription()+" is writable")
+		}
+	}
+
+	failed := false
+	for _, c := range checks {
+		failed = failed || c.Result == "FAIL"
+	}
+	if jsonOutput {
+		data, err := json.MarshalIndent(checks, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	} else {
+		for _, c := range checks {
+			fmt.Printf("%-4s %-10s %s\n", c.Result, c.Name, c.Detail)
+		}
+	}
+
+	if failed {
+		log.Errorf("%s is not ready for an install", targetHostname)
+		os.Exit(exitPreflight)
+	}
+	log.Infof("%s is ready for an install", targetHostname)
+}
+
+// targetPreflightChecks checks privileges, container runtime, storage locations, ports, SELinux, the OS of the target and
+// whether it reaches the LDAP server
+func targetPreflightChecks() []preflightCheck {
+	var checks []preflightCheck
+	add := func(name, result, detail string) {
+		checks = append(checks, preflightCheck{Name: name, Result: result, Detail: detail})
+	}
+
+	// Quay connects to the directory from the target, not from the control host
+	ldapScript := ""
+	if authMode == "ldap" {
+		address, err := ldapAddress()
+		if err != nil {
+			add("ldap", "FAIL", err.Error())
+		} else {
+			host, port, _ := net.SplitHostPort(address)
+			ldapScript = `if timeout 5 bash -c '</dev/tcp/` + host + `/` + port + `' 2>/dev/null; then echo "ldap reachable ` + address + `"; else echo "ldap unreachable ` + address + `"; fi
+`
+		}
+	}
+
+	out, err := runRemoteCommand(`if [ "$(id -u)" = 0 ]; then echo "sudo root"; elif sudo -n true 2>/dev/null; then echo "sudo passwordless"; else echo "sudo password"; fi
+storage() {
+  d=$2; state=exists
+  while [ ! -d "$d" ]; do state=missing; d=$(dirname "$d"); done
+  if [ -w "$d" ]; then access=writable; elif sudo -n test -w "$d" 2>/dev/null; then access=sudo; else access=readonly; fi
+  echo "storage $1 $state $access $(df -Pk "$d" | awk 'NR==2 {print $4}') $d"
+}
+storage quayRoot ` + quayRoot + `
+storage quayStorage ` + storagePath(quayStorage) + `
+storage pgStorage ` + storagePath(pgStorage) + `
+` + quayStorageNFSScript() + `` + portCheckScript([]string{"8443", "5432", "6379"}) + `if podman container exists quay-app 2>/dev/null; then echo "quay installed"; fi
+echo "selinux $(getenforce 2>/dev/null || echo Disabled)"
+echo "arch $(uname -m)"
+echo "addresses $(hostname -I 2>/dev/null)"
+echo "resolved $(getent ahosts ` + hostOnly(quayHostname) + ` 2>/dev/null | awk '{print $1}' | sort -u | tr '\n' ' ')"
+. /etc/os-release 2>/dev/null; echo "os ${ID:-unknown} ${VERSION_ID:-unknown}"
+` + ldapScript)
+	if err != nil {
+		add("target", "FAIL", "could not gather facts: "+err.Error())
+		return checks
+	}
+	facts := map[string][]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			key := fields[0]
+			if key == "port" || key == "storage" || key == "nfs" {
+				key += " " + fields[1]
+				fields = fields[1:]
+			}
+			facts[key] = fields[1:]
+		}
+	}
+
+	switch strings.Join(facts["sudo"], " ") {
+	case "root", "passwordless":
+		add("sudo", "PASS", strings.Join(facts["sudo"], " "))
+	default:
+		add("sudo", "WARN", "sudo requires a password, pass --askBecomePass to install")
+	}
+
+	runtime, err := gatherRuntimeFacts()
+	if err == nil {
+		err = checkRuntimeCompatibility(runtime)
+	}
+	if err != nil {
+		add("podman", "FAIL", err.Error())
+	} else {
+		add("podman", "PASS", fmt.Sprintf("podman %s, cgroups %s", runtime.PodmanVersion, runtime.CgroupVersion))
+	}
+	if err == nil {
+		switch {
+		case runtime.FIPS:
+			add("fips", "PASS", "enabled")
+		case fipsMode:
+			add("fips", "FAIL", "disabled, enable it with fips-mode-setup --enable and reboot")
+		}
+	}
+
+	for _, name := range storageLocations {
+		if name == "quayStorage" && storageBackend != "local" {
+			continue
+		}
+		checks = append(checks, storageCheck(name, facts["storage "+name]))
+	}
+
+	if storageBackend == "local" {
+		checks = append(checks, nfsChecks(facts, strings.Join(facts["selinux"], " "))...)
+	}
+
+	installed := len(facts["quay"]) > 0
+	for _, port := range []string{"8443", "5432", "6379"} {
+		switch {
+		case len(facts["port "+port]) == 0:
+			add("port "+port, "WARN", "could not check whether the port is in use")
+		case facts["port "+port][0] == "free":
+			add("port "+port, "PASS", "free")
+		case installed:
+			add("port "+port, "WARN", "in use, Quay is already installed")
+		default:
+			add("port "+port, "FAIL", "in use by "+strings.Join(facts["port "+port][1:], " "))
+		}
+	}
+
+	add("selinux", "PASS", strings.Join(facts["selinux"], " "))
+
+	checks = append(checks, archCheck(facts["arch"]))
+
+	checks = append(checks, hostnameCheck(facts["addresses"], facts["resolved"]))
+
+	if ldap := facts["ldap"]; len(ldap) == 2 {
+		if ldap[0] == "reachable" {
+			add("ldap", "PASS", ldap[1]+" is reachable")
+		} else {
+			add("ldap", "FAIL", ldap[1]+" is not reachable from the target")
+		}
+	}
+
+	if release := facts["os"]; len(release) == 2 && (release[0] == "rhel" || release[0] == "centos" || release[0] == "rocky" || release[0] == "almalinux" || release[0] == "fedora") && versionAtLeast(release[1], "8") {
+		add("os", "PASS", strings.Join(release, " "))
+	} else {
+		add("os", "WARN", strings.Join(facts["os"], " ")+" is not tested, RHEL 8 or later is recommended")
+	}
+	return checks
+}
+
+// storagePath returns the directory a storage flag points to on the target. Named volumes live in the volume
+// path of podman.
+func storagePath(location string) string {
+	if strings.HasPrefix(location, "/") || strings.HasPrefix(location, "~") {
+		return location
+	}
+	return `"$(podman info --format '{{.Store.VolumePath}}' 2>/dev/null || echo /)"`
+}
+
+// storageCheck checks that a storage location exists, is writable and has enough free space from its
+// "exists|missing writable|sudo|readonly freeKiB dir" facts
+func storageCheck(name string, storage []string) preflightCheck {
+	outcome := func(result, detail string) preflightCheck {
+		return preflightCheck{Name: name, Result: result, Detail: detail}
+	}
+	if len(storage) != 4 {
+		return outcome("WARN", "could not check the location")
+	}
+	free, _ := strconv.Atoi(storage[2])
+	detail := fmt.Sprintf("%d GiB free in %s", free/1024/1024, storage[3])
+	switch {
+	case storage[1] == "readonly":
+		return outcome("FAIL", storage[3]+" is not writable by "+targetUsername+" or with sudo")
+	case free < minFreeDiskKiB:
+		return outcome("FAIL", detail+", at least 10 GiB are required")
+	case storage[0] == "missing":
+		return outcome("WARN", detail+", the directory does not exist and will be created on the filesystem of "+storage[3])
+	}
+	return outcome("PASS", detail)
+}
+
+// runtimeFacts describes the container runtime found on the target
+type runtimeFacts struct {
+	CgroupVersion string `json:"cgroupVersion" yaml:"cgroupVersion"`
+	PodmanVersion string `json:"podmanVersion" yaml:"podmanVersion"`
+	Crun          bool   `json:"crun" yaml:"crun"`
+	Runc          bool   `json:"runc" yaml:"runc"`
+	RuncVersion   string `json:"runcVersion,omitempty" yaml:"runcVersion,omitempty"`
+	FIPS          bool   `json:"fips" yaml:"fips"`
+}
+
+// runtimeRule is one entry of the container runtime compatibility table
+type runtimeRule struct {
+	fatal   bool
+	applies func(f runtimeFacts) bool
+	message func(f runtimeFacts) string
+}
+
+// minRuncCgroupV2Version is the first runc release with stable cgroups v2 support
+const minRuncCgroupV2Version = "1.0"
+
+// runcSupportsCgroupV2 reports whether runc of version v supports cgroups v2, the 1.0.0 release candidates do not
+func runcSupportsCgroupV2(v string) bool {
+	return versionAtLeast(v, minRuncCgroupV2Version) && !strings.HasPrefix(v, "1.0.0-")
+}
+
+// runtimeCompatibility lists the known combinations of cgroups, podman and OCI runtime that do not work
+var runtimeCompatibility = []runtimeRule{
+	{
+		fatal:   true,
+		applies: func(f runtimeFacts) bool { return f.PodmanVersion == "" },
+		message: func(f runtimeFacts) string {
+			return fmt.Sprintf("podman was not found on the target, install podman %s or later", minPodmanVersion)
+		},
+	},
+	{
+		fatal:   true,
+		applies: func(f runtimeFacts) bool { return !versionAtLeast(f.PodmanVersion, minPodmanVersion) },
+		message: func(f runtimeFacts) string {
+			return fmt.Sprintf("podman %s is not supported, at least podman %s is required", f.PodmanVersion, minPodmanVersion)
+		},
+	},
+	{
+		fatal: true,
+		applies: func(f runtimeFacts) bool {
+			return f.CgroupVersion == "v2" && !f.Crun && f.Runc && f.RuncVersion != "" && !runcSupportsCgroupV2(f.RuncVersion)
+		},
+		message: func(f runtimeFacts) string {
+			return fmt.Sprintf("podman %s with cgroups v2 requires crun or runc %s or later; found runc %s only", f.PodmanVersion, minRuncCgroupV2Version, f.RuncVersion)
+		},
+	},
+	{
+		fatal:   true,
+		applies: func(f runtimeFacts) bool { return !f.Crun && !f.Runc },
+		message: func(f runtimeFacts) string { return "neither crun nor runc was found on the target" },
+	},
+	{
+		applies: func(f runtimeFacts) bool { return !versionAtLeast(f.PodmanVersion, "3.3") },
+		message: func(f runtimeFacts) string {
+			return fmt.Sprintf("podman %s is older than the recommended podman 3.3", f.PodmanVersion)
+		},
+	},
+	{
+		applies: func(f runtimeFacts) bool { return f.CgroupVersion == "v1" && versionAtLeast(f.PodmanVersion, "4.0") },
+		message: func(f runtimeFacts) string {
+			return fmt.Sprintf("podman %s with cgroups v1 is deprecated, consider switching the target to cgroups v2", f.PodmanVersion)
+		},
+	},
+}
+
+// gatherRuntimeFacts collects the cgroup version, podman version, available OCI runtimes and FIPS mode from the target
+func gatherRuntimeFacts() (runtimeFacts, error) {
+	var facts runtimeFacts
+	out, err := runRemoteCommand(`if [ "$(stat -fc %T /sys/fs/cgroup)" = cgroup2fs ]; then echo "cgroup v2"; else echo "cgroup v1"; fi
+echo "podman $(podman version --format '{{.Client.Version}}' 2>/dev/null)"
+if command -v crun >/dev/null 2>&1; then echo "crun yes"; else echo "crun no"; fi
+if command -v runc >/dev/null 2>&1; then echo "runc yes $(runc --version 2>/dev/null | awk 'NR == 1 {print $3}')"; else echo "runc no"; fi
+if [ "$(cat /proc/sys/crypto/fips_enabled 2>/dev/null)" = 1 ]; then echo "fips yes"; else echo "fips no"; fi
+`)
+	if err != nil {
+		return facts, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "cgroup":
+			facts.CgroupVersion = fields[1]
+		case "podman":
+			facts.PodmanVersion = fields[1]
+		case "crun":
+			facts.Crun = fields[1] == "yes"
+		case "runc":
+			facts.Runc = fields[1] == "yes"
+			if len(fields) > 2 {
+				facts.RuncVersion = fields[2]
+			}
+		case "fips":
+			facts.FIPS = fields[1] == "yes"
+		}
+	}
+	return facts, nil
+}
+
+// checkRuntimeCompatibility evaluates the facts against the compatibility table, logging warnings and returning the first fatal problem
+func checkRuntimeCompatibility(facts runtimeFacts) error {
+	log.Infof("Target runtime: cgroups %s, podman %s, crun=%t, runc=%t %s", facts.CgroupVersion, facts.PodmanVersion, facts.Crun, facts.Runc, facts.RuncVersion)
+	for _, rule := range runtimeCompatibility {
+		if !rule.applies(facts) {
+			continue
+		}
+		if rule.fatal {
+			return fmt.Errorf("incompatible container runtime on target: %s", rule.message(facts))
+		}
+		log.Warn(rule.message(facts))
+	}
+	return nil
+}
+
+// checkPodmanSecretsSupport disables usePodmanSecrets if the target podman cannot pass secrets as environment variables
+func checkPodmanSecretsSupport(facts runtimeFacts) {
+	if usePodmanSecrets && !versionAtLeast(facts.PodmanVersion, "3.1") {
+		log.Warnf("podman %s on the target does not support secrets, passing passwords as environment variables instead", facts.PodmanVersion)
+		usePodmanSecrets = false
+	}
+}
+
+// versionAtLeast reports whether the dotted version v is greater than or equal to min
+func versionAtLeast(v, min string) bool {
+	have := strings.Split(strings.SplitN(v, "-", 2)[0], ".")
+	want := strings.Split(min, ".")
+	for i := range want {
+		var h int
+		if i < len(have) {
+			h, _ = strconv.Atoi(have[i])
+		}
+		w, _ := strconv.Atoi(want[i])
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
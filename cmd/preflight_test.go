@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain sets the formatter the root command sets before any command runs
+func TestMain(m *testing.M) {
+	log.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	os.Exit(m.Run())
+}
+
+func TestCheckRuntimeCompatibility(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		facts runtimeFacts
+		fails bool
+	}{
+		{"cgroups v2 with crun", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.9.4", Crun: true}, false},
+		{"cgroups v2 with runc 1.1", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.9.4", Runc: true, RuncVersion: "1.1.12"}, false},
+		{"cgroups v2 with runc 1.0", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.0.2", Runc: true, RuncVersion: "1.0.0"}, false},
+		{"cgroups v2 with a runc release candidate", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.0.2", Runc: true, RuncVersion: "1.0.0-rc92"}, true},
+		{"cgroups v2 with runc 0.1", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.0.2", Runc: true, RuncVersion: "0.1.1"}, true},
+		{"cgroups v1 with an old runc", runtimeFacts{CgroupVersion: "v1", PodmanVersion: "3.4.4", Runc: true, RuncVersion: "1.0.0-rc10"}, false},
+		{"no OCI runtime", runtimeFacts{CgroupVersion: "v2", PodmanVersion: "4.9.4"}, true},
+		{"no podman", runtimeFacts{CgroupVersion: "v2", Crun: true}, true},
+		{"podman too old", runtimeFacts{CgroupVersion: "v1", PodmanVersion: "2.2.1", Runc: true, RuncVersion: "1.1.12"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkRuntimeCompatibility(tc.facts)
+			if tc.fails && err == nil {
+				t.Errorf("%+v passed, want an incompatible runtime", tc.facts)
+			}
+			if !tc.fails && err != nil {
+				t.Errorf("%+v failed: %s", tc.facts, err)
+			}
+		})
+	}
+}
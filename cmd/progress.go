@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// playbookProgress renders a task by task progress display of a playbook run
+type playbookProgress struct {
+	out         io.Writer
+	interactive bool
+	started     time.Time
+
+	task      string
+	host      string
+	status    string
+	result    string
+	taskStart time.Time
+
+	tasks   int
+	changed []string
+	failed  []string
+}
+
+// newPlaybookProgress creates a progress display, redrawing the current task in place if out is a terminal
+func newPlaybookProgress(out *os.File) *playbookProgress {
+	interactive := false
+	if info, err := out.Stat(); err == nil {
+		interactive = info.Mode()&os.ModeCharDevice != 0 && !noColor
+	}
+	return &playbookProgress{out: out, interactive: interactive, started: time.Now()}
+}
+
+// handle updates the display for an event of the playbook output
+func (p *playbookProgress) handle(event ansibleEvent) {
+	switch event.Kind {
+	case "play":
+		p.finishTask()
+		fmt.Fprintf(p.out, "PLAY %s\n", event.Play)
+	case "task":
+		p.finishTask()
+		p.task, p.host, p.status, p.result, p.taskStart = event.Task, "", "running", "", time.Now()
+		if p.interactive {
+			fmt.Fprintf(p.out, "\r\033[K  [%s] %s ...", elapsed(p.started), p.task)
+		}
+	case "result":
+		p.host = event.Host
+		// Keep the most significant status when a task runs on several hosts or items
+		if statusRank(event.Status) >= statusRank(p.status) {
+			p.status, p.result = event.Status, event.Result
+		}
+	case "recap":
+		p.finishTask()
+		p.summary(event)
+	}
+}
+
+// finishTask prints the final line of the current task
+func (p *playbookProgress) finishTask() {
+	if p.task == "" {
+		return
+	}
+	p.tasks++
+	switch p.status {
+	case "changed":
+		p.changed = append(p.changed, p.task)
+	case "fatal", "failed", "unreachable":
+		p.failed = append(p.failed, p.task)
+	}
+	if p.interactive {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+	host := ""
+	if p.host != "" {
+		host = " [" + p.host + "]"
+	}
+	fmt.Fprintf(p.out, "  %-9s %s%s (%s)\n", p.status, p.task, host, elapsed(p.taskStart))
+	if p.status == "fatal" || p.status == "failed" || p.status == "unreachable" {
+		fmt.Fprintf(p.out, "            %s\n", p.result)
+	}
+	p.task = ""
+}
+
+// summary prints the totals of the playbook run
+func (p *playbookProgress) summary(recap ansibleEvent) {
+	fmt.Fprintf(p.out, "Playbook finished on %s in %s: %d tasks, %d changed, %d failed\n", recap.Host, elapsed(p.started), p.tasks, len(p.changed), len(p.failed))
+	if len(p.changed) > 0 {
+		fmt.Fprintf(p.out, "  changed: %s\n", strings.Join(p.changed, ", "))
+	}
+	if len(p.failed) > 0 {
+		fmt.Fprintf(p.out, "  failed: %s\n", strings.Join(p.failed, ", "))
+	}
+}
+
+// statusRank orders task results from least to most significant
+func statusRank(status string) int {
+	switch status {
+	case "skipping":
+		return 1
+	case "ok":
+		return 2
+	case "changed":
+		return 3
+	case "fatal", "failed", "unreachable":
+		return 4
+	}
+	return 0
+}
+
+// elapsed formats the time since start rounded to tenths of a second
+func elapsed(start time.Time) string {
+	return time.Since(start).Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPlaybookProgressReportsColoredFailure(t *testing.T) {
+	var out bytes.Buffer
+	progress := &playbookProgress{out: &out}
+	w := &ansibleEventWriter{handle: progress.handle}
+	w.Write([]byte("\x1b[0;32mTASK [mirror_appliance : Start Quay] ***\x1b[0m\r\n" +
+		"\x1b[0;31mfatal: [quay.example.com]: FAILED! => {\"msg\": \"quay-app failed to start\"}\x1b[0m\r\n" +
+		"PLAY RECAP *********\r\n" +
+		"\x1b[0;31mquay.example.com\x1b[0m : ok=3 changed=0 unreachable=0 failed=1\r\n"))
+
+	if len(progress.failed) != 1 || progress.failed[0] != "mirror_appliance : Start Quay" {
+		t.Fatalf("failed tasks = %q, want the Start Quay task\n%s", progress.failed, out.String())
+	}
+	if !strings.Contains(out.String(), `{"msg": "quay-app failed to start"}`) {
+		t.Errorf("the result of the failed task is not shown:\n%s", out.String())
+	}
+}
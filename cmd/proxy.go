@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// httpProxy is the proxy Quay uses for outgoing HTTP connections
+var httpProxy string
+
+// httpsProxy is the proxy Quay uses for outgoing HTTPS connections
+var httpsProxy string
+
+// noProxy is the comma separated list of hosts Quay connects to without a proxy
+var noProxy string
+
+// httpProxyEnv and httpsProxyEnv are the environment variables the proxies are passed to the playbook in, since their
+// URLs may hold credentials
+const (
+	httpProxyEnv  = "MIRROR_REGISTRY_HTTP_PROXY"
+	httpsProxyEnv = "MIRROR_REGISTRY_HTTPS_PROXY"
+)
+
+// validateProxies checks the proxy URLs and adds the local services and the Quay hostname to noProxy
+func validateProxies() error {
+	for flag, value := range map[string]string{"--httpProxy": httpProxy, "--httpsProxy": httpsProxy} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Invalid " + flag + " " + redactProxy(value) + ", expected a URL such as http://proxy.example.com:3128")
+		}
+		if password, ok := u.User.Password(); ok {
+			registerSecret(password)
+		}
+	}
+	if strings.ContainsAny(noProxy, " \t\"'") {
+		return errors.New("Invalid --noProxy " + noProxy + ", expected a comma separated list of hosts")
+	}
+	if httpProxy == "" && httpsProxy == "" {
+		if noProxy != "" {
+			log.Warn("Ignoring --noProxy because neither --httpProxy nor --httpsProxy is set")
+			noProxy = ""
+		}
+		return nil
+	}
+
+	// Quay reaches Postgres, Redis and itself locally, these must never go through the proxy
+	hosts := strings.Split(noProxy, ",")
+	for _, host := range []string{"localhost", "127.0.0.1", hostOnly(quayHostname)} {
+		found := false
+		for _, h := range hosts {
+			found = found || h == host
+		}
+		if !found {
+			hosts = append(hosts, host)
+		}
+	}
+	noProxy = strings.Trim(strings.Join(hosts, ","), ",")
+	log.Infof("Quay will use HTTP proxy %q, HTTPS proxy %q and no proxy for %s", redactProxy(httpProxy), redactProxy(httpsProxy), noProxy)
+	return nil
+}
+
+// redactProxy replaces the user info of a proxy URL, which holds the credentials of an authenticating proxy
+func redactProxy(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		if i := strings.LastIndex(value, "@"); i >= 0 {
+			return "xxxxx" + value[i:]
+		}
+		return value
+	}
+	if u.User == nil {
+		return value
+	}
+	u.User = url.User("xxxxx")
+	return u.String()
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyCaches are the upstream registries requested with --proxy-cache
+var proxyCaches []string
+
+// defaultProxyCacheExpiration is how long Quay keeps a cached image before checking the upstream registry again
+const defaultProxyCacheExpiration = 24 * time.Hour
+
+// quayProxyCache is a parsed --proxy-cache value
+type quayProxyCache struct {
+	Organization string
+	Upstream     string
+	Username     string
+	Password     string
+	Expiration   time.Duration
+	Insecure     bool
+}
+
+// proxyCacheConfig is the proxy cache configuration of an organization in the Quay API
+type proxyCacheConfig struct {
+	OrgName    string `json:"org_name"`
+	Upstream   string `json:"upstream_registry"`
+	Username   string `json:"upstream_registry_username,omitempty"`
+	Password   string `json:"upstream_registry_password,omitempty"`
+	Expiration int    `json:"expiration_s"`
+	Insecure   bool   `json:"insecure"`
+}
+
+// nonNamespaceChars are the characters of an upstream registry replaced in the default organization name
+var nonNamespaceChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// parseProxyCaches validates the --proxy-cache values, comma separated key=value options with a required upstream.
+// The organization defaults to the upstream with its dots and slashes replaced, e.g. registry-redhat-io.
+func parseProxyCaches(values []string) ([]quayProxyCache, error) {
+	var caches []quayProxyCache
+	seen := map[string]bool{}
+	for _, value := range values {
+		cache := quayProxyCache{Expiration: defaultProxyCacheExpiration}
+		for _, option := range strings.Split(value, ",") {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("Invalid --proxy-cache option " + option + ", expected key=value")
+			}
+			key, v := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			switch key {
+			case "upstream":
+				cache.Upstream = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(v, "https://"), "http://"), "/")
+			case "org":
+				cache.Organization = v
+			case "username":
+				cache.Username = v
+			case "password":
+				registerSecret(v)
+				cache.Password = v
+			case "expiration":
+				expiration, err := time.ParseDuration(v)
+				if err != nil || expiration < time.Minute {
+					return nil, errors.New("Invalid expiration " + v + " in --proxy-cache, expected a duration of at least 1m such as 24h")
+				}
+				cache.Expiration = expiration
+			case "insecure":
+				insecure, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, errors.New("Invalid insecure " + v + " in --proxy-cache, expected true or false")
+				}
+				cache.Insecure = insecure
+			default:
+				return nil, errors.New("Unknown --proxy-cache option " + key + ", expected upstream, org, username, password, expiration or insecure")
+			}
+		}
+		if cache.Upstream == "" {
+			return nil, errors.New("Invalid --proxy-cache " + redact(value) + ", upstream is required, e.g. upstream=registry.redhat.io")
+		}
+		if (cache.Username == "") != (cache.Password == "") {
+			return nil, errors.New("The proxy cache of " + cache.Upstream + " needs both a username and a password, or neither for anonymous pulls")
+		}
+		if cache.Organization == "" {
+			cache.Organization = strings.Trim(nonNamespaceChars.ReplaceAllString(strings.ToLower(cache.Upstream), "-"), "-")
+		}
+		if len(cache.Organization) < 2 || !validNamespace.MatchString(cache.Organization) {
+			return nil, errors.New("Invalid organization name " + cache.Organization + " in --proxy-cache")
+		}
+		if seen[cache.Organization] {
+			return nil, errors.New("Organization " + cache.Organization + " is given more than once in --proxy-cache")
+		}
+		seen[cache.Organization] = true
+		caches = append(caches, cache)
+	}
+	return caches, nil
+}
+
+// applyProxyCache creates the organization if needed and configures it as a pull-through cache of the upstream
+// registry, replacing the configuration of another upstream or expiration. Credentials are checked against the
+// upstream registry before they are saved.
+func applyProxyCache(api *quayAPIClient, cache quayProxyCache) (string, error) {
+	var actions []string
+	result, err := applyOrganization(api, cache.Organization)
+	if err != nil {
+		return "", err
+	}
+	if result == "created" {
+		actions = append(actions, "organization created")
+	}
+
+	endpoint := "/organization/" + cache.Organization + "/proxycache"
+	config := proxyCacheConfig{
+		OrgName:    cache.Organization,
+		Upstream:   cache.Upstream,
+		Username:   cache.Username,
+		Password:   cache.Password,
+		Expiration: int(cache.Expiration.Seconds()),
+		Insecure:   cache.Insecure,
+	}
+	var existing proxyCacheConfig
+	status, err := api.do("GET", endpoint, nil, &existing)
+	if err != nil && status != http.StatusNotFound {
+		return "", err
+	}
+	// The API does not return the credentials, so configs with credentials are always replaced
+	if existing.Upstream == config.Upstream && existing.Expiration == config.Expiration && existing.Insecure == config.Insecure && config.Username == "" {
+		return strings.Join(append(actions, "already caches "+cache.Upstream), ", "), nil
+	}
+
+	if config.Username != "" {
+		if _, err := api.do("POST", "/organization/"+cache.Organization+"/validateproxycache", config, nil); err != nil {
+			return "", fmt.Errorf("the credentials for %s were rejected: %s", cache.Upstream, err.Error())
+		}
+	}
+	if existing.Upstream != "" {
+		if _, err := api.do("DELETE", endpoint, nil, nil); err != nil {
+			return "", err
+		}
+	}
+	if _, err := api.do("POST", endpoint, config, nil); err != nil {
+		return "", err
+	}
+	return strings.Join(append(actions, "caches "+cache.Upstream+" for "+cache.Expiration.String()), ", "), nil
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// pruneWait is how long prune waits for the garbage collection worker to reclaim space
+var pruneWait time.Duration
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Garbage collect untagged images and blobs and report the reclaimed space.",
+	Run: func(cmd *cobra.Command, args []string) {
+		prune()
+	},
+}
+
+// pruneResult is the outcome of a prune
+type pruneResult struct {
+	Host            string   `json:"host"`
+	Namespaces      []string `json:"namespaces"`
+	BeforeKiB       int64    `json:"beforeKiB"`
+	AfterKiB        int64    `json:"afterKiB"`
+	ReclaimedKiB    int64    `json:"reclaimedKiB"`
+	StillCollecting bool     `json:"stillCollecting"`
+}
+
+// namespaceExpiration is the time machine window of a namespace, which keeps untagged content from being collected
+type namespaceExpiration struct {
+	namespace string
+	endpoint  string
+	seconds   int
+}
+
+func init() {
+
+	// Add prune command
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	pruneCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	pruneCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	pruneCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	pruneCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	pruneCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+	pruneCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	pruneCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder or Podman named volume where quay persistent storage data is saved. This defaults to 'quay-storage'")
+	pruneCmd.Flags().DurationVarP(&pruneWait, "wait", "", 15*time.Minute, "How long to wait for garbage collection to reclaim space. This defaults to 15m")
+	pruneCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the result as JSON")
+}
+
+func prune() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	credentials, err := loadCredentials(targetHostname)
+	check(err)
+	if credentials["initAccessToken"] == "" {
+		check(errors.New("Cannot prune, no access token for the init user is stored in " + credentialsFile(targetHostname)))
+	}
+	api := newQuayAPIClient(quayHostname, credentials["initAccessToken"])
+
+	result := pruneResult{Host: targetHostname}
+	result.BeforeKiB, err = storageUsage()
+	check(err)
+
+	// Untagged manifests and their blobs are kept for the time machine window of their namespace, close it
+	// until the garbage collection worker caught up and restore it afterwards, even if prune fails
+	expirations, err := namespaceExpirations(api)
+	check(err)
+	restore := func() {
+		for _, expiration := range expirations {
+			if _, err := api.do("PUT", expiration.endpoint, map[string]int{"tag_expiration_s": expiration.seconds}, nil); err != nil {
+				log.Errorf("Could not restore the time machine window of %s to %ds: %s", expiration.namespace, expiration.seconds, err.Error())
+			}
+		}
+	}
+	exitHooks = append(exitHooks, func(error) { restore() })
+	for _, expiration := range expirations {
+		result.Namespaces = append(result.Namespaces, expiration.namespace)
+		if expiration.seconds == 0 {
+			continue
+		}
+		_, err := api.do("PUT", expiration.endpoint, map[string]int{"tag_expiration_s": 0}, nil)
+		check(err)
+	}
+
+	// Poll until the storage stops shrinking
+	log.Infof("Waiting up to %s for garbage collection on %s", pruneWait, targetHostname)
+	result.AfterKiB, result.StillCollecting = waitForCollection(result.BeforeKiB)
+	restore()
+
+	result.ReclaimedKiB = result.BeforeKiB - result.AfterKiB
+	if jsonOutput {
+		data, err := json.MarshalIndent(result, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	}
+	log.Infof("Reclaimed %.1f GiB on %s, %.1f GiB in use", float64(result.ReclaimedKiB)/1024/1024, targetHostname, float64(result.AfterKiB)/1024/1024)
+	if result.StillCollecting {
+		log.Warnf("Garbage collection was still reclaiming space after %s, run prune again with a longer --wait", pruneWait)
+	}
+}
+
+// namespaceExpirations returns the time machine window of every organization and of the init user
+func namespaceExpirations(api *quayAPIClient) ([]namespaceExpiration, error) {
+	var organizations struct {
+		Organizations []struct {
+			Name string `json:"name"`
+		} `json:"organizations"`
+	}
+	if _, err := api.do("GET", "/superuser/organizations/", nil, &organizations); err != nil {
+		return nil, err
+	}
+	var expirations []namespaceExpiration
+	for _, organization := range organizations.Organizations {
+		var details struct {
+			TagExpiration int `json:"tag_expiration_s"`
+		}
+		endpoint := "/organization/" + organization.Name
+		if _, err := api.do("GET", endpoint, nil, &details); err != nil {
+			return nil, err
+		}
+		expirations = append(expirations, namespaceExpiration{namespace: organization.Name, endpoint: endpoint, seconds: details.TagExpiration})
+	}
+	var user struct {
+		Username      string `json:"username"`
+		TagExpiration int    `json:"tag_expiration_s"`
+	}
+	if _, err := api.do("GET", "/user/", nil, &user); err != nil {
+		return nil, err
+	}
+	return append(expirations, namespaceExpiration{namespace: user.Username, endpoint: "/user/", seconds: user.TagExpiration}), nil
+}
+
+// waitForCollection polls the storage usage until it did not change for three polls or --wait elapsed.
+// It returns the last usage and whether it was still shrinking.
+func waitForCollection(before int64) (int64, bool) {
+	const interval = 30 * time.Second
+	usage, unchanged := before, 0
+	for deadline := time.Now().Add(pruneWait); time.Now().Before(deadline); {
+		time.Sleep(interval)
+		current, err := storageUsage()
+		if err != nil {
+			log.Warnf("Could not measure storage usage: %s", err.Error())
+			continue
+		}
+		if current < usage {
+			log.Infof("%.1f GiB reclaimed so far", float64(before-current)/1024/1024)
+			unchanged = 0
+		} else {
+			unchanged++
+		}
+		usage = current
+		if unchanged >= 3 {
+			return usage, false
+		}
+	}
+	return usage, unchanged == 0
+}
+
+// storageUsage returns the KiB used by the registry storage on the target
+func storageUsage() (int64, error) {
+	out, err := runRemoteCommand(remotePreamble() + `STORAGE=` + quayStorage + `
+if podman volume exists "$STORAGE" 2>/dev/null; then STORAGE_DIR=$(podman volume inspect --format '{{.Mountpoint}}' "$STORAGE"); else STORAGE_DIR=$STORAGE; fi
+if [ "$(id -u)" = 0 ]; then UNSHARE=""; else UNSHARE="podman unshare"; fi
+$UNSHARE du -sk "$STORAGE_DIR" | cut -f1
+`)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
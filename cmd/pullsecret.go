@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// mergePullSecret is the pull secret the auth of the registry is merged into
+var mergePullSecret string
+
+// generatePullSecretCmd represents the generate pull-secret command
+var generatePullSecretCmd = &cobra.Command{
+	Use:   "pull-secret",
+	Short: "Generate a pull secret for the installed registry, optionally merged into an existing one.",
+	Run: func(cmd *cobra.Command, args []string) {
+		generatePullSecret()
+	},
+}
+
+func init() {
+
+	// Add pull-secret command
+	generateCmd.AddCommand(generatePullSecretCmd)
+
+	generatePullSecretCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on, used to look up the stored init credentials. This defaults to $HOST")
+	generatePullSecretCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+	generatePullSecretCmd.Flags().StringVarP(&mergePullSecret, "merge", "", "", "The pull secret or ~/.docker/config.json to merge the auth into. The file is updated in place unless --output is set")
+	generatePullSecretCmd.Flags().StringVarP(&generateOutput, "output", "o", "", "The file to write the pull secret to. This defaults to stdout, or the --merge file")
+}
+
+func generatePullSecret() {
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	data := []byte("{}")
+	if mergePullSecret != "" {
+		var err error
+		data, err = ioutil.ReadFile(mergePullSecret)
+		check(err)
+		if generateOutput == "" {
+			generateOutput = mergePullSecret
+		}
+	}
+
+	pullSecret, err := withRegistryAuth(data)
+	check(err)
+
+	if generateOutput == "" {
+		fmt.Println(string(pullSecret))
+		return
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(generateOutput); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err = ioutil.WriteFile(generateOutput, append(pullSecret, '\n'), mode)
+	check(err)
+	log.Printf("Auth for %s written to %s", quayHostname, generateOutput)
+}
+
+// registryAuth returns the base64 encoded init credentials stored at install time
+func registryAuth() (string, error) {
+	credentials, err := loadCredentials(targetHostname)
+	if err != nil {
+		return "", err
+	}
+	if credentials["initUser"] == "" || credentials["initPassword"] == "" {
+		return "", errors.New("No init credentials for " + targetHostname + " are stored in " + credentialsFile(targetHostname))
+	}
+	return base64.StdEncoding.EncodeToString([]byte(credentials["initUser"] + ":" + credentials["initPassword"])), nil
+}
+
+// withRegistryAuth adds the auth of quayHostname to a pull secret, keeping its other registries and settings
+func withRegistryAuth(data []byte) ([]byte, error) {
+	var pullSecret map[string]json.RawMessage
+	if err := json.Unmarshal(data, &pullSecret); err != nil {
+		return nil, errors.New("Could not parse pull secret: " + err.Error())
+	}
+	if pullSecret == nil {
+		pullSecret = map[string]json.RawMessage{}
+	}
+	auths := map[string]json.RawMessage{}
+	if raw, ok := pullSecret["auths"]; ok {
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return nil, errors.New("Could not parse the auths of the pull secret: " + err.Error())
+		}
+	}
+
+	auth, err := registryAuth()
+	if err != nil {
+		return nil, err
+	}
+	auths[quayHostname], err = json.Marshal(map[string]string{"auth": auth})
+	if err != nil {
+		return nil, err
+	}
+	pullSecret["auths"], err = json.Marshal(auths)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(pullSecret)
+}
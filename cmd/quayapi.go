@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// quayAPIClient talks to the Quay API of an installed registry using an OAuth access token
+type quayAPIClient struct {
+	hostname string
+	token    string
+	client   *http.Client
+}
+
+// newQuayAPIClient creates a client for https://<hostname>/api/v1
+func newQuayAPIClient(hostname, token string) *quayAPIClient {
+	return &quayAPIClient{
+		hostname: hostname,
+		token:    token,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				// The registry certificate is often self-signed and not trusted by the control host
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// do sends a request and decodes a JSON response into out, if given. It returns the HTTP status code.
+func (c *quayAPIClient) do(method, endpoint string, body, out interface{}) (int, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, "https://"+c.hostname+"/api/v1"+endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	log.Debugf("Quay API request: %s %s", method, endpoint)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s returned %s: %s", method, endpoint, resp.Status, bytes.TrimSpace(data))
+	}
+	if out != nil && len(data) > 0 {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// signIn opens a web session of a user with its password, as the login page does, and returns the client holding the
+// session cookie and the CSRF token bound to it
+func signIn(hostname, username, password string) (*http.Client, string, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, "", err
+	}
+	client := newQuayAPIClient(hostname, "").client
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	base := "https://" + hostname
+
+	// Get a CSRF token bound to the session cookie
+	var csrf struct {
+		Token string `json:"csrf_token"`
+	}
+	resp, err := client.Get(base + "/csrf_token")
+	if err != nil {
+		return nil, "", err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&csrf)
+	resp.Body.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read CSRF token: %s", err.Error())
+	}
+
+	// Sign in to establish the session
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	req, err := http.NewRequest("POST", base+"/api/v1/signin", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", csrf.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("signing in as %s returned %s", username, resp.Status)
+	}
+	return client, csrf.Token, nil
+}
+
+// createAccessToken generates an OAuth access token of an application on behalf of a user.
+// Quay only issues these tokens through its web authorization flow, so this signs in with
+// the user's password and authorizes the application like the "Generate Token" page does.
+func createAccessToken(hostname, username, password, clientID string, scopes []string) (string, error) {
+	client, csrfToken, err := signIn(hostname, username, password)
+	if err != nil {
+		return "", err
+	}
+	base := "https://" + hostname
+
+	// Authorize the application, the token is returned in the fragment of the redirect
+	form := url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {base + "/oauth/localapp"},
+		"scope":         {strings.Join(scopes, " ")},
+		"response_type": {"token"},
+		"_csrf_token":   {csrfToken},
+	}
+	resp, err := client.PostForm(base+"/oauth/authorizeapp", form)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("authorizing application returned %s without a redirect", resp.Status)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		return "", err
+	}
+	if token := fragment.Get("access_token"); token != "" {
+		return token, nil
+	}
+	return "", errors.New("authorizing application did not return an access token")
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// orgQuotas are the name=size organization quotas requested with --orgQuota
+var orgQuotas []string
+
+// defaultOrgQuota is the storage quota Quay applies to every organization and user without a quota of its own
+var defaultOrgQuota string
+
+// quotaBackfill holds whether or not Quay computes the size of content pushed before quotas were enabled
+var quotaBackfill bool
+
+// validNamespace matches the organization names accepted by Quay
+var validNamespace = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// sizeUnits maps the supported size suffixes to their multiplier
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"Pi", 1 << 50},
+}
+
+// orgQuota is a parsed --orgQuota value
+type orgQuota struct {
+	Name  string `json:"name" yaml:"name"`
+	Size  string `json:"size" yaml:"size"`
+	Bytes int64  `json:"bytes" yaml:"bytes"`
+}
+
+// orgQuotaResult records what happened when applying an organization quota
+type orgQuotaResult struct {
+	orgQuota `yaml:",inline"`
+	Result   string `json:"result" yaml:"result"`
+}
+
+// parseSize converts a size such as 500Gi or 2Ti into bytes
+func parseSize(size string) (int64, error) {
+	number, multiplier := size, int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(size, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(size, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("Invalid size " + size + ", expected a positive number with an optional Ki, Mi, Gi, Ti or Pi suffix")
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, errors.New("Invalid size " + size + ", it is larger than 8Ei")
+	}
+	return n * multiplier, nil
+}
+
+// parseOrgQuotas validates the --orgQuota values
+func parseOrgQuotas(values []string) ([]orgQuota, error) {
+	var quotas []orgQuota
+	seen := map[string]bool{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid --orgQuota " + value + ", expected name=size")
+		}
+		name, size := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if len(name) < 2 || !validNamespace.MatchString(name) {
+			return nil, errors.New("Invalid organization name " + name + " in --orgQuota")
+		}
+		if seen[name] {
+			return nil, errors.New("Organization " + name + " is given more than once in --orgQuota")
+		}
+		seen[name] = true
+		bytes, err := parseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, orgQuota{Name: name, Size: size, Bytes: bytes})
+	}
+	return quotas, nil
+}
+
+// quotaVars returns the extra-vars of the quota settings, or "" when quotas are not configured. quota_management is only
+// passed to turn it on, so a re-run of install keeps it on for a registry that already uses quotas.
+func quotaVars(quotas []orgQuota) (string, error) {
+	vars := ""
+	if len(quotas) > 0 || defaultOrgQuota != "" {
+		vars = " quota_management=true"
+	}
+	if defaultOrgQuota == "" {
+		return vars, nil
+	}
+	bytes, err := parseSize(defaultOrgQuota)
+	if err != nil {
+		return "", errors.New("Invalid --defaultOrgQuota: " + err.Error())
+	}
+	return vars + fmt.Sprintf(" default_org_quota_bytes=%d quota_backfill=%t", bytes, quotaBackfill), nil
+}
+
+// applyOrgQuota creates the organization if needed and creates or updates its storage quota
+func applyOrgQuota(api *quayAPIClient, quota orgQuota) (string, error) {
+	var actions []string
+
+	status, err := api.do("GET", "/organization/"+quota.Name, nil, nil)
+	if status == http.StatusNotFound {
+		if _, err := api.do("POST", "/organization/", map[string]string{"name": quota.Name}, nil); err != nil {
+			return "", err
+		}
+		actions = append(actions, "organization created")
+	} else if err != nil {
+		return "", err
+	}
+
+	var existing []struct {
+		ID         int   `json:"id"`
+		LimitBytes int64 `json:"limit_bytes"`
+	}
+	if _, err := api.do("GET", "/organization/"+quota.Name+"/quota", nil, &existing); err != nil {
+		return "", err
+	}
+	body := map[string]int64{"limit_bytes": quota.Bytes}
+	switch {
+	case len(existing) == 0:
+		if _, err := api.do("POST", "/organization/"+quota.Name+"/quota", body, nil); err != nil {
+			return "", err
+		}
+		actions = append(actions, "quota set to "+quota.Size)
+	case existing[0].LimitBytes != quota.Bytes:
+		if _, err := api.do("PUT", fmt.Sprintf("/organization/%s/quota/%d", quota.Name, existing[0].ID), body, nil); err != nil {
+			return "", err
+		}
+		actions = append(actions, "quota updated to "+quota.Size)
+	default:
+		actions = append(actions, "quota already "+quota.Size)
+	}
+	return strings.Join(actions, ", "), nil
+}
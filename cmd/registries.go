@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// managedRegistry is a registry installed from this control host, as recorded in the state file
+type managedRegistry struct {
+	Host         string     `json:"host"`
+	QuayHostname string     `json:"quayHostname"`
+	QuayVersion  string     `json:"quayVersion"`
+	QuayRoot     string     `json:"quayRoot"`
+	QuayStorage  string     `json:"quayStorage"`
+	HA           bool       `json:"ha,omitempty"`
+	InstalledAt  time.Time  `json:"installedAt"`
+	UpgradedAt   *time.Time `json:"upgradedAt,omitempty"`
+}
+
+// registriesState is the content of the state file of the control host
+type registriesState struct {
+	Registries []managedRegistry `json:"registries"`
+}
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the registries installed from this control host.",
+	Run: func(cmd *cobra.Command, args []string) {
+		listRegistries()
+	},
+}
+
+func init() {
+
+	// Add list command
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the registries as JSON")
+}
+
+// registriesFile returns the path of the state file listing the registries installed from this control host
+func registriesFile() string {
+	return path.Join(os.Getenv("HOME"), ".mirror-registry", "state.json")
+}
+
+// loadRegistries reads the registries installed from this control host
+func loadRegistries() (*registriesState, error) {
+	state := &registriesState{Registries: []managedRegistry{}}
+	data, err := ioutil.ReadFile(registriesFile())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", registriesFile(), err.Error())
+	}
+	return state, nil
+}
+
+// save writes the registries to the state file, sorted by host. Runs hold the lock of the control host while they
+// update it, so they do not overwrite each other.
+func (s *registriesState) save() error {
+	sort.Slice(s.Registries, func(i, j int) bool { return s.Registries[i].Host < s.Registries[j].Host })
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := registriesFile()
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// recordRegistry adds or replaces the registry of a host in the state file. An upgrade keeps the install time.
+func recordRegistry(registry managedRegistry, upgraded bool) {
+	state, err := loadRegistries()
+	if err == nil {
+		now := time.Now()
+		var registries []managedRegistry
+		for _, existing := range state.Registries {
+			if existing.Host != registry.Host {
+				registries = append(registries, existing)
+			} else if upgraded {
+				registry.InstalledAt = existing.InstalledAt
+			}
+		}
+		if upgraded {
+			registry.UpgradedAt = &now
+			if registry.InstalledAt.IsZero() {
+				registry.InstalledAt = now
+			}
+		} else {
+			registry.InstalledAt = now
+		}
+		state.Registries = append(registries, registry)
+		err = state.save()
+	}
+	if err != nil {
+		log.Warnf("Could not record %s in %s: %s", registry.Host, registriesFile(), err.Error())
+	}
+}
+
+// forgetRegistry removes the registry of a host from the state file
+func forgetRegistry(host string) {
+	state, err := loadRegistries()
+	if err == nil {
+		var registries []managedRegistry
+		for _, existing := range state.Registries {
+			if existing.Host != host {
+				registries = append(registries, existing)
+			}
+		}
+		state.Registries = registries
+		err = state.save()
+	}
+	if err != nil {
+		log.Warnf("Could not remove %s from %s: %s", host, registriesFile(), err.Error())
+	}
+}
+
+// currentRegistry describes the registry of the current target from the install and upgrade flags
+func currentRegistry(version string) managedRegistry {
+	registry := managedRegistry{
+		Host:         hostOnly(targetHostname),
+		QuayHostname: quayHostname,
+		QuayVersion:  version,
+		QuayRoot:     quayRoot,
+		QuayStorage:  quayStorage,
+	}
+	// An HA install is reached through its load balancer rather than a single target
+	if haMode {
+		registry.Host = hostOnly(quayHostname)
+		registry.HA = true
+	}
+	return registry
+}
+
+func listRegistries() {
+
+	state, err := loadRegistries()
+	check(err)
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(state.Registries, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(state.Registries) == 0 {
+		log.Infof("No registries were installed from this control host")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tURL\tVERSION\tQUAY ROOT\tSTORAGE\tINSTALLED\tUPGRADED")
+	for _, registry := range state.Registries {
+		host := registry.Host
+		if registry.HA {
+			host += " (HA)"
+		}
+		upgraded := "-"
+		if registry.UpgradedAt != nil {
+			upgraded = registry.UpgradedAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\thttps://%s\t%s\t%s\t%s\t%s\t%s\n", host, registry.QuayHostname, registry.QuayVersion, registry.QuayRoot, registry.QuayStorage, registry.InstalledAt.Local().Format("2006-01-02 15:04"), upgraded)
+	}
+	w.Flush()
+}
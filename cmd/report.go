@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// reportFile is the path the install report is written to
+var reportFile string
+
+// secretFlags lists the flags whose values must never be written to a report or log
+var secretFlags = map[string]bool{
+	"azure-account-key": true,
+	"gcs-secret-key":    true,
+	"initPassword":      true,
+	"ldapBindPassword":  true,
+	"oidcClientSecret":  true,
+	"pgPassword":        true,
+	"proxy-cache":       true,
+	"redisPassword":     true,
+	"s3-secret-key":     true,
+	"smtpPassword":      true,
+	"users":             true,
+}
+
+// proxyFlags lists the flags holding proxy URLs, which are written to a report without their credentials
+var proxyFlags = map[string]bool{
+	"httpProxy":  true,
+	"httpsProxy": true,
+}
+
+// reportImage describes an image deployed by the installer
+type reportImage struct {
+	Reference string `json:"reference" yaml:"reference"`
+	Digest    string `json:"digest,omitempty" yaml:"digest,omitempty"`
+	ID        string `json:"id,omitempty" yaml:"id,omitempty"`
+}
+
+// reportPhase records how long a single phase of the install took and how it ended
+type reportPhase struct {
+	Name     string `json:"name" yaml:"name"`
+	Duration string `json:"duration" yaml:"duration"`
+	Result   string `json:"result" yaml:"result"`
+
+	start time.Time
+}
+
+// installReport is the audit record written at the end of every install, successful or not
+type installReport struct {
+	Operator       string                 `json:"operator" yaml:"operator"`
+	StartedAt      time.Time              `json:"startedAt" yaml:"startedAt"`
+	FinishedAt     time.Time              `json:"finishedAt" yaml:"finishedAt"`
+	Duration       string                 `json:"duration" yaml:"duration"`
+	ReleaseVersion string                 `json:"releaseVersion" yaml:"releaseVersion"`
+	TargetHostname string                 `json:"targetHostname" yaml:"targetHostname"`
+	TargetUsername string                 `json:"targetUsername" yaml:"targetUsername"`
+	QuayHostname   string                 `json:"quayHostname" yaml:"quayHostname"`
+	Images         map[string]reportImage `json:"images,omitempty" yaml:"images,omitempty"`
+	Options        map[string]string      `json:"options" yaml:"options"`
+	Phases         []*reportPhase         `json:"phases" yaml:"phases"`
+	Existing       *existingInstall       `json:"existingInstall,omitempty" yaml:"existingInstall,omitempty"`
+	Runtime        *runtimeFacts          `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	HealthCheck    string                 `json:"healthCheck" yaml:"healthCheck"`
+	OrgQuotas      []orgQuotaResult       `json:"orgQuotas,omitempty" yaml:"orgQuotas,omitempty"`
+	Organizations  []namespaceResult      `json:"organizations,omitempty" yaml:"organizations,omitempty"`
+	Repositories   []namespaceResult      `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	ProxyCaches    []namespaceResult      `json:"proxyCaches,omitempty" yaml:"proxyCaches,omitempty"`
+	Users          []namespaceResult      `json:"users,omitempty" yaml:"users,omitempty"`
+	APIToken       string                 `json:"apiToken,omitempty" yaml:"apiToken,omitempty"`
+	Result         string                 `json:"result" yaml:"result"`
+	Error          string                 `json:"error,omitempty" yaml:"error,omitempty"`
+
+	deployed map[string]deployedImage
+}
+
+// newInstallReport starts a report for the given command flags
+func newInstallReport(flags *pflag.FlagSet) *installReport {
+	operator := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		operator = u.Username
+	}
+
+	// Record every flag value, omitting secrets
+	options := map[string]string{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if secretFlags[f.Name] {
+			if f.Changed {
+				options[f.Name] = "<redacted>"
+			}
+			return
+		}
+		if proxyFlags[f.Name] {
+			options[f.Name] = redactProxy(f.Value.String())
+			return
+		}
+		options[f.Name] = f.Value.String()
+	})
+
+	return &installReport{
+		Operator:       operator,
+		StartedAt:      time.Now(),
+		ReleaseVersion: releaseVersion,
+		Options:        options,
+		HealthCheck:    "not run",
+	}
+}
+
+// startPhase finishes the current phase successfully and begins timing a new one
+func (r *installReport) startPhase(name string) {
+	r.endPhase("success")
+	r.Phases = append(r.Phases, &reportPhase{Name: name, start: time.Now()})
+}
+
+// endPhase finishes the current phase, if any, with the given result
+func (r *installReport) endPhase(result string) {
+	if len(r.Phases) == 0 {
+		return
+	}
+	p := r.Phases[len(r.Phases)-1]
+	if p.Result != "" {
+		return
+	}
+	p.Duration = time.Since(p.start).Round(time.Millisecond).String()
+	p.Result = result
+}
+
+// finish records the final outcome and writes the report to reportFile
+func (r *installReport) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+	if err != nil {
+		r.endPhase("failed")
+		r.Result = "failed"
+		r.Error = err.Error()
+	} else {
+		r.endPhase("success")
+		r.Result = "success"
+	}
+
+	r.TargetHostname = targetHostname
+	r.TargetUsername = targetUsername
+	r.QuayHostname = quayHostname
+	r.Images = r.images()
+
+	if err := r.write(reportFile); err != nil {
+		log.Warnf("Could not write install report: %s", err.Error())
+		return
+	}
+	log.Infof("Install report written to %s", reportFile)
+}
+
+// write serializes the report as YAML or JSON depending on the file extension
+func (r *installReport) write(file string) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		data, err = yaml.Marshal(r)
+	default:
+		data, err = json.MarshalIndent(r, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// recordImages sets the component images the playbook deploys, as returned by pinImages. recordImageIDs completes
+// them with the IDs of the running images before the report is finished.
+func (r *installReport) recordImages(deployed map[string]deployedImage) {
+	r.deployed = deployed
+}
+
+// images returns the images deployed by the install, none if it failed before running the playbook
+func (r *installReport) images() map[string]reportImage {
+	if r.deployed == nil {
+		return nil
+	}
+	images := map[string]reportImage{"pause": {Reference: pauseImage}}
+	for name, image := range r.deployed {
+		images[name] = reportImage{Reference: image.Reference, Digest: image.Digest, ID: image.ID}
+	}
+	if withMonitoring {
+		if pgHost == "" {
+			images["postgres-exporter"] = reportImage{Reference: postgresExporterImage}
+		}
+		if redisHost == "" {
+			images["redis-exporter"] = reportImage{Reference: redisExporterImage}
+		}
+		if monitoringStack {
+			images["prometheus"] = reportImage{Reference: prometheusImage}
+			images["grafana"] = reportImage{Reference: grafanaImage}
+		}
+	}
+	if haMode {
+		images["haproxy"] = reportImage{Reference: haproxyImage}
+	}
+	return images
+}
+
+// defaultReportFile returns the default install report location
+func defaultReportFile() string {
+	return path.Join(os.Getenv("HOME"), ".mirror-registry", "logs", time.Now().Format("20060102-150405")+"-install-report.json")
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-password/password"
+	"github.com/spf13/cobra"
+)
+
+// dbPasswordStdin controls whether the new database password is read from stdin
+var dbPasswordStdin bool
+
+// validDBPassword restricts user-supplied database passwords to characters that are safe inside DB_URI
+var validDBPassword = regexp.MustCompile(`^[A-Za-z0-9._~-]{8,}$`)
+
+// resetDBPasswordCmd represents the reset-db-password command
+var resetDBPasswordCmd = &cobra.Command{
+	Use:   "reset-db-password",
+	Short: "Rotate the PostgreSQL password used by Quay.",
+	Run: func(cmd *cobra.Command, args []string) {
+		resetDBPassword()
+	},
+}
+
+func init() {
+
+	// Add reset-db-password command
+	rootCmd.AddCommand(resetDBPasswordCmd)
+
+	resetDBPasswordCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	resetDBPasswordCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	resetDBPasswordCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	resetDBPasswordCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	resetDBPasswordCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	resetDBPasswordCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, used for the health check. This defaults to <targetHostname>:8443")
+	resetDBPasswordCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	resetDBPasswordCmd.Flags().BoolVarP(&dbPasswordStdin, "dbPassword-stdin", "", false, "Read the new database password from stdin. If not set, a password is randomly generated.")
+	resetDBPasswordCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the result, including the new password, as JSON")
+}
+
+func resetDBPassword() {
+
+	var err error
+	log.Printf("Database password reset has begun")
+
+	// Generate password if none provided
+	var newPassword string
+	if dbPasswordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			check(errors.New("Could not read database password from stdin: " + err.Error()))
+		}
+		newPassword = strings.TrimSpace(line)
+		if !validDBPassword.MatchString(newPassword) {
+			check(errors.New("Database password must be at least 8 characters long and only contain letters, digits and . _ ~ -"))
+		}
+	} else {
+		newPassword, err = password.Generate(32, 10, 0, false, false)
+		check(err)
+	}
+
+	// The password is part of the remote script, keep it out of the debug log
+	registerSecret(newPassword)
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	err = loadSSHKeys()
+	check(err)
+
+	// An external database is not managed by the installer, its password is rotated on the database server
+	out, err := runRemoteCommand(remotePreamble() + `if [ ! -f "$UNIT_DIR/quay-postgres.service" ]; then echo external; fi` + "\n")
+	check(err)
+	if strings.TrimSpace(out) == "external" {
+		check(errors.New("There is no quay-postgres service on " + targetHostname + ", Quay uses an external database. Change the password on the database server and re-run install with the new --pgPassword"))
+	}
+
+	// Apply the password to postgres and everything connecting to it, keeping backups for rollback
+	log.Printf("Applying new database password on %s", targetHostname)
+	_, err = runRemoteCommand(dbPasswordScript(newPassword))
+	if err == nil {
+		log.Printf("Waiting for Quay to become healthy at https://%s/health/instance", quayHostname)
+		err = waitForQuayHealth(quayHostname, 18, 10*time.Second)
+	}
+	if err != nil {
+		log.Errorf("Database password reset failed, rolling back: %s", err.Error())
+		if _, rollbackErr := runRemoteCommand(dbPasswordRollbackScript()); rollbackErr != nil {
+			log.Errorf("Rollback failed, restore %s/quay-config/config.yaml.bak manually", quayRoot)
+		}
+		check(err)
+	}
+	_, err = runRemoteCommand(dbPasswordPreamble() + `rm -f "$CONFIG.bak" "$UNIT_DIR/quay-postgres.service.bak" "$CLAIR_CONFIG.bak" "$EXPORTER_UNIT.bak"` + "\n")
+	check(err)
+
+	file, err := saveCredentials(targetHostname, map[string]string{"dbPassword": newPassword})
+	check(err)
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(map[string]string{
+			"host":            targetHostname,
+			"dbPassword":      newPassword,
+			"credentialsFile": file,
+		}, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	}
+	log.Printf("Database password reset successfully, the new password is stored in %s", file)
+}
+
+// dbPasswordPreamble locates the Quay config, the other consumers of the database password and the database user on
+// the target
+func dbPasswordPreamble() string {
+	return remotePreamble() + `CLAIR_CONFIG=` + quayRoot + `/clair-config/config.yaml
+EXPORTER_UNIT=$UNIT_DIR/quay-postgres-exporter.service
+DB_USER=$(sed -n 's#^DB_URI: postgresql://\([^:]*\):.*#\1#p' "$CONFIG")
+`
+}
+
+// restartDBClientsCommands restarts Quay and the optional services connecting to the database, after
+// dbPasswordPreamble
+const restartDBClientsCommands = `$SC daemon-reload
+$SC restart quay-app.service
+for u in quay-clair quay-postgres-exporter quay-mirror; do
+    if [ -f "$UNIT_DIR/$u.service" ]; then $SC restart $u.service; fi
+done
+`
+
+// dbPasswordScript changes the database role password and every place that references it
+func dbPasswordScript(newPassword string) string {
+	return dbPasswordPreamble() + `NEW_PASSWORD='` + newPassword + `'
+cp -p "$CONFIG" "$CONFIG.bak"
+cp -p "$UNIT_DIR/quay-postgres.service" "$UNIT_DIR/quay-postgres.service.bak"
+for f in "$CLAIR_CONFIG" "$EXPORTER_UNIT"; do
+    if [ -f "$f" ]; then cp -p "$f" "$f.bak"; fi
+done
+echo "ALTER ROLE \"$DB_USER\" WITH PASSWORD '$NEW_PASSWORD';" | podman exec -i quay-postgres psql -d quay -U postgres
+sed -i "s#^\(DB_URI: postgresql://[^:]*:\)[^@]*@#\1$NEW_PASSWORD@#" "$CONFIG"
+sed -i "s#POSTGRESQL_PASSWORD=[^ ]*#POSTGRESQL_PASSWORD=$NEW_PASSWORD#" "$UNIT_DIR/quay-postgres.service"
+if [ -f "$CLAIR_CONFIG" ]; then sed -i "s#\(connstring: .* password=\)[^ ]*#\1$NEW_PASSWORD#" "$CLAIR_CONFIG"; fi
+if [ -f "$EXPORTER_UNIT" ]; then sed -i "s#DATA_SOURCE_PASS=[^ ]*#DATA_SOURCE_PASS=$NEW_PASSWORD#" "$EXPORTER_UNIT"; fi
+if grep -q "quay-postgres-password" "$UNIT_DIR/quay-postgres.service"; then
+    podman secret rm quay-postgres-password >/dev/null
+    printf '%s' "$NEW_PASSWORD" | podman secret create quay-postgres-password - >/dev/null
+fi
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+` + restartDBClientsCommands
+}
+
+// dbPasswordRollbackScript restores the backups taken by dbPasswordScript and the previous role password
+func dbPasswordRollbackScript() string {
+	return dbPasswordPreamble() + `mv -f "$CONFIG.bak" "$CONFIG"
+mv -f "$UNIT_DIR/quay-postgres.service.bak" "$UNIT_DIR/quay-postgres.service"
+for f in "$CLAIR_CONFIG" "$EXPORTER_UNIT"; do
+    if [ -f "$f.bak" ]; then mv -f "$f.bak" "$f"; fi
+done
+OLD_PASSWORD=$(sed -n 's#^DB_URI: postgresql://[^:]*:\([^@]*\)@.*#\1#p' "$CONFIG")
+echo "ALTER ROLE \"$DB_USER\" WITH PASSWORD '$OLD_PASSWORD';" | podman exec -i quay-postgres psql -d quay -U postgres
+if grep -q "quay-postgres-password" "$UNIT_DIR/quay-postgres.service"; then
+    podman secret rm quay-postgres-password >/dev/null
+    printf '%s' "$OLD_PASSWORD" | podman secret create quay-postgres-password - >/dev/null
+fi
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+` + restartDBClientsCommands
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-password/password"
+	"github.com/spf13/cobra"
+)
+
+// resetPasswordCmd represents the reset-password command
+var resetPasswordCmd = &cobra.Command{
+	Use:   "reset-password",
+	Short: "Set a new password for the init user.",
+	Run: func(cmd *cobra.Command, args []string) {
+		resetPassword()
+	},
+}
+
+func init() {
+
+	// Add reset-password command
+	rootCmd.AddCommand(resetPasswordCmd)
+
+	resetPasswordCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	resetPasswordCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	resetPasswordCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	resetPasswordCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	resetPasswordCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	resetPasswordCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install. This defaults to <targetHostname>:8443")
+	resetPasswordCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	resetPasswordCmd.Flags().StringVarP(&initUser, "initUser", "", "init", "The username of the initial user. This defaults to init.")
+	resetPasswordCmd.Flags().BoolVarP(&initPasswordStdin, "initPassword-stdin", "", false, "Read the new password from stdin. If not set, $MIRROR_REGISTRY_INIT_PASSWORD is used or a password is randomly generated.")
+	resetPasswordCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the result, including the new password, as JSON")
+}
+
+func resetPassword() {
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	if !validNamespace.MatchString(initUser) {
+		check(errors.New("Invalid --initUser " + initUser))
+	}
+	err := loadInitPassword()
+	check(err)
+	if strings.Contains(initPassword, "\n") {
+		check(errors.New("The password cannot span several lines"))
+	}
+	if initPassword == "" {
+		initPassword, err = password.Generate(32, 10, 0, false, false)
+		check(err)
+	}
+	registerSecret(initPassword)
+
+	// Prefer the API, fall back to the database when the access token of the init user was lost too
+	credentials, err := loadCredentials(targetHostname)
+	check(err)
+	method := "API"
+	if credentials["initAccessToken"] != "" {
+		err = setUserPassword(newQuayAPIClient(quayHostname, credentials["initAccessToken"]), initUser, initPassword)
+		if err != nil {
+			log.Warnf("Could not set the password through the API, updating the database instead: %s", err.Error())
+		}
+	}
+	if credentials["initAccessToken"] == "" || err != nil {
+		method = "database"
+		err = loadSSHKeys()
+		check(err)
+		_, err = runRemoteCommand(userPasswordScript(initUser, initPassword))
+		check(err)
+	}
+	log.Infof("Password of %s reset through the %s", initUser, method)
+
+	// Only the credentials of the init user of the install are kept, not those of other users reset with --initUser
+	file := ""
+	if credentials["initUser"] == "" || credentials["initUser"] == initUser {
+		file, err = saveCredentials(targetHostname, map[string]string{"initUser": initUser, "initPassword": initPassword})
+		check(err)
+		if method == "API" {
+			err = loadSSHKeys()
+		}
+		if err == nil {
+			err = saveTargetCredentials(map[string]string{"initUser": initUser, "initPassword": initPassword, "quayHostname": quayHostname})
+		}
+		if err != nil {
+			log.Warnf("Could not update the init credentials stored on %s: %s", targetHostname, err.Error())
+		}
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(map[string]string{
+			"host":            targetHostname,
+			"user":            initUser,
+			"password":        initPassword,
+			"credentialsFile": file,
+		}, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	}
+	if file == "" {
+		log.WithField(revealSecrets, true).Printf("Quay is available at %s with credentials (%s, %s)", "https://"+quayHostname, initUser, initPassword)
+		return
+	}
+	log.WithField(revealSecrets, true).Printf("Quay is available at %s with credentials (%s, %s), also stored in %s", "https://"+quayHostname, initUser, initPassword, file)
+}
+
+// userPasswordScript hashes the password with the bcrypt module of the Quay container and stores it in the bundled
+// Postgres. The password is passed on stdin to keep it out of the process list of the target.
+func userPasswordScript(username, newPassword string) string {
+	return remotePreamble() + `if ! podman container exists quay-postgres; then
+    echo "The init user password of an external PostgreSQL database can only be reset through the API" >&2
+    exit 1
+fi
+HASH=$(podman exec -i quay-app python3 -c 'import bcrypt, sys; print(bcrypt.hashpw(sys.stdin.read().rstrip("\n").encode("utf-8"), bcrypt.gensalt()).decode("utf-8"))' <<'MIRROR_REGISTRY_PASSWORD'
+` + newPassword + `
+MIRROR_REGISTRY_PASSWORD
+)
+RESULT=$(echo "UPDATE \"user\" SET password_hash = '$HASH' WHERE username = '` + username + `';" | podman exec -i quay-postgres psql -d quay -U postgres -tA)
+if [ "$RESULT" != "UPDATE 1" ]; then
+    echo "User ` + username + ` does not exist" >&2
+    exit 1
+fi
+`
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// restoreFrom is the path of the backup archive to restore
+var restoreFrom string
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Rebuild a mirror registry from a backup archive.",
+	Run: func(cmd *cobra.Command, args []string) {
+		restore(cmd)
+	},
+}
+
+func init() {
+
+	// Add restore command
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVarP(&restoreFrom, "from", "", "", "The path of the backup archive created by the backup command")
+	restoreCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target you wish to restore Quay to. This defaults to $HOST")
+	restoreCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	restoreCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	restoreCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	restoreCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	restoreCmd.Flags().StringVarP(&eeArchivePath, "eeArchive", "", "", "The path of execution-environment.tar. This defaults to the directory of the mirror-registry binary")
+	restoreCmd.Flags().StringVarP(&imageArchivePath, "image-archive", "i", "", "An archive containing images")
+	restoreCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The value to set SERVER_HOSTNAME in the Quay config.yaml. This defaults to <targetHostname>:8443")
+	restoreCmd.Flags().BoolVarP(&askBecomePass, "askBecomePass", "", false, "Whether or not to ask for sudo password during SSH connection.")
+	restoreCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	restoreCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder where quay persistent storage data is saved. This defaults to a Podman named volume 'quay-storage'. Root is required to uninstall.")
+	restoreCmd.Flags().StringVarP(&pgStorage, "pgStorage", "", "pg-storage", "The folder where postgres persistent storage data is saved. This defaults to a Podman named volume 'pg-storage'. Root is required to uninstall.")
+	restoreCmd.MarkFlagRequired("from")
+}
+
+func restore(cmd *cobra.Command) {
+
+	log.Printf("Restore has begun")
+
+	if !pathExists(restoreFrom) {
+		check(errors.New("Could not find backup archive " + restoreFrom))
+	}
+
+	// The restored database can only be decrypted with the secret keys of the backed up install
+	config, err := exec.Command("tar", "-xzf", restoreFrom, "-O", "quay-config/config.yaml").Output()
+	if err != nil {
+		check(errors.New("Could not read quay-config/config.yaml from " + restoreFrom + ", is it a backup archive?"))
+	}
+	for _, key := range secretKeys {
+		match := regexp.MustCompile(`(?m)^` + key.name + `: "?([^"\n]+)`).FindSubmatch(config)
+		if match == nil {
+			check(errors.New("The backup config.yaml has no " + key.name))
+		}
+		os.Setenv(key.env, string(match[1]))
+	}
+
+	// Provision the services with the install playbook
+	install(cmd.Flags())
+
+	// Replace the database and storage with the backup
+	remoteArchive := fmt.Sprintf("mirror-registry-restore-%s.tar.gz", time.Now().Format("20060102-150405"))
+	log.Printf("Copying %s to %s", restoreFrom, targetHostname)
+	err = copyToRemote(restoreFrom, remoteArchive)
+	check(err)
+
+	log.Printf("Restoring database and storage on %s. This may take some time.", targetHostname)
+	_, err = runRemoteCommand(restoreScript(remoteArchive))
+	check(err)
+
+	log.Printf("Waiting for Quay to become healthy at https://%s/health/instance", quayHostname)
+	err = waitForQuayHealth(quayHostname, 18, 10*time.Second)
+	check(err)
+
+	log.Printf("Quay restored successfully from %s, log in with the credentials of the backed up install", restoreFrom)
+}
+
+// restoreScript loads the database dump and storage of a backup archive in the home of the target user and restarts Quay
+func restoreScript(archive string) string {
+	return remotePreamble() + `set -o pipefail
+ARCHIVE=$HOME/` + archive + `
+STAGE=$(mktemp -d)
+trap 'rm -rf "$STAGE" "$ARCHIVE"' EXIT
+STORAGE=` + quayStorage + `
+if podman volume exists "$STORAGE" 2>/dev/null; then STORAGE_DIR=$(podman volume inspect --format '{{.Mountpoint}}' "$STORAGE"); else STORAGE_DIR=$STORAGE; fi
+if [ "$(id -u)" = 0 ]; then UNSHARE=""; else UNSHARE="podman unshare"; fi
+DB_USER=$(sed -n 's#^DB_URI: postgresql://\([^:]*\):.*#\1#p' "$CONFIG")
+
+tar -C "$STAGE" -xzf "$ARCHIVE"
+$SC stop quay-app.service
+
+podman exec quay-postgres dropdb -U postgres quay
+podman exec quay-postgres createdb -U postgres -O "$DB_USER" quay
+podman exec -i quay-postgres psql -q -v ON_ERROR_STOP=1 -U postgres -d quay < "$STAGE/quay.sql"
+
+$UNSHARE find "$STORAGE_DIR" -mindepth 1 -delete
+$UNSHARE tar -C "$STORAGE_DIR" -xf "$STAGE/storage.tar"
+
+$SC start quay-app.service
+`
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"time"
+)
+
+// retries is the number of times a transient failure of SSH or of loading the execution environment is retried
+var retries int
+
+// retryDelay is the number of seconds before the first retry, doubled for every further one
+var retryDelay int
+
+// retry runs fn until it succeeds, retryable reports its error as permanent, or the retries run out. The delay between
+// attempts starts at --retryDelay and doubles each time.
+func retry(what string, retryable func(error) bool, fn func() error) error {
+	delay := time.Duration(retryDelay) * time.Second
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= retries || !retryable(err) {
+			return err
+		}
+		log.Warnf("Could not %s, retrying in %s (%d of %d): %s", what, delay, attempt+1, retries, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isSSHFailure reports whether ssh could not connect to or authenticate with the target, e.g. while it is unreachable
+// or its name does not resolve yet
+func isSSHFailure(err error) bool {
+	return exitCode(err) == exitSSH
+}
+
+// anyFailure retries every error
+func anyFailure(error) bool {
+	return true
+}
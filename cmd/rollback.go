@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"io"
+	"os"
+)
+
+// rollbackOnFailure holds whether or not to uninstall what a failed install playbook created on the target
+var rollbackOnFailure bool
+
+// rollbackInstall runs the uninstall playbook, deleting quayRoot and the storage, after the install playbook failed on
+// a target without a previous install. The output is appended to the playbook log.
+func rollbackInstall(output io.Writer, askBecomePassFlag string) {
+	log.Warnf("Rolling back the failed install on %s", targetHostname)
+
+	// The SSH key copy of the install may already be gone, so the rollback mounts its own
+	sshPodmanFlags, sshAnsibleFlags, cleanup, err := sshAuthFlags()
+	if err != nil {
+		log.Errorf("Could not roll back the install, run mirror-registry uninstall --autoApprove to clean up %s: %s", targetHostname, err.Error())
+		return
+	}
+	defer cleanup()
+
+	playbookCmd := uninstallPlaybookCommand(sshPodmanFlags, sshAnsibleFlags, askBecomePassFlag, true)
+	log.Debug("Running command: " + playbookCmd.String())
+	cmd := playbookCmd.exec()
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := runPlaybook(cmd); err != nil {
+		log.Errorf("Rolling back the install failed, run mirror-registry uninstall --autoApprove to clean up %s: %s", targetHostname, err.Error())
+		return
+	}
+	log.Infof("Rolled back the install, removed the Quay services, containers and data created on %s", targetHostname)
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -12,6 +14,7 @@ import (
 var log = &logrus.Logger{
 	Out:   os.Stdout,
 	Level: logrus.InfoLevel,
+	Hooks: make(logrus.LevelHooks),
 }
 
 // verbose is the optional command that will display INFO logs
@@ -20,12 +23,22 @@ var verbose bool
 // noColor is the optional flag for controlling ANSI sequence output
 var noColor bool
 
+// logFormat selects text or json log output
+var logFormat string
+
 // version is an optional command that will display the current release version
 var releaseVersion string
 
+// gitCommit is the commit the binary was built from, set at build time via ldflags
+var gitCommit string
+
+// buildDate is the UTC time the binary was built, set at build time via ldflags
+var buildDate string
+
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Display verbose logs")
 	rootCmd.PersistentFlags().BoolVarP(&noColor, "no-color", "c", false, "Control colored output")
+	rootCmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", "text", "The format of log output, text or json. With json, every log line and ansible task result is a JSON event. This defaults to text")
 }
 
 var (
@@ -38,18 +51,57 @@ var (
 			} else {
 				log.SetLevel(logrus.InfoLevel)
 			}
+			setLogFormat()
+
+			// Keep stdout clean for machine-readable output
+			if logFormat == "json" {
+				return
+			}
+			if jsonOutput {
+				log.Out = os.Stderr
+				return
+			}
+			fmt.Println(banner)
 		},
 	}
 )
 
 // Execute executes the root command.
 func Execute() error {
-	log.SetFormatter(&logrus.TextFormatter{
-		DisableColors:   noColor,
-		TimestampFormat: "2006-01-02 15:04:05",
-		FullTimestamp:   true,
-	})
-	fmt.Println(`
+	return rootCmd.Execute()
+}
+
+// quietPreRun replaces the root PersistentPreRun for commands whose stdout is meant to be piped or redirected,
+// it skips the banner and logs to stderr
+func quietPreRun(cmd *cobra.Command, args []string) {
+	if verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+	setLogFormat()
+	log.Out = os.Stderr
+}
+
+// setLogFormat configures the logger for the --log-format flag
+func setLogFormat() {
+	switch logFormat {
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
+	case "text":
+		log.SetFormatter(&logrus.TextFormatter{
+			DisableColors:   noColor,
+			TimestampFormat: "2006-01-02 15:04:05",
+			FullTimestamp:   true,
+		})
+	default:
+		log.SetFormatter(&logrus.TextFormatter{DisableColors: noColor, FullTimestamp: true})
+		check(errors.New("Invalid --log-format " + logFormat + ", expected text or json"))
+	}
+}
+
+// banner is printed at the start of every human-readable run
+const banner = `
    __   __
   /  \ /  \     ______   _    _     __   __   __
  / /\ / /\ \   /  __  \ | |  | |   /  \  \ \ / /
@@ -59,6 +111,4 @@ func Execute() error {
   \__/ \__/      \ \__
                   \___\ by Red Hat
  Build, Store, and Distribute your Containers
-	`)
-	return rootCmd.Execute()
-}
+	`
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runtimeName is the container engine requested with --runtime, empty to detect it
+var runtimeName string
+
+// eeRuntime is the container engine loading and running the execution environment on the control host
+var eeRuntime containerRuntime = podmanRuntime{}
+
+// minDockerVersion is the oldest Docker engine the installer supports on the control host
+const minDockerVersion = "20.10"
+
+// containerRuntime is a container engine able to load and run the execution environment on the control host.
+// Quay itself always runs on podman on the target.
+type containerRuntime interface {
+	// name returns the command of the engine
+	name() string
+	// check fails with the minimum requirement when the engine cannot run the execution environment
+	check() error
+	// importCommand returns the command importing an image tarball from stdin
+	importCommand() string
+	// graphRoot returns the directory the engine stores images in
+	graphRoot() (string, error)
+	// rootless reports whether the engine runs containers without root privileges
+	rootless() bool
+	// imageExists reports whether an image is present in the storage of the engine
+	imageExists(reference string) bool
+}
+
+// loadContainerRuntime selects the engine of --runtime, or podman and then Docker, whichever is installed, and checks it
+func loadContainerRuntime() error {
+	switch runtimeName {
+	case "podman":
+		eeRuntime = podmanRuntime{}
+	case "docker":
+		eeRuntime = dockerRuntime{}
+	case "":
+		_, podmanErr := exec.LookPath("podman")
+		_, dockerErr := exec.LookPath("docker")
+		switch {
+		case podmanErr == nil || os.Getenv("CONTAINER_HOST") != "":
+			eeRuntime = podmanRuntime{}
+		case dockerErr == nil:
+			log.Info("podman was not found on the control host, running the execution environment with Docker")
+			eeRuntime = dockerRuntime{}
+		default:
+			return fmt.Errorf("Neither podman nor docker was found on the control host, install podman %s or later", minPodmanVersion)
+		}
+	default:
+		return errors.New("Invalid --runtime " + runtimeName + ", must be podman or docker")
+	}
+	return eeRuntime.check()
+}
+
+// dockerRuntime runs the execution environment with the Docker engine
+type dockerRuntime struct{}
+
+func (dockerRuntime) name() string {
+	return "docker"
+}
+
+func (dockerRuntime) check() error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker was not found on the control host, install Docker %s or later", minDockerVersion)
+	}
+	out, err := exec.Command("docker", "version", "--format", "{{.Server.Version}}").Output()
+	if err != nil {
+		return fmt.Errorf("could not reach the Docker daemon on the control host, make sure it runs and %s may use it: %s", os.Getenv("USER"), err.Error())
+	}
+	version := strings.TrimSpace(string(out))
+	log.Debugf("Control host runs Docker %s", version)
+	switch {
+	case !versionAtLeast(version, minDockerVersion):
+		return fmt.Errorf("Docker %s on the control host is not supported, at least Docker %s is required", version, minDockerVersion)
+	case networkMode == "slirp4netns":
+		return errors.New("--networkMode slirp4netns is not supported by Docker, use host or bridge")
+	}
+	return nil
+}
+
+func (dockerRuntime) importCommand() string {
+	return "docker import"
+}
+
+func (dockerRuntime) graphRoot() (string, error) {
+	out, err := exec.Command("docker", "info", "--format", "{{.DockerRootDir}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the Docker image storage: %s", err.Error())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (dockerRuntime) rootless() bool {
+	out, err := exec.Command("docker", "info", "--format", "{{.SecurityOptions}}").Output()
+	return err == nil && strings.Contains(string(out), "rootless")
+}
+
+func (dockerRuntime) imageExists(reference string) bool {
+	return exec.Command("docker", "image", "inspect", reference).Run() == nil
+}
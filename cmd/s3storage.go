@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Endpoint is the URL or host[:port] of the S3 compatible object storage, e.g. https://minio.example.com:9000
+var s3Endpoint string
+
+// s3Bucket is the bucket Quay stores image blobs in
+var s3Bucket string
+
+// s3AccessKey is the access key of the S3 compatible object storage
+var s3AccessKey string
+
+// s3SecretKey is the secret key of s3AccessKey
+var s3SecretKey string
+
+// s3Region is the region requests to the object storage are signed for
+var s3Region string
+
+// s3PreflightKey is the object written and deleted to check access to the bucket, below the storage path of Quay
+const s3PreflightKey = "datastorage/registry/.mirror-registry-preflight"
+
+// validS3Bucket matches S3 bucket names
+var validS3Bucket = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3Location is a parsed --s3-endpoint
+type s3Location struct {
+	Host   string
+	Port   int
+	Secure bool
+}
+
+// s3Target is a bucket of S3 compatible object storage with the credentials requests to it are signed with
+type s3Target struct {
+	s3Location
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// s3Error is the error document returned by S3 compatible object storage
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// validateS3Storage checks the object storage flags. The secret key can also be passed in $MIRROR_REGISTRY_S3_SECRET_KEY.
+func validateS3Storage() error {
+	if s3SecretKey == "" {
+		s3SecretKey = os.Getenv(s3SecretKeyEnv)
+	}
+	if s3Endpoint == "" || s3Bucket == "" || s3AccessKey == "" || s3SecretKey == "" {
+		return errors.New("--storage s3 requires --s3-endpoint, --s3-bucket, --s3-access-key and --s3-secret-key")
+	}
+	if !validS3Bucket.MatchString(s3Bucket) {
+		return errors.New("Invalid --s3-bucket " + s3Bucket + ", bucket names are 3 to 63 lowercase letters, digits, dots and hyphens")
+	}
+	if strings.ContainsAny(s3AccessKey, " \"'") {
+		return errors.New("--s3-access-key may not contain spaces or quotes")
+	}
+	registerSecret(s3SecretKey)
+	_, err := parseS3Endpoint(s3Endpoint)
+	return err
+}
+
+// parseS3Endpoint returns the host, port and scheme of --s3-endpoint. A bare host[:port] uses HTTPS.
+func parseS3Endpoint(endpoint string) (s3Location, error) {
+	var location s3Location
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "https" && u.Scheme != "http") || strings.Trim(u.Path, "/") != "" {
+		return location, errors.New("Invalid --s3-endpoint " + endpoint + ", expected a URL such as https://s3.example.com:9000 without a path")
+	}
+	location.Host = u.Hostname()
+	location.Secure = u.Scheme == "https"
+	location.Port = 443
+	if !location.Secure {
+		location.Port = 80
+	}
+	if u.Port() != "" {
+		location.Port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return location, errors.New("Invalid port in --s3-endpoint " + endpoint)
+		}
+	}
+	return location, nil
+}
+
+// checkS3Bucket checks that --s3-bucket exists and the credentials can write to it
+func checkS3Bucket() error {
+	location, err := parseS3Endpoint(s3Endpoint)
+	if err != nil {
+		return err
+	}
+	return checkBucketAccess(s3Target{s3Location: location, Bucket: s3Bucket, AccessKey: s3AccessKey, SecretKey: s3SecretKey, Region: s3Region})
+}
+
+// checkBucketAccess writes and deletes an object below the storage path of Quay to check that the bucket exists and
+// the credentials can write to it
+func checkBucketAccess(target s3Target) error {
+	for _, method := range []string{"PUT", "DELETE"} {
+		var body []byte
+		if method == "PUT" {
+			body = []byte("mirror-registry preflight\n")
+		}
+		if err := s3Request(target, method, s3PreflightKey, body); err != nil {
+			return fmt.Errorf("Could not %s an object in bucket %s on %s: %s", strings.ToLower(method), target.Bucket, withPort(target.Host, strconv.Itoa(target.Port)), err.Error())
+		}
+	}
+	return nil
+}
+
+// s3Request sends a path-style request for an object of a bucket, signed with AWS Signature Version 4
+func s3Request(target s3Target, method, key string, body []byte) error {
+	scheme := "https"
+	if !target.Secure {
+		scheme = "http"
+	}
+	path := "/" + target.Bucket + "/" + key
+	req, err := http.NewRequest(method, scheme+"://"+withPort(target.Host, strconv.Itoa(target.Port))+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{method, path, "", "host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + amzDate, "", signedHeaders, payloadHash}, "\n")
+	scope := now.Format("20060102") + "/" + target.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signingKey := []byte("AWS4" + target.SecretKey)
+	for _, part := range []string{now.Format("20060102"), target.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", target.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	var s3Err s3Error
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		switch s3Err.Code {
+		case "NoSuchBucket":
+			return errors.New("the bucket does not exist, create it first")
+		case "InvalidAccessKeyId", "SignatureDoesNotMatch":
+			return errors.New("the access key or secret key was rejected (" + s3Err.Code + ")")
+		case "AuthorizationHeaderMalformed":
+			return errors.New("the request was signed for the wrong region (" + s3Err.Message + ")")
+		}
+		return fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+	}
+	return errors.New(resp.Status)
+}
+
+// sha256Hex returns the hex encoded SHA-256 digest of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3StorageVars returns the object storage extra-vars of the install playbook, the secret key is passed in the
+// environment
+func s3StorageVars() string {
+	location, _ := parseS3Endpoint(s3Endpoint)
+	return fmt.Sprintf(" s3_host=%s s3_port=%d s3_is_secure=%t s3_bucket=%s s3_access_key=%s s3_region=%s", location.Host, location.Port, location.Secure, s3Bucket, s3AccessKey, s3Region)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secretKeyFile is the path of a file containing the Quay SECRET_KEY to reuse
+var secretKeyFile string
+
+// databaseSecretKeyFile is the path of a file containing the Quay DATABASE_SECRET_KEY to reuse
+var databaseSecretKeyFile string
+
+// minSecretKeyLength is the minimum length accepted for SECRET_KEY and DATABASE_SECRET_KEY
+const minSecretKeyLength = 32
+
+// secretKey describes how one of the Quay secret keys is supplied to the installer
+type secretKey struct {
+	name string // config.yaml field
+	flag string
+	env  string // environment variable read on the control host and passed to the playbook
+	file *string
+}
+
+// secretKeys are the Quay secret keys that can be supplied instead of generated
+var secretKeys = []secretKey{
+	{"SECRET_KEY", "secretKey", "MIRROR_REGISTRY_SECRET_KEY", &secretKeyFile},
+	{"DATABASE_SECRET_KEY", "databaseSecretKey", "MIRROR_REGISTRY_DATABASE_SECRET_KEY", &databaseSecretKeyFile},
+}
+
+// loadSecretKeys reads the secret keys given with --secretKey/--databaseSecretKey or their environment variables.
+// It returns the supplied values keyed by environment variable so they can be handed to the playbook
+// through the environment instead of the command line.
+func loadSecretKeys() (map[string]string, error) {
+	values := map[string]string{}
+	for _, key := range secretKeys {
+		value := os.Getenv(key.env)
+		if *key.file != "" {
+			data, err := ioutil.ReadFile(*key.file)
+			if err != nil {
+				return nil, errors.New("Could not read --" + key.flag + " file: " + err.Error())
+			}
+			value = string(data)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if len(value) < minSecretKeyLength || strings.ContainsAny(value, "\"\\ \t\n") {
+			return nil, errors.New(key.name + " must be at least 32 characters long and must not contain whitespace, quotes or backslashes")
+		}
+		log.Infof("Using the supplied %s", key.name)
+		values[key.env] = value
+	}
+	return values, nil
+}
+
+// secretEnvFlags returns the podman flags passing secrets from the environment into the container, without their values
+func secretEnvFlags(values map[string]string) string {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var flags string
+	for _, name := range names {
+		flags += "-e " + name + " "
+	}
+	return flags
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceComponents are the components the service commands act on, all of them when empty
+var serviceComponents []string
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Start, stop or restart the services of the registry on the target.",
+}
+
+// serviceStartCmd represents the service start command
+var serviceStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the services of the registry and wait for Quay to become healthy.",
+	Run: func(cmd *cobra.Command, args []string) {
+		manageServices("start")
+	},
+}
+
+// serviceStopCmd represents the service stop command
+var serviceStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the services of the registry, e.g. for a maintenance window.",
+	Run: func(cmd *cobra.Command, args []string) {
+		manageServices("stop")
+	},
+}
+
+// serviceRestartCmd represents the service restart command
+var serviceRestartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the services of the registry, e.g. after a config change, and wait for Quay to become healthy.",
+	Run: func(cmd *cobra.Command, args []string) {
+		manageServices("restart")
+	},
+}
+
+func init() {
+
+	// Add service command
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceStartCmd)
+	serviceCmd.AddCommand(serviceStopCmd)
+	serviceCmd.AddCommand(serviceRestartCmd)
+
+	for _, cmd := range []*cobra.Command{serviceStartCmd, serviceStopCmd, serviceRestartCmd} {
+		cmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+		cmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+		cmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+		cmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+		cmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+		cmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, used for the health check. This defaults to <targetHostname>:8443")
+		cmd.Flags().StringSliceVarP(&serviceComponents, "component", "", nil, "The service to act on, quay, postgres, redis, clair or mirror. May be repeated or comma separated. This defaults to all installed services")
+	}
+	for _, cmd := range []*cobra.Command{serviceStartCmd, serviceRestartCmd} {
+		cmd.Flags().BoolVarP(&skipHealthcheck, "skip-healthcheck", "", false, "Return once the services are started, without waiting for Quay to report healthy.")
+		cmd.Flags().IntVarP(&healthcheckTimeout, "healthcheckTimeout", "", 300, "The number of seconds to wait for Quay to report healthy. This defaults to 300")
+	}
+}
+
+// manageServices runs a systemctl action on the units of the selected components and reports their states
+func manageServices(action string) {
+
+	// Dependencies first, so the order matches the one systemd starts them in
+	units := []string{}
+	selected := map[string]bool{}
+	for _, component := range serviceComponents {
+		if _, ok := logComponents[component]; !ok {
+			check(errors.New("Invalid --component " + component + ", must be one of quay, postgres, redis, clair or mirror"))
+		}
+		selected[component] = true
+	}
+	for _, component := range []string{"postgres", "redis", "clair", "quay", "mirror"} {
+		if len(selected) == 0 || selected[component] {
+			units = append(units, logComponents[component])
+		}
+	}
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	err := loadSSHKeys()
+	check(err)
+
+	// Skip the units of components that are not installed, e.g. an external database or Clair left disabled, and report
+	// the state of all units after the action, as quay-app is stopped and restarted with the services it requires
+	var script strings.Builder
+	script.WriteString(remotePreamble() + "UNITS=\n")
+	for _, unit := range units {
+		fmt.Fprintf(&script, "if [ -f \"$UNIT_DIR/%[1]s.service\" ]; then UNITS=\"$UNITS %[1]s.service\"; else echo \"absent %[1]s\"; fi\n", unit)
+	}
+	fmt.Fprintf(&script, "if [ -n \"$UNITS\" ]; then $SC %s $UNITS; fi\n", action)
+	for _, component := range []string{"postgres", "redis", "clair", "quay", "mirror"} {
+		fmt.Fprintf(&script, "if [ -f \"$UNIT_DIR/%[1]s.service\" ]; then echo \"state %[1]s $($SC is-active %[1]s.service 2>/dev/null || true)\"; fi\n", logComponents[component])
+	}
+
+	log.Printf("Running %s of %s on %s", action, strings.Join(units, ", "), targetHostname)
+	out, err := runRemoteCommand(script.String())
+	if err != nil && !isSSHFailure(err) {
+		err = fmt.Errorf("could not %s %s: %w", action, strings.Join(units, ", "), err)
+	}
+	check(err)
+
+	acted, quayInstalled := false, false
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "absent":
+			log.Warnf("%s is not installed on %s, skipped", fields[1], targetHostname)
+		case len(fields) == 3 && fields[0] == "state":
+			for _, unit := range units {
+				acted = acted || unit == fields[1]
+			}
+			quayInstalled = quayInstalled || fields[1] == "quay-app"
+			log.Infof("%s is %s", fields[1], fields[2])
+		}
+	}
+	if !acted {
+		check(errors.New("None of the selected services are installed on " + targetHostname))
+	}
+
+	// Restarting a service Quay requires restarts quay-app too, while starting one leaves a stopped quay-app stopped
+	waitForQuay := action == "restart" || action == "start" && (len(selected) == 0 || selected["quay"])
+	if !waitForQuay || !quayInstalled || skipHealthcheck {
+		return
+	}
+	err = waitForInstall(quayHostname, "", "", time.Duration(healthcheckTimeout)*time.Second)
+	if err != nil {
+		check(withExitCode(exitHealthcheck, err))
+	}
+	log.Printf("Quay is healthy at https://%s", quayHostname)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// setLogLevelCmd represents the set-log-level command
+var setLogLevelCmd = &cobra.Command{
+	Use:   "set-log-level [DEBUG|INFO|WARNING]",
+	Short: "Show or change the log level of a running Quay install.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel(args)
+	},
+}
+
+func init() {
+
+	// Add set-log-level command
+	rootCmd.AddCommand(setLogLevelCmd)
+
+	setLogLevelCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	setLogLevelCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	setLogLevelCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	setLogLevelCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	setLogLevelCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	setLogLevelCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, used for the health check. This defaults to <targetHostname>:8443")
+	setLogLevelCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+}
+
+// validateLogLevel normalizes and checks a Quay log level
+func validateLogLevel(level string) (string, error) {
+	level = strings.ToUpper(level)
+	switch level {
+	case "DEBUG", "INFO", "WARNING":
+		return level, nil
+	}
+	return "", errors.New("Invalid Quay log level " + level + ", must be one of DEBUG, INFO or WARNING")
+}
+
+// getQuayLogLevel reads the configured log level from config.yaml on the target
+func getQuayLogLevel() (string, error) {
+	out, err := runRemoteCommand(remotePreamble() + `sed -n 's/^LOGGING_LEVEL: //p' "$CONFIG"` + "\n")
+	if err != nil {
+		return "", err
+	}
+	if level := strings.TrimSpace(out); level != "" {
+		return level, nil
+	}
+	return "INFO", nil
+}
+
+func setLogLevel(args []string) {
+
+	err := loadSSHKeys()
+	check(err)
+
+	current, err := getQuayLogLevel()
+	check(err)
+	if len(args) == 0 {
+		fmt.Println(current)
+		return
+	}
+
+	level, err := validateLogLevel(args[0])
+	check(err)
+	if level == current {
+		log.Infof("Quay log level is already %s", level)
+		return
+	}
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	debugLog := "false"
+	if level == "DEBUG" {
+		debugLog = "true"
+	}
+
+	log.Printf("Changing Quay log level from %s to %s on %s", current, level, targetHostname)
+	_, err = runRemoteCommand(remotePreamble() + fmt.Sprintf(`if grep -q '^LOGGING_LEVEL:' "$CONFIG"; then
+    sed -i 's/^LOGGING_LEVEL: .*/LOGGING_LEVEL: %[1]s/' "$CONFIG"
+else
+    echo 'LOGGING_LEVEL: %[1]s' >> "$CONFIG"
+fi
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+if grep -q 'DEBUGLOG=' "$UNIT_DIR/quay-app.service"; then
+    sed -i 's/DEBUGLOG=[a-z]*/DEBUGLOG=%[2]s/' "$UNIT_DIR/quay-app.service"
+else
+    sed -i 's#^\(\s*\)--name quay-app \\#&\n\1-e DEBUGLOG=%[2]s \\#' "$UNIT_DIR/quay-app.service"
+fi
+$SC daemon-reload
+$SC restart quay-app.service
+`, level, debugLog))
+	check(err)
+
+	log.Printf("Waiting for Quay to become healthy at https://%s/health/instance", quayHostname)
+	err = waitForQuayHealth(quayHostname, 18, 10*time.Second)
+	check(err)
+
+	log.Printf("Quay log level set to %s", level)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// verifySignatures holds whether or not to check the cosign signatures of the images before deploying them
+var verifySignatures bool
+
+// signatureKey is the path of the cosign public key the images are verified against
+var signatureKey string
+
+// defaultSignatureKey is the name of the Red Hat cosign public key looked up next to the binary
+const defaultSignatureKey = "redhat-release.pub"
+
+// verifyImageSignatures checks the detached signature of the image archive, or the signatures of the images in their
+// registries when there is no archive, against --signatureKey with cosign on the control host
+func verifyImageSignatures() error {
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return errors.New("--verify-signatures requires cosign on the control host")
+	}
+	if signatureKey == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		signatureKey = path.Join(path.Dir(executable), defaultSignatureKey)
+	}
+	if !pathExists(signatureKey) {
+		return errors.New("Could not find the public key " + signatureKey + " to verify the signatures with, pass its location with --signatureKey")
+	}
+
+	if imageArchivePath != "" {
+		signature := imageArchivePath + ".sig"
+		if !pathExists(signature) {
+			return errors.New("Could not find the signature of the image archive at " + signature)
+		}
+		log.Infof("Verifying the signature of %s against %s", imageArchivePath, signatureKey)
+		out, err := exec.Command("cosign", "verify-blob", "--key", signatureKey, "--signature", signature, imageArchivePath).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s does not match its signature %s: %s", imageArchivePath, signature, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	// cosign reads registry credentials from $DOCKER_CONFIG/config.json, which has the format of a pull secret
+	env := os.Environ()
+	if pullSecret != "" {
+		dir, err := ioutil.TempDir("", "mirror-registry-cosign-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		data, err := ioutil.ReadFile(pullSecret)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "config.json"), data, 0600); err != nil {
+			return err
+		}
+		env = append(env, "DOCKER_CONFIG="+dir)
+	}
+
+	images := []string{quayImage, redisImage, postgresImage}
+	if withClair {
+		images = append(images, clairImage)
+	}
+	for _, image := range images {
+		log.Infof("Verifying the signature of %s against %s", image, signatureKey)
+		cmd := exec.Command("cosign", "verify", "--key", signatureKey, image)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("the signature of %s could not be verified against %s: %s", image, signatureKey, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpHost is the mail server Quay sends notifications and password reset emails through
+var smtpHost string
+
+// smtpPort is the port of the mail server
+var smtpPort int
+
+// smtpUser is the user Quay authenticates to the mail server with, no authentication is used if empty
+var smtpUser string
+
+// smtpPassword is the password of smtpUser
+var smtpPassword string
+
+// smtpSender is the address emails are sent from
+var smtpSender string
+
+// smtpTLS holds whether or not Quay uses STARTTLS with the mail server
+var smtpTLS bool
+
+// smtpPasswordEnv is the environment variable holding the SMTP password
+const smtpPasswordEnv = "MIRROR_REGISTRY_SMTP_PASSWORD"
+
+// validateSMTP checks the mail flags and whether the mail server is reachable from the control host
+func validateSMTP() error {
+	if smtpPassword == "" {
+		smtpPassword = os.Getenv(smtpPasswordEnv)
+	}
+	if smtpHost == "" {
+		if smtpUser != "" || smtpPassword != "" || smtpSender != "" {
+			return errors.New("--smtpUser, --smtpPassword and --smtpSender require --smtpHost")
+		}
+		return nil
+	}
+	if strings.ContainsAny(smtpHost, " /:") {
+		return errors.New("Invalid --smtpHost " + smtpHost + ", expected a hostname without port")
+	}
+	if smtpSender == "" {
+		return errors.New("--smtpHost requires --smtpSender")
+	}
+	if _, err := mail.ParseAddress(smtpSender); err != nil || strings.ContainsAny(smtpSender, "\"'`$\\<> ") {
+		return errors.New("Invalid --smtpSender " + smtpSender + ", expected an email address")
+	}
+	if (smtpUser == "") != (smtpPassword == "") {
+		return errors.New("SMTP authentication requires both --smtpUser and --smtpPassword or $" + smtpPasswordEnv)
+	}
+	if strings.ContainsAny(smtpUser, "\"'`$\\ ") {
+		return errors.New("--smtpUser must not contain quotes, spaces, backslashes or $")
+	}
+
+	address := net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort))
+	if skipForDryRun("test the connection to mail server " + address) {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		// The mail server may only be reachable from the target
+		log.Warnf("Could not connect to mail server %s, make sure the target can reach it: %s", address, err.Error())
+		return nil
+	}
+	conn.Close()
+	return nil
+}
+
+// smtpVars returns the extra-vars enabling mail, the password is passed through the environment
+func smtpVars() string {
+	if smtpHost == "" {
+		return ""
+	}
+	return fmt.Sprintf(" enable_mailing=true mail_server=%s mail_port=%d mail_use_tls=%t mail_username=%s mail_default_sender=%s", smtpHost, smtpPort, smtpTLS, smtpUser, smtpSender)
+}
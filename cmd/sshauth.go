@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sshPasswordAuth holds whether or not to authenticate to the target with a password instead of an SSH key
+var sshPasswordAuth bool
+
+// sshAgentKey is the --ssh-key value selecting the keys of the running SSH agent
+const sshAgentKey = "agent"
+
+// sshAgentSocket is where the SSH agent socket is mounted in the execution environment
+const sshAgentSocket = "/run/ssh-agent.sock"
+
+// sshPasswordEnv is the environment variable the SSH password is read from and passed to ssh and the playbook in
+const sshPasswordEnv = "MIRROR_REGISTRY_SSH_PASSWORD"
+
+// loadSSHPassword reads the SSH password from $MIRROR_REGISTRY_SSH_PASSWORD or prompts for it.
+// The password stays in the environment of the installer, from where sshpass and the execution environment read it.
+func loadSSHPassword() error {
+	if _, err := exec.LookPath("sshpass"); err != nil {
+		return errors.New("--ssh-password requires sshpass on the control host")
+	}
+	if os.Getenv(sshPasswordEnv) != "" {
+		log.Info("Using the SSH password from $" + sshPasswordEnv)
+		return nil
+	}
+
+	password, err := readSecret(fmt.Sprintf("SSH password for %s@%s: ", targetUsername, hostOnly(targetHostname)))
+	if err != nil {
+		return errors.New("Could not read the SSH password: " + err.Error())
+	}
+	if password == "" {
+		return errors.New("The SSH password must not be empty")
+	}
+	registerSecret(password)
+	return os.Setenv(sshPasswordEnv, password)
+}
+
+// readSecret prompts for a line on the terminal without echoing it
+func readSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	echo := func(flag string) {
+		stty := exec.Command("stty", flag)
+		stty.Stdin = os.Stdin
+		stty.Run()
+	}
+	echo("-echo")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	echo("echo")
+	fmt.Println()
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// sshAuthFlags returns the podman and ansible-playbook flags that authenticate the playbook to the target,
+// and a function removing temporary files
+func sshAuthFlags() (podmanFlags, ansibleFlags string, cleanup func(), err error) {
+	if sshPasswordAuth {
+		return "-e " + sshPasswordEnv + " ", `-e 'ansible_password={{ lookup("env", "` + sshPasswordEnv + `") }}'`, func() {}, nil
+	}
+	if sshKey == sshAgentKey {
+		// The agent socket cannot be relabeled, so SELinux separation is disabled for the execution environment
+		return fmt.Sprintf("-v %s:%s -e SSH_AUTH_SOCK=%s --security-opt label=disable ", hostMountPath(os.Getenv("SSH_AUTH_SOCK")), sshAgentSocket, sshAgentSocket), "", func() {}, nil
+	}
+	volume, cleanup, err := sshKeyMount()
+	if err != nil {
+		return "", "", nil, err
+	}
+	return "-v " + volume + " ", "--private-key /runner/env/ssh_key", cleanup, nil
+}
+
+// sshCommand prepares ssh or scp with the options and authentication used for the target host
+func sshCommand(program string, args ...string) *exec.Cmd {
+	options := []string{"-o", "StrictHostKeyChecking=no", "-o", fmt.Sprintf("Port=%d", sshPort)}
+	if sshConnectTimeout > 0 {
+		options = append(options, "-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout))
+	}
+	if sshPasswordAuth {
+		options = append(options, "-o", "PubkeyAuthentication=no")
+		cmd := exec.Command("sshpass", append([]string{"-e", program}, append(options, args...)...)...)
+		cmd.Env = append(os.Environ(), "SSHPASS="+os.Getenv(sshPasswordEnv))
+		return cmd
+	}
+	if sshKey != sshAgentKey {
+		options = append(options, "-i", sshKey)
+	}
+	options = append(options, "-o", "BatchMode=yes")
+	return exec.Command(program, append(options, args...)...)
+}
+
+// loadSSHAgent checks that an SSH agent with at least one identity is running
+func loadSSHAgent() error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("--ssh-key agent requires a running SSH agent, SSH_AUTH_SOCK is not set")
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return errors.New("SSH_AUTH_SOCK " + socket + " is not a socket")
+	}
+	if err := exec.Command("ssh-add", "-l").Run(); err != nil {
+		return errors.New("The SSH agent at " + socket + " has no identities, add one with ssh-add")
+	}
+	log.Info("Using the identities of the SSH agent at " + socket)
+	return nil
+}
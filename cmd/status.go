@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// statusServices are the systemd services the status command reports on, in start order
+var statusServices = []string{"quay-pod", "quay-postgres", "quay-redis", "quay-clair", "quay-app", "quay-mirror", "quay-postgres-exporter", "quay-redis-exporter", "quay-monitoring-pod", "quay-prometheus", "quay-grafana"}
+
+// statusContainers are the containers of the status services whose images are reported
+var statusContainers = []string{"quay-postgres", "quay-redis", "quay-clair", "quay-app", "quay-mirror", "quay-postgres-exporter", "quay-redis-exporter", "quay-prometheus", "quay-grafana"}
+
+// optionalServices are the services that are only deployed with some install options
+var optionalServices = map[string]bool{
+	"quay-clair":             true,
+	"quay-mirror":            true,
+	"quay-postgres-exporter": true,
+	"quay-redis-exporter":    true,
+	"quay-monitoring-pod":    true,
+	"quay-prometheus":        true,
+	"quay-grafana":           true,
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the health of the deployed mirror registry components.",
+	Run: func(cmd *cobra.Command, args []string) {
+		status()
+	},
+}
+
+// componentStatus is the state of one deployed service
+type componentStatus struct {
+	Service string `json:"service"`
+	State   string `json:"state"`
+	Image   string `json:"image,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+	Drift   string `json:"drift,omitempty"`
+}
+
+// statusResult is the health report for a target
+type statusResult struct {
+	Host       string            `json:"host"`
+	Healthy    bool              `json:"healthy"`
+	Components []componentStatus `json:"components"`
+	Endpoint   string            `json:"endpoint"`
+	LogLevel   string            `json:"logLevel"`
+}
+
+func init() {
+
+	// Add status command
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	statusCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	statusCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	statusCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	statusCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	statusCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, used for the health check. This defaults to <targetHostname>:8443")
+	statusCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	statusCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the status as JSON")
+}
+
+func status() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	// Gather service states, container images and the log level in a single SSH session
+	var script strings.Builder
+	script.WriteString(remotePreamble() + "set +e\n")
+	for _, service := range statusServices {
+		fmt.Fprintf(&script, "if [ -f \"$UNIT_DIR/%[1]s.service\" ]; then echo \"state %[1]s $($SC is-active %[1]s.service 2>/dev/null)\"; else echo \"state %[1]s absent\"; fi\n", service)
+	}
+	for _, container := range statusContainers {
+		fmt.Fprintf(&script, "echo \"image %s $(podman inspect --format '{{.ImageName}}' %s 2>/dev/null)\"\n", container, container)
+		fmt.Fprintf(&script, "echo \"imageid %s $(podman inspect --format '{{.Image}}' %s 2>/dev/null)\"\n", container, container)
+	}
+	script.WriteString(`echo "loglevel $(sed -n 's/^LOGGING_LEVEL: //p' "$CONFIG" 2>/dev/null)"` + "\n")
+
+	log.Infof("Gathering service status from %s", targetHostname)
+	out, err := runRemoteCommand(script.String())
+	check(err)
+
+	facts := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3:
+			facts[fields[0]+" "+fields[1]] = fields[2]
+		case len(fields) == 2 && fields[0] == "loglevel":
+			facts["loglevel"] = fields[1]
+		}
+	}
+	value := func(key, missing string) string {
+		if v, ok := facts[key]; ok && v != "" {
+			return v
+		}
+		return missing
+	}
+
+	// The images recorded by the last install or upgrade from this control host, to detect drift
+	state, err := readInstallState(targetHostname)
+	check(err)
+	recorded := map[string]deployedImage{}
+	ids := map[string]string{}
+	if state != nil {
+		recorded = state.Images
+	}
+	for name, container := range componentContainers {
+		ids[name] = facts["imageid "+container]
+	}
+	drift := imageDrift(recorded, ids)
+
+	result := statusResult{Host: targetHostname, Healthy: true, LogLevel: value("loglevel", "INFO")}
+	for _, service := range statusServices {
+		// The pod services have no container of their own
+		component := componentStatus{Service: service + ".service", State: value("state "+service, "unknown")}
+		if service != "quay-pod" && service != "quay-monitoring-pod" {
+			component.Image = value("image "+service, "none")
+		}
+		for name, container := range componentContainers {
+			if container == service {
+				component.Digest = recorded[name].Digest
+				component.Drift = drift[name]
+			}
+		}
+		// Postgres and Redis are absent when Quay uses external servers
+		external := component.State == "absent" && (service == "quay-postgres" || service == "quay-redis")
+		if external {
+			component.State = "external"
+			component.Image = ""
+		}
+		// Clair, the repository mirror worker and the monitoring services are optional
+		optional := component.State == "absent" && optionalServices[service]
+		if optional {
+			component.State = "not installed"
+			component.Image = ""
+		}
+		result.Healthy = result.Healthy && (component.State == "active" || external || optional)
+		result.Components = append(result.Components, component)
+	}
+
+	if err := checkQuayHealth(quayHostname); err != nil {
+		result.Endpoint = "unhealthy: " + err.Error()
+		result.Healthy = false
+	} else {
+		result.Endpoint = "healthy"
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(result, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	} else {
+		for _, c := range result.Components {
+			image := c.Image
+			if c.Digest != "" {
+				image += " (" + c.Digest + ")"
+			}
+			fmt.Printf("%-24s %-10s %s\n", c.Service, c.State, image)
+		}
+		fmt.Printf("%-24s %s\n", "https://"+quayHostname+"/health/instance", result.Endpoint)
+		fmt.Printf("%-24s %s\n", "Quay log level", result.LogLevel)
+	}
+
+	for _, c := range result.Components {
+		if c.Drift != "" {
+			log.Warnf("%s, it was changed outside of mirror-registry", c.Drift)
+		}
+	}
+
+	if !result.Healthy {
+		log.Errorf("Mirror registry on %s is not healthy", targetHostname)
+		os.Exit(exitHealthcheck)
+	}
+	log.Infof("Mirror registry on %s is healthy", targetHostname)
+}
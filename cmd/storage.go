@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+)
+
+// objectStoragePath is the path below which Quay stores image blobs in a bucket or container of object storage
+const objectStoragePath = "/datastorage/registry"
+
+// storageBackend is where Quay stores image blobs, local for --quayStorage, or s3, azure or gcs for a bucket of object
+// storage
+var storageBackend string
+
+// validateStorage checks the flags of the selected storage backend and that no other backend is configured
+func validateStorage() error {
+	for _, given := range []struct {
+		backend string
+		set     bool
+	}{
+		{"s3", s3Endpoint != "" || s3Bucket != "" || s3AccessKey != ""},
+		{"azure", azureAccountName != "" || azureContainer != ""},
+		{"gcs", gcsBucket != "" || gcsAccessKey != ""},
+	} {
+		if given.set && given.backend != storageBackend {
+			return errors.New("The --" + given.backend + "-* options require --storage " + given.backend)
+		}
+	}
+	if storageBackend != "local" && haMode {
+		return errors.New("--storage " + storageBackend + " cannot be used with --ha, set the storage vars in --inventory")
+	}
+	switch storageBackend {
+	case "local":
+		return nil
+	case "s3":
+		return validateS3Storage()
+	case "azure":
+		return validateAzureStorage()
+	case "gcs":
+		return validateGCSStorage()
+	}
+	return errors.New("Invalid --storage " + storageBackend + ", must be local, s3, azure or gcs")
+}
+
+// storageSecretEnv returns the environment variable and value the secret of the storage backend is passed to the
+// playbook in, if any
+func storageSecretEnv() (string, string) {
+	switch storageBackend {
+	case "s3":
+		return s3SecretKeyEnv, s3SecretKey
+	case "azure":
+		return azureAccountKeyEnv, azureAccountKey
+	case "gcs":
+		return gcsSecretKeyEnv, gcsSecretKey
+	}
+	return "", ""
+}
+
+// storageDescription names the bucket or container of the storage backend in logs
+func storageDescription() string {
+	switch storageBackend {
+	case "s3":
+		return "bucket " + s3Bucket + " on " + s3Endpoint
+	case "azure":
+		return "container " + azureContainer + " of Azure storage account " + azureAccountName
+	case "gcs":
+		return "Google Cloud Storage bucket " + gcsBucket
+	}
+	return quayStorage
+}
+
+// checkStorage checks that the credentials of the storage backend can write to its bucket or container
+func checkStorage() error {
+	switch storageBackend {
+	case "s3":
+		return checkS3Bucket()
+	case "azure":
+		return checkAzureContainer()
+	case "gcs":
+		return checkGCSBucket()
+	}
+	return nil
+}
+
+// storageVars returns the storage extra-vars of the install playbook, the secrets are passed in the environment
+func storageVars() string {
+	switch storageBackend {
+	case "s3":
+		return s3StorageVars()
+	case "azure":
+		return azureStorageVars()
+	case "gcs":
+		return gcsStorageVars()
+	}
+	return ""
+}
+
+// storageConfig returns the default location of DISTRIBUTED_STORAGE_CONFIG for the object storage backend, with the
+// same drivers and parameters the install playbook writes to config.yaml
+func storageConfig() []interface{} {
+	switch storageBackend {
+	case "s3":
+		location, _ := parseS3Endpoint(s3Endpoint)
+		if strings.HasSuffix(location.Host, "amazonaws.com") {
+			return []interface{}{"S3Storage", map[string]interface{}{
+				"s3_access_key": s3AccessKey,
+				"s3_secret_key": s3SecretKey,
+				"s3_bucket":     s3Bucket,
+				"s3_region":     s3Region,
+				"host":          location.Host,
+				"port":          location.Port,
+				"storage_path":  objectStoragePath,
+			}}
+		}
+		return []interface{}{"RadosGWStorage", map[string]interface{}{
+			"access_key":   s3AccessKey,
+			"secret_key":   s3SecretKey,
+			"bucket_name":  s3Bucket,
+			"hostname":     location.Host,
+			"port":         location.Port,
+			"is_secure":    location.Secure,
+			"storage_path": objectStoragePath,
+		}}
+	case "azure":
+		return []interface{}{"AzureStorage", map[string]interface{}{
+			"azure_account_name": azureAccountName,
+			"azure_account_key":  azureAccountKey,
+			"azure_container":    azureContainer,
+			"storage_path":       objectStoragePath,
+		}}
+	case "gcs":
+		return []interface{}{"GoogleCloudStorage", map[string]interface{}{
+			"access_key":   gcsAccessKey,
+			"secret_key":   gcsSecretKey,
+			"bucket_name":  gcsBucket,
+			"storage_path": objectStoragePath,
+		}}
+	}
+	return []interface{}{"LocalStorage", map[string]interface{}{"storage_path": "/datastorage"}}
+}
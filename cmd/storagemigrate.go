@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// migrateTarget is the object storage backend storage migrate moves the blobs to. It is separate from storageBackend
+// so the local default of --storage of install and preflight is not overwritten when flags are registered.
+var migrateTarget string
+
+// rcloneImage is the rclone image that copies the local blobs to object storage on the target
+var rcloneImage string
+
+// storageCmd represents the storage command
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manage where Quay stores image blobs.",
+}
+
+// storageMigrateCmd represents the storage migrate command
+var storageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy the local image blobs of Quay to object storage, switch Quay over to it and restart Quay.",
+	Run: func(cmd *cobra.Command, args []string) {
+		migrateStorage()
+	},
+}
+
+func init() {
+
+	// Add storage migrate command
+	rootCmd.AddCommand(storageCmd)
+	storageCmd.AddCommand(storageMigrateCmd)
+
+	storageMigrateCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	storageMigrateCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	storageMigrateCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	storageMigrateCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	storageMigrateCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	storageMigrateCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The SERVER_HOSTNAME of the Quay install, used for the health check. This defaults to <targetHostname>:8443")
+	storageMigrateCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	storageMigrateCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder or Podman named volume the blobs are copied from. This defaults to 'quay-storage'")
+	storageMigrateCmd.Flags().StringVarP(&migrateTarget, "to", "", "", "The object storage to migrate to, s3, azure or gcs")
+	storageMigrateCmd.Flags().StringVarP(&s3Endpoint, "s3-endpoint", "", "", "The URL of the S3 compatible object storage of --to s3, e.g. https://minio.example.com:9000")
+	storageMigrateCmd.Flags().StringVarP(&s3Bucket, "s3-bucket", "", "", "The existing bucket the blobs are copied to with --to s3")
+	storageMigrateCmd.Flags().StringVarP(&s3AccessKey, "s3-access-key", "", "", "The access key of the S3 compatible object storage")
+	storageMigrateCmd.Flags().StringVarP(&s3SecretKey, "s3-secret-key", "", "", "The secret key of --s3-access-key. Can also be set with $"+s3SecretKeyEnv)
+	storageMigrateCmd.Flags().StringVarP(&s3Region, "s3-region", "", "us-east-1", "The region of the bucket, used to sign requests and by Quay for AWS S3. This defaults to us-east-1")
+	storageMigrateCmd.Flags().StringVarP(&azureAccountName, "azure-account-name", "", "", "The Azure storage account of --to azure")
+	storageMigrateCmd.Flags().StringVarP(&azureAccountKey, "azure-account-key", "", "", "An access key of --azure-account-name. Can also be set with $"+azureAccountKeyEnv)
+	storageMigrateCmd.Flags().StringVarP(&azureContainer, "azure-container", "", "", "The existing blob container the blobs are copied to with --to azure")
+	storageMigrateCmd.Flags().StringVarP(&gcsBucket, "gcs-bucket", "", "", "The existing Google Cloud Storage bucket the blobs are copied to with --to gcs")
+	storageMigrateCmd.Flags().StringVarP(&gcsAccessKey, "gcs-access-key", "", "", "The access ID of a Google Cloud Storage HMAC key")
+	storageMigrateCmd.Flags().StringVarP(&gcsSecretKey, "gcs-secret-key", "", "", "The secret of --gcs-access-key. Can also be set with $"+gcsSecretKeyEnv)
+	storageMigrateCmd.Flags().StringVarP(&rcloneImage, "rcloneImage", "", "docker.io/rclone/rclone:latest", "The rclone image that copies the blobs on the target. This defaults to docker.io/rclone/rclone:latest")
+	storageMigrateCmd.Flags().BoolVarP(&skipHealthcheck, "skip-healthcheck", "", false, "Return once Quay is restarted, without waiting for it to report healthy or rolling back.")
+	storageMigrateCmd.Flags().IntVarP(&healthcheckTimeout, "healthcheckTimeout", "", 300, "The number of seconds to wait for Quay to report healthy on object storage before it is switched back to local storage. This defaults to 300")
+	storageMigrateCmd.MarkFlagRequired("to")
+}
+
+func migrateStorage() {
+
+	storageBackend = migrateTarget
+	if storageBackend == "local" {
+		check(errors.New("storage migrate copies local blobs to object storage, --to must be s3, azure or gcs"))
+	}
+	err := validateStorage()
+	check(err)
+
+	// Set quayHostname if not already set
+	if quayHostname == "" {
+		quayHostname = withPort(targetHostname, "8443")
+	}
+
+	err = loadSSHKeys()
+	check(err)
+
+	live, err := runRemoteCommand(remotePreamble() + `cat "$CONFIG"` + "\n")
+	check(err)
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte(live), &config); err != nil {
+		check(fmt.Errorf("Could not parse the config.yaml on %s: %s", targetHostname, err.Error()))
+	}
+	if driver := storageDriver(config); driver != "LocalStorage" {
+		check(errors.New("Quay on " + targetHostname + " stores blobs with " + driver + ", storage migrate only moves blobs off local storage"))
+	}
+
+	log.Infof("Checking access to %s", storageDescription())
+	err = checkStorage()
+	check(err)
+
+	// Most blobs are copied while Quay keeps serving, the second pass only copies what was pushed in the meantime
+	log.Printf("Copying the blobs in %s to %s while Quay keeps running. This may take some time", quayStorage, storageDescription())
+	_, err = runRemoteCommand(rcloneScript("copy --transfers 8 --checkers 16 --stats 1m --stats-one-line -v"))
+	check(err)
+
+	log.Printf("Stopping Quay on %s to copy the blobs pushed during the first pass", targetHostname)
+	_, err = runRemoteCommand(remotePreamble() + `$SC stop quay-app.service
+if [ -f "$UNIT_DIR/quay-mirror.service" ]; then $SC stop quay-mirror.service; fi
+`)
+	check(err)
+	_, err = runRemoteCommand(rcloneScript("copy --transfers 8 --checkers 16 -v"))
+	if err == nil {
+		log.Printf("Verifying that every blob is in %s", storageDescription())
+		_, err = runRemoteCommand(rcloneScript("check --one-way --size-only"))
+	}
+	if err != nil {
+		log.Errorf("Copying the blobs failed, restarting Quay on local storage: %s", err.Error())
+		if _, restartErr := runRemoteCommand(remotePreamble() + restartQuayCommands); restartErr != nil {
+			log.Errorf("Restarting Quay failed, start it with mirror-registry service start")
+		}
+		check(err)
+	}
+
+	config["DISTRIBUTED_STORAGE_CONFIG"] = map[string]interface{}{"default": storageConfig()}
+	config["DISTRIBUTED_STORAGE_PREFERENCE"] = []string{"default"}
+	data, err := yaml.Marshal(config)
+	check(err)
+
+	log.Printf("Switching Quay on %s to %s and restarting it", targetHostname, storageDescription())
+	_, err = runRemoteCommand(remotePreamble() + `cp -p "$CONFIG" "$CONFIG.local-storage"
+echo '` + base64.StdEncoding.EncodeToString(data) + `' | base64 -d > "$CONFIG"
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+` + restartQuayCommands)
+	if err == nil && !skipHealthcheck {
+		err = waitForInstall(quayHostname, "", "", time.Duration(healthcheckTimeout)*time.Second)
+		if err != nil {
+			err = withExitCode(exitHealthcheck, err)
+		}
+	}
+	if err != nil {
+		log.Errorf("Quay did not come up on %s, switching back to local storage: %s", storageDescription(), err.Error())
+		if _, rollbackErr := runRemoteCommand(remotePreamble() + `if [ -f "$CONFIG.local-storage" ]; then mv -f "$CONFIG.local-storage" "$CONFIG"; fi
+sha256sum "$CONFIG" | cut -d' ' -f1 > "$CONFIG.sha256"
+` + restartQuayCommands); rollbackErr != nil {
+			log.Errorf("Rollback failed, restore %s/quay-config/config.yaml.local-storage manually", quayRoot)
+		}
+		check(err)
+	}
+
+	log.Printf("Quay on %s now stores blobs in %s", targetHostname, storageDescription())
+	log.Printf("The previous config is kept as %s/quay-config/config.yaml.local-storage and the local blobs in %s. Delete them once Quay works as expected, and pass the same --storage options when re-running install", quayRoot, quayStorage)
+}
+
+// storageDriver returns the driver of the default location of DISTRIBUTED_STORAGE_CONFIG in a Quay config
+func storageDriver(config map[string]interface{}) string {
+	locations, _ := config["DISTRIBUTED_STORAGE_CONFIG"].(map[string]interface{})
+	location, _ := locations["default"].([]interface{})
+	if len(location) == 0 {
+		return "an unknown driver"
+	}
+	return fmt.Sprint(location[0])
+}
+
+// restartQuayCommands restarts Quay and the mirror worker, after remotePreamble
+const restartQuayCommands = `$SC restart quay-app.service
+if [ -f "$UNIT_DIR/quay-mirror.service" ]; then $SC restart quay-mirror.service; fi
+`
+
+// rcloneEnv returns the environment variables configuring the object storage as the dest remote of rclone
+func rcloneEnv() map[string]string {
+	env := map[string]string{}
+	switch storageBackend {
+	case "s3":
+		location, _ := parseS3Endpoint(s3Endpoint)
+		scheme := "https://"
+		if !location.Secure {
+			scheme = "http://"
+		}
+		env["TYPE"] = "s3"
+		env["PROVIDER"] = "Other"
+		if strings.HasSuffix(location.Host, "amazonaws.com") {
+			env["PROVIDER"] = "AWS"
+		}
+		env["ENDPOINT"] = scheme + withPort(location.Host, strconv.Itoa(location.Port))
+		env["ACCESS_KEY_ID"] = s3AccessKey
+		env["SECRET_ACCESS_KEY"] = s3SecretKey
+		env["REGION"] = s3Region
+		env["FORCE_PATH_STYLE"] = "true"
+	case "azure":
+		env["TYPE"] = "azureblob"
+		env["ACCOUNT"] = azureAccountName
+		env["KEY"] = azureAccountKey
+	case "gcs":
+		env["TYPE"] = "s3"
+		env["PROVIDER"] = "GCS"
+		env["ENDPOINT"] = "https://" + gcsHost
+		env["ACCESS_KEY_ID"] = gcsAccessKey
+		env["SECRET_ACCESS_KEY"] = gcsSecretKey
+	}
+	return env
+}
+
+// rcloneDestination returns the path of the rclone dest remote the local blobs are copied to
+func rcloneDestination() string {
+	bucket := map[string]string{"s3": s3Bucket, "azure": azureContainer, "gcs": gcsBucket}[storageBackend]
+	return "dest:" + bucket + objectStoragePath
+}
+
+// rcloneScript runs an rclone command from the local storage to the object storage on the target. The credentials are
+// exported in the script and passed to the container by name, to keep them off the command line.
+func rcloneScript(command string) string {
+	script := remotePreamble()
+	var names []string
+	for option, value := range rcloneEnv() {
+		name := "RCLONE_CONFIG_DEST_" + option
+		names = append(names, name)
+		script += "export " + name + "=" + shellQuote(value) + "\n"
+	}
+	sort.Strings(names)
+	flags := ""
+	for _, name := range names {
+		flags += " -e " + name
+	}
+	fields := strings.Fields(command)
+	return script + fmt.Sprintf("podman run --rm --security-opt label=disable -v %s:/datastorage:ro%s %s %s /datastorage %s %s\n", quayStorage, flags, rcloneImage, fields[0], rcloneDestination(), strings.Join(fields[1:], " "))
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// troubleshootOutput is the path of the diagnostic bundle, or a directory to write it to
+var troubleshootOutput string
+
+// troubleshootLines is the number of log lines collected per container and unit
+var troubleshootLines int
+
+// redactConfigCommand prints a config.yaml with the values of passwords, secret and pre-shared keys, tokens and
+// database URIs replaced by <redacted>
+const redactConfigCommand = `sed -E 's/^([[:space:]]*[A-Za-z0-9_]*(PASSWORD|PASSWD|SECRET|KEY|TOKEN|PSK|DB_URI|_URI)[A-Za-z0-9_]*:).*/\1 <redacted>/I'`
+
+// troubleshootCmd represents the troubleshoot command
+var troubleshootCmd = &cobra.Command{
+	Use:   "troubleshoot",
+	Short: "Collect logs, unit states, the redacted config and disk usage of an install into a tar.gz for support cases.",
+	Run: func(cmd *cobra.Command, args []string) {
+		troubleshoot()
+	},
+}
+
+func init() {
+
+	// Add troubleshoot command
+	rootCmd.AddCommand(troubleshootCmd)
+
+	troubleshootCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	troubleshootCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	troubleshootCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	troubleshootCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	troubleshootCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	troubleshootCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	troubleshootCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder or Podman named volume where quay persistent storage data is saved. This defaults to 'quay-storage'")
+	troubleshootCmd.Flags().StringVarP(&troubleshootOutput, "output", "o", ".", "The path of the bundle. If a directory is given, a timestamped bundle is created in it. This defaults to the current directory")
+	troubleshootCmd.Flags().IntVarP(&troubleshootLines, "lines", "", 2000, "The number of log lines collected per container and systemd unit. This defaults to 2000")
+}
+
+func troubleshoot() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	output := troubleshootOutput
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		output = path.Join(output, fmt.Sprintf("mirror-registry-troubleshoot-%s-%s.tar.gz", hostOnly(targetHostname), time.Now().Format("20060102-150405")))
+	}
+	output, err = filepath.Abs(output)
+	check(err)
+
+	// Mask the credentials known to this control host in everything collected
+	credentials, err := loadCredentials(targetHostname)
+	check(err)
+	for _, value := range credentials {
+		registerSecret(value)
+	}
+
+	log.Printf("Collecting diagnostics from %s", targetHostname)
+	out, err := runRemoteCommand(troubleshootScript())
+	check(err)
+	remote, err := gzip.NewReader(strings.NewReader(out))
+	check(err)
+
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	check(err)
+	defer file.Close()
+	zw := gzip.NewWriter(file)
+	tw := tar.NewWriter(zw)
+
+	// Files from the target go below target/, those of the control host below control/
+	tr := tar.NewReader(remote)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		check(err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		check(err)
+		err = addBundleFile(tw, path.Join("target", path.Clean(header.Name)), data)
+		check(err)
+	}
+	for name, file := range troubleshootLocalFiles() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Warnf("Could not add %s to the bundle: %s", file, err.Error())
+			continue
+		}
+		err = addBundleFile(tw, path.Join("control", name), data)
+		check(err)
+	}
+
+	check(tw.Close())
+	check(zw.Close())
+	log.Printf("Diagnostics of %s written to %s", targetHostname, output)
+	log.Warn("Secrets in config.yaml and the credentials stored on this host are redacted, review the bundle before sharing it")
+}
+
+// addBundleFile adds a file to the bundle with the secrets known to this run masked
+func addBundleFile(tw *tar.Writer, name string, data []byte) error {
+	data = []byte(redact(string(data)))
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, bytes.NewReader(data))
+	return err
+}
+
+// troubleshootLocalFiles returns the latest playbook log and install report of the control host and its state files
+func troubleshootLocalFiles() map[string]string {
+	files := map[string]string{}
+	logs, _ := filepath.Glob(path.Join(os.Getenv("HOME"), ".mirror-registry", "logs", "*.log"))
+	reports, _ := filepath.Glob(path.Join(os.Getenv("HOME"), ".mirror-registry", "logs", "*-install-report.*"))
+	for _, matches := range [][]string{logs, reports} {
+		if len(matches) > 0 {
+			// The names start with a sortable timestamp
+			sort.Strings(matches)
+			latest := matches[len(matches)-1]
+			files[path.Base(latest)] = latest
+		}
+	}
+	for name, file := range map[string]string{"install-state.json": installStateFile(targetHostname), "state.json": registriesFile()} {
+		if pathExists(file) {
+			files[name] = file
+		}
+	}
+	return files
+}
+
+// troubleshootScript gathers the container logs, the unit states and journals, the config with secrets redacted, the
+// disk usage and the podman and OS versions of the target into a tar.gz on stdout. Missing components are skipped.
+func troubleshootScript() string {
+	lines := strconv.Itoa(troubleshootLines)
+	return remotePreamble() + `set +e
+STAGE=$(mktemp -d)
+trap 'rm -rf "$STAGE"' EXIT
+mkdir -p "$STAGE/containers" "$STAGE/units"
+for c in quay-app quay-postgres quay-redis quay-clair quay-mirror quay-postgres-exporter quay-redis-exporter quay-prometheus quay-grafana; do
+    if podman container exists $c; then
+        podman logs --tail ` + lines + ` $c > "$STAGE/containers/$c.log" 2>&1
+        podman inspect $c > "$STAGE/containers/$c.inspect.json" 2>&1
+    fi
+done
+for u in quay-pod quay-app quay-postgres quay-redis quay-clair quay-mirror quay-postgres-exporter quay-redis-exporter quay-monitoring-pod quay-prometheus quay-grafana quay-cert-renew quay-acme-renew quay-backup; do
+    if [ -f "$UNIT_DIR/$u.service" ]; then
+        $SC status --no-pager $u.service > "$STAGE/units/$u.status" 2>&1
+        cp "$UNIT_DIR/$u.service" "$STAGE/units/$u.service"
+        if [ "$(id -u)" = 0 ]; then journalctl --no-pager -n ` + lines + ` -u $u.service; else journalctl --no-pager -n ` + lines + ` --user-unit $u.service; fi > "$STAGE/units/$u.journal" 2>&1
+    fi
+done
+if [ -f "$CONFIG" ]; then
+    ` + redactConfigCommand + ` "$CONFIG" > "$STAGE/config.yaml"
+fi
+STORAGE=` + quayStorage + `
+if podman volume exists "$STORAGE" 2>/dev/null; then STORAGE_DIR=$(podman volume inspect --format '{{.Mountpoint}}' "$STORAGE"); else STORAGE_DIR=$STORAGE; fi
+{
+    df -h
+    echo
+    du -sh ` + quayRoot + ` "$STORAGE_DIR" 2>&1
+    echo
+    podman system df 2>&1
+} > "$STAGE/disk-usage.txt"
+podman ps -a > "$STAGE/podman-ps.txt" 2>&1
+podman images --digests > "$STAGE/podman-images.txt" 2>&1
+podman version > "$STAGE/podman-version.txt" 2>&1
+{ uname -a; cat /etc/os-release; echo; free -m; echo; getenforce 2>/dev/null; } > "$STAGE/system.txt" 2>&1
+tar -C "$STAGE" -czf - .
+`
+}
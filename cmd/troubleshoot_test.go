@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// configTemplate is the config.yaml template of the install playbook
+const configTemplate = "../ansible-runner/context/app/project/roles/mirror_appliance/templates/config.yaml.j2"
+
+func TestRedactConfigCommandRedactsTemplateSecrets(t *testing.T) {
+	data, err := ioutil.ReadFile(configTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Render every expression as rendered-<var> and drop the control blocks
+	rendered := regexp.MustCompile(`\{\{\s*(\w+)[^}]*\}\}`).ReplaceAllString(string(data), "rendered-$1")
+	rendered = regexp.MustCompile(`(?m)^\s*\{%.*%\}\s*$\n?`).ReplaceAllString(rendered, "")
+
+	cmd := exec.Command("sh", "-c", redactConfigCommand)
+	cmd.Stdin = strings.NewReader(rendered)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{
+		"azure_account_key",
+		"clair_psk",
+		"database_secret_key",
+		"gcs_secret_key",
+		"ldap_admin_passwd",
+		"mail_password",
+		"oidc_client_secret",
+		"pg_password",
+		"redis_password",
+		"s3_secret_key",
+		"secret_key",
+	} {
+		if regexp.MustCompile(`rendered-` + secret + `\b`).Match(out) {
+			t.Errorf("%s is not redacted:\n%s", secret, out)
+		}
+	}
+}
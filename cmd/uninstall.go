@@ -1,9 +1,10 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -12,6 +13,9 @@ import (
 // autoApprove controls whether or not to prompt user
 var autoApprove bool
 
+// preserveData holds whether or not to keep quayRoot and the Quay and Postgres storage when uninstalling
+var preserveData bool
+
 // uninstallCmd represents the uninstall command
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
@@ -26,15 +30,33 @@ func init() {
 	// Add install command
 	rootCmd.AddCommand(uninstallCmd)
 
-	uninstallCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key. This defaults to ~/.ssh/quay_installer")
+	uninstallCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	uninstallCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	uninstallCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	uninstallCmd.Flags().StringVarP(&eeArchivePath, "eeArchive", "", "", "The path of execution-environment.tar. This defaults to the directory of the mirror-registry binary")
+	uninstallCmd.Flags().BoolVarP(&skipArchiveChecksum, "skipArchiveChecksum", "", false, "Use an execution environment that does not match the SHA-256 digest this release was built with, e.g. a custom build.")
 	uninstallCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", "localhost", "The hostname of the target you wish to install Quay to. This defaults to localhost")
 	uninstallCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user you wish to ssh into your remote with. This defaults to the current username")
 	uninstallCmd.Flags().BoolVarP(&askBecomePass, "askBecomePass", "", false, "Whether or not to ask for sudo password during SSH connection.")
+	uninstallCmd.Flags().StringVarP(&runtimeName, "runtime", "", "", "The container engine running the execution environment on the control host, podman or docker. Quay always runs on podman on the target. This defaults to podman if installed, docker otherwise")
+	uninstallCmd.Flags().StringVarP(&networkMode, "networkMode", "", "host", "The network mode of the ansible-runner container (host, slirp4netns or bridge). This defaults to host")
+	uninstallCmd.Flags().IntVarP(&ansibleTimeout, "ansibleTimeout", "", 10, "Seconds ansible waits for SSH connections and privilege escalation prompts. For high-latency links (e.g. satellite) 60 is recommended. This defaults to 10")
+	uninstallCmd.Flags().IntVarP(&sshConnectTimeout, "sshConnectTimeout", "", 0, "Seconds SSH waits to establish a connection. For high-latency links 30 is recommended. This defaults to --ansibleTimeout")
+	uninstallCmd.Flags().StringVarP(&sshControlPersist, "sshControlPersist", "", "60s", "How long idle SSH control connections are kept open (e.g. 60s, 10m). For high-latency links 10m is recommended to avoid reconnecting. This defaults to 60s")
+	uninstallCmd.Flags().IntVarP(&retries, "retries", "", 3, "How many times SSH connections to the target and loading the execution environment are retried after a transient failure, e.g. an unreachable host or a DNS failure. This defaults to 3")
+	uninstallCmd.Flags().IntVarP(&retryDelay, "retryDelay", "", 5, "Seconds before the first retry, doubled for every further one. This defaults to 5")
 	uninstallCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
 	uninstallCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder where quay persistent storage data is saved. This defaults to a Podman named volume 'quay-storage'. Root is required to uninstall.")
 	uninstallCmd.Flags().StringVarP(&pgStorage, "pgStorage", "", "pg-storage", "The folder where postgres persistent storage data is saved. This defaults to a Podman named volume 'pg-storage'. Root is required to uninstall.")
 	uninstallCmd.Flags().StringVarP(&additionalArgs, "additionalArgs", "", "", "Additional arguments you would like to append to the ansible-playbook call. Used mostly for development.")
+	uninstallCmd.Flags().StringVarP(&logFile, "logfile", "", "", "The file the full playbook output is saved to, kept for troubleshooting. This defaults to ~/.mirror-registry/logs/<timestamp>.log")
+	uninstallCmd.Flags().DurationVarP(&playbookTimeout, "timeout", "", 0, "How long the playbook may run, e.g. 90m. A playbook still running then is killed and the task it hung in is reported. This defaults to no limit")
+	uninstallCmd.Flags().BoolVarP(&forceUnlock, "force-unlock", "", false, "Remove the locks a previous run left on the control host and the target, e.g. after it was killed. Only use it when no other run is active.")
+	uninstallCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Print the checks and the playbook command that would run, without loading the execution environment or connecting to the target.")
 	uninstallCmd.Flags().BoolVarP(&autoApprove, "autoApprove", "", false, "Skips interactive approval")
+	uninstallCmd.Flags().BoolVarP(&autoApprove, "force", "", false, "Delete quayRoot and all storage data without asking to type the hostname to confirm")
+	uninstallCmd.Flags().BoolVarP(&autoApprove, "yes", "y", false, "Same as --force")
+	uninstallCmd.Flags().BoolVarP(&preserveData, "preserve-data", "", false, "Only remove the services, containers and systemd units, and keep quayRoot and the Quay and Postgres storage for a later reinstall or restore.")
 }
 
 func uninstall() {
@@ -42,54 +64,115 @@ func uninstall() {
 	var err error
 	log.Printf("Uninstall has begun")
 
-	if !autoApprove {
-		question := fmt.Sprintf("Are you sure want to delete quayRoot directory %s and all storage data? [y/n]", quayRoot)
-		fmt.Println(question)
-		autoApprove = getApproval(question)
-		if !autoApprove {
-			log.Info("Skipping deletion of quayRoot.")
-		}
+	if preserveData {
+		log.Infof("Keeping quayRoot %s, the Quay storage %s and the Postgres storage %s", quayRoot, quayStorage, pgStorage)
+	} else if !autoApprove && !dryRun {
+		err = confirmUninstall()
+		check(err)
+		autoApprove = true
 	}
 
-	// Load execution environment
-	err = loadExecutionEnvironment()
+	err = checkContainerizedExecution()
 	check(err)
 
-	err = loadSSHKeys()
+	err = validateNetworkModes()
+	check(err)
+
+	err = loadContainerRuntime()
+	check(withExitCode(exitPodman, err))
+
+	err = validateAnsibleConnection()
 	check(err)
 
+	// Load execution environment
+	if !skipForDryRun("load the execution environment " + eeImage) {
+		err = loadExecutionEnvironment()
+		check(withExitCode(exitPodman, err))
+	}
+
+	if !skipForDryRun("check the SSH key " + sshKey + " and generate it for local installs if missing") {
+		err = loadSSHKeys()
+		check(withExitCode(exitSSH, err))
+	}
+
+	// Keep other runs off the control host and the target until this one is done
+	if !skipForDryRun("lock the control host and " + targetHostname) {
+		release, err := acquireLock("uninstall")
+		check(err)
+		defer release()
+	}
+
 	// Set askBecomePass flag if true
 	var askBecomePassFlag string
 	if askBecomePass {
 		askBecomePassFlag = "-K"
 	}
 
-	log.Printf("Running uninstall playbook. This may take some time. To see playbook output run the installer with -v (verbose) flag.")
-	podmanCmd := fmt.Sprintf(`podman run `+
+	// Mount the SSH key in a way the local podman can read it, or pass the SSH password
+	sshPodmanFlags, sshAnsibleFlags, cleanup, err := sshAuthFlags()
+	check(err)
+	defer cleanup()
+
+	log.Printf("Running uninstall playbook. This may take some time. To see the full playbook output run the installer with -v (verbose) flag.")
+	playbookCmd := uninstallPlaybookCommand(sshPodmanFlags, sshAnsibleFlags, askBecomePassFlag, autoApprove)
+	if dryRun {
+		printDryRunCommand(playbookCmd)
+		return
+	}
+
+	log.Debug("Running command: " + playbookCmd.String())
+	cmd := playbookCmd.exec()
+	output, closeLog, err := openPlaybookLog(playbookOutput(), playbookCmd)
+	check(err)
+	defer closeLog()
+	cmd.Stdout = output
+	if verbose {
+		cmd.Stderr = os.Stderr
+	}
+	cmd.Stdin = os.Stdin
+	err = runPlaybook(cmd)
+	check(withExitCode(exitPlaybook, err))
+	forgetRegistry(hostOnly(targetHostname))
+
+	log.Printf("Quay uninstalled successfully")
+}
+
+// uninstallPlaybookCommand returns the command running the uninstall playbook, which also deletes quayRoot and the
+// storage when approve is set
+func uninstallPlaybookCommand(sshPodmanFlags, sshAnsibleFlags, askBecomePassFlag string, approve bool) *playbookCommand {
+	podmanCmd := fmt.Sprintf(eeRuntime.name()+` run `+
 		`--rm --interactive --tty `+
 		`--workdir /runner/project `+
-		`--net host `+
-		` -v %s:/runner/env/ssh_key `+
+		fmt.Sprintf("--net %s ", networkMode)+
+		` %s`+
 		`-e RUNNER_OMIT_EVENTS=False `+
 		`-e RUNNER_ONLY_FAILED_EVENTS=False `+
 		`-e ANSIBLE_HOST_KEY_CHECKING=False `+
 		`-e ANSIBLE_CONFIG=/runner/project/ansible.cfg `+
+		ansibleConnectionEnv()+
 		fmt.Sprintf("-e ANSIBLE_NOCOLOR=%t ", noColor)+
 		`--quiet `+
 		`--name ansible_runner_instance `+
 		fmt.Sprintf("%s ", eeImage)+
-		`ansible-playbook -i %s@%s, --private-key /runner/env/ssh_key uninstall_mirror_appliance.yml -e "quay_root=%s quay_storage=%s pg_storage=%s auto_approve=%t" %s %s`,
-		sshKey, targetUsername, strings.Split(targetHostname, ":")[0], quayRoot, quayStorage, pgStorage, autoApprove, askBecomePassFlag, additionalArgs)
+		`ansible-playbook -i %s@%s, %s uninstall_mirror_appliance.yml -e "quay_root=%s quay_storage=%s pg_storage=%s auto_approve=%t preserve_data=%t" %s %s`,
+		sshPodmanFlags, targetUsername, hostOnly(targetHostname), sshAnsibleFlags, quayRoot, quayStorage, pgStorage, approve, preserveData, askBecomePassFlag, additionalArgs)
+	return newPlaybookCommand(podmanCmd, nil)
+}
 
-	log.Debug("Running command: " + podmanCmd)
-	cmd := exec.Command("bash", "-c", podmanCmd)
-	if verbose {
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
+// confirmUninstall asks to type the hostname of the target before quayRoot and all mirrored content are deleted, so
+// an uninstall run against the wrong host or from the shell history does not destroy a registry by accident
+func confirmUninstall() error {
+	host := hostOnly(targetHostname)
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("Uninstall deletes quayRoot and all storage data of " + host + " and needs a confirmation, pass --force to uninstall without a terminal or --preserve-data to keep the data")
 	}
-	cmd.Stdin = os.Stdin
-	err = cmd.Run()
-	check(err)
-
-	log.Printf("Quay uninstalled successfully")
+	fmt.Printf("This deletes the Quay services, quayRoot %s and all mirrored content on %s. Type the hostname %s to confirm: ", quayRoot, host, host)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return errors.New("Uninstall cancelled, no confirmation was given")
+	}
+	if strings.TrimSpace(answer) != host {
+		return errors.New("Uninstall cancelled, " + strings.TrimSpace(answer) + " does not match " + host)
+	}
+	return nil
 }
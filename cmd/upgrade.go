@@ -8,17 +8,26 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq" // pg driver
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// skipDBBackup holds whether or not to skip the database backup taken before upgrading
+var skipDBBackup bool
+
+// upgradeLogLevel is the new log level of the Quay application, empty keeps the current level.
+// It is separate from quayLogLevel so the install default is not overwritten when flags are registered.
+var upgradeLogLevel string
+
 // upgradeCmd represents the upgrade command
 var upgradeCmd = &cobra.Command{
 	Use:   "upgrade",
 	Short: "Upgrade all mirror registry images.",
 	Run: func(cmd *cobra.Command, args []string) {
-		upgrade()
+		upgrade(cmd.Flags())
 	},
 }
 
@@ -29,20 +38,50 @@ func init() {
 
 	upgradeCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target you wish to install Quay to. This defaults to $HOST")
 	upgradeCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
-	upgradeCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key. This defaults to ~/.ssh/quay_installer")
+	upgradeCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	upgradeCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	upgradeCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	upgradeCmd.Flags().StringVarP(&eeArchivePath, "eeArchive", "", "", "The path of execution-environment.tar. This defaults to the directory of the mirror-registry binary")
+	upgradeCmd.Flags().BoolVarP(&skipArchiveChecksum, "skipArchiveChecksum", "", false, "Use an execution environment or image archive that does not match the SHA-256 digests this release was built with, e.g. a custom build.")
 
 	upgradeCmd.Flags().StringVarP(&quayHostname, "quayHostname", "", "", "The value to set SERVER_HOSTNAME in the Quay config.yaml. This defaults to <targetHostname>:8443")
 
 	upgradeCmd.Flags().StringVarP(&imageArchivePath, "image-archive", "i", "", "An archive containing images")
+	upgradeCmd.Flags().BoolVarP(&verifySignatures, "verify-signatures", "", false, "Verify the cosign signatures of the images against --signatureKey before deploying them: the detached signature of the image archive, or the images in their registries when there is none. Requires cosign on the control host.")
+	upgradeCmd.Flags().StringVarP(&signatureKey, "signatureKey", "", "", "The path of the cosign public key --verify-signatures checks the images against. This defaults to redhat-release.pub in the directory of the mirror-registry binary")
 	upgradeCmd.Flags().BoolVarP(&askBecomePass, "askBecomePass", "", false, "Whether or not to ask for sudo password during SSH connection.")
+	upgradeCmd.Flags().StringVarP(&runtimeName, "runtime", "", "", "The container engine running the execution environment on the control host, podman or docker. Quay always runs on podman on the target. This defaults to podman if installed, docker otherwise")
+	upgradeCmd.Flags().StringVarP(&targetArch, "arch", "", "", "The CPU architecture of the target, amd64, arm64, ppc64le or s390x, selecting the image archive image-archive-<arch>.tar next to the binary. This defaults to the architecture detected on the target")
+	upgradeCmd.Flags().StringVarP(&networkMode, "networkMode", "", "host", "The network mode of the ansible-runner container (host, slirp4netns or bridge). This defaults to host")
+	upgradeCmd.Flags().IntVarP(&ansibleTimeout, "ansibleTimeout", "", 10, "Seconds ansible waits for SSH connections and privilege escalation prompts. For high-latency links (e.g. satellite) 60 is recommended. This defaults to 10")
+	upgradeCmd.Flags().IntVarP(&sshConnectTimeout, "sshConnectTimeout", "", 0, "Seconds SSH waits to establish a connection. For high-latency links 30 is recommended. This defaults to --ansibleTimeout")
+	upgradeCmd.Flags().StringVarP(&sshControlPersist, "sshControlPersist", "", "60s", "How long idle SSH control connections are kept open (e.g. 60s, 10m). For high-latency links 10m is recommended to avoid reconnecting. This defaults to 60s")
+	upgradeCmd.Flags().IntVarP(&retries, "retries", "", 3, "How many times SSH connections to the target and loading the execution environment are retried after a transient failure, e.g. an unreachable host or a DNS failure. This defaults to 3")
+	upgradeCmd.Flags().IntVarP(&retryDelay, "retryDelay", "", 5, "Seconds before the first retry, doubled for every further one. This defaults to 5")
+	upgradeCmd.Flags().StringVarP(&podNetworkMode, "podNetworkMode", "", "", "The network mode of the Quay pod on the target (host, slirp4netns or bridge). In host mode Quay listens on port 8443 directly. This defaults to the network mode of the existing Quay pod")
+	upgradeCmd.Flags().BoolVarP(&usePodmanSecrets, "usePodmanSecrets", "", false, "Pass the Postgres and Redis passwords to the containers as podman secrets instead of environment variables. Requires podman 3.1 on the target, older versions fall back to environment variables. This defaults to the way the existing install passes them.")
+	upgradeCmd.Flags().StringVarP(&upgradeLogLevel, "quayLogLevel", "", "", "Change the log level of the Quay application (DEBUG, INFO or WARNING). This defaults to keeping the current level")
 	upgradeCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
 	upgradeCmd.Flags().StringVarP(&quayStorage, "quayStorage", "", "quay-storage", "The folder where quay persistent storage data is saved. This defaults to a Podman named volume 'quay-storage'. Root is required to uninstall.")
 	upgradeCmd.Flags().StringVarP(&pgStorage, "pgStorage", "", "pg-storage", "The folder where postgres persistent storage data is saved. This defaults to a Podman named volume 'pg-storage'. Root is required to uninstall.")
+	upgradeCmd.Flags().BoolVarP(&skipDBBackup, "skipDBBackup", "", false, "Skip the database backup taken on the target before upgrading.")
+	upgradeCmd.Flags().StringVarP(&quayImage, "quayImage", "", quayImage, "The Quay image to upgrade to, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	upgradeCmd.Flags().StringVarP(&redisImage, "redisImage", "", redisImage, "The Redis image to upgrade to, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	upgradeCmd.Flags().StringVarP(&postgresImage, "postgresImage", "", postgresImage, "The Postgres image to upgrade to, e.g. a hotfix build or a copy in an internal registry. The bundled image archive is not used for it. This defaults to the image of this release")
+	upgradeCmd.Flags().StringVarP(&clairImage, "clairImage", "", clairImage, "The Clair image to upgrade to, if Clair was installed with --with-clair")
+	upgradeCmd.Flags().StringVarP(&postgresExporterImage, "postgresExporterImage", "", "quay.io/prometheuscommunity/postgres-exporter:latest", "The Postgres exporter image to upgrade to, if installed with --with-monitoring. This defaults to quay.io/prometheuscommunity/postgres-exporter:latest")
+	upgradeCmd.Flags().StringVarP(&redisExporterImage, "redisExporterImage", "", "docker.io/oliver006/redis_exporter:latest", "The Redis exporter image to upgrade to, if installed with --with-monitoring. This defaults to docker.io/oliver006/redis_exporter:latest")
+	upgradeCmd.Flags().StringVarP(&prometheusImage, "prometheusImage", "", "quay.io/prometheus/prometheus:latest", "The Prometheus image to upgrade to, if installed with --monitoringStack. This defaults to quay.io/prometheus/prometheus:latest")
+	upgradeCmd.Flags().StringVarP(&grafanaImage, "grafanaImage", "", "docker.io/grafana/grafana:latest", "The Grafana image to upgrade to, if installed with --monitoringStack. This defaults to docker.io/grafana/grafana:latest")
+	upgradeCmd.Flags().BoolVarP(&forceUnlock, "force-unlock", "", false, "Remove the locks a previous run left on the control host and the target, e.g. after it was killed. Only use it when no other run is active.")
+	upgradeCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Print the checks and the playbook command that would run, without loading the execution environment or connecting to the target.")
 	upgradeCmd.Flags().StringVarP(&additionalArgs, "additionalArgs", "", "", "Additional arguments you would like to append to the ansible-playbook call. Used mostly for development.")
+	upgradeCmd.Flags().StringVarP(&logFile, "logfile", "", "", "The file the full playbook output is saved to, kept for troubleshooting. This defaults to ~/.mirror-registry/logs/<timestamp>.log")
+	upgradeCmd.Flags().DurationVarP(&playbookTimeout, "timeout", "", 0, "How long the playbook may run, e.g. 90m. A playbook still running then is killed and the task it hung in is reported. This defaults to no limit")
 
 }
 
-func upgrade() {
+func upgrade(flags *pflag.FlagSet) {
 
 	var err error
 	log.Printf("Upgrade has begun")
@@ -52,28 +91,136 @@ func upgrade() {
 	log.Debug("Quay Image: " + quayImage)
 	log.Debug("Redis Image: " + redisImage)
 	log.Debug("Postgres Image: " + postgresImage)
+	log.Debug("Clair Image: " + clairImage)
 
-	// Load execution environment
-	err = loadExecutionEnvironment()
+	err = checkContainerizedExecution()
+	check(err)
+
+	err = validateNetworkModes()
+	check(err)
+
+	overriddenImages, err := validateImageOverrides(flags)
+	check(err)
+
+	err = loadContainerRuntime()
+	check(withExitCode(exitPodman, err))
+
+	err = validateAnsibleConnection()
 	check(err)
 
+	// Only override the log level of the existing install when requested
+	var logLevelVars string
+	if upgradeLogLevel != "" {
+		level, err := validateLogLevel(upgradeLogLevel)
+		check(err)
+		logLevelVars = fmt.Sprintf(" quay_log_level=%s quay_log_level_override=true", level)
+	}
+
+	// Load execution environment
+	if !skipForDryRun("load the execution environment " + eeImage) {
+		err = loadExecutionEnvironment()
+		check(withExitCode(exitPodman, err))
+	}
+
 	// Set quayHostname if not already set
 	if quayHostname == "" {
-		quayHostname = targetHostname + ":8443"
+		quayHostname = withPort(targetHostname, "8443")
 	}
 
 	// Check that SSH key is present, and generate if not
-	err = loadSSHKeys()
+	if !skipForDryRun("check the SSH key " + sshKey + " and generate it for local installs if missing") {
+		err = loadSSHKeys()
+		check(withExitCode(exitSSH, err))
+	}
+
+	// Keep other runs off the control host and the target until this one is done
+	if !skipForDryRun("lock the control host and " + targetHostname) {
+		release, err := acquireLock("upgrade")
+		check(err)
+		defer release()
+	}
+
+	// The images have to match the architecture of the target
+	err = detectTargetArch()
 	check(err)
 
+	// Compare the deployed images with the ones this installer ships
+	deployed := map[string]string{"quay-app": "unknown", "quay-postgres": "unknown", "quay-redis": "unknown"}
+	if !skipForDryRun("compare the images deployed on " + targetHostname + " with " + quayImage + ", " + postgresImage + " and " + redisImage) {
+		deployed, err = deployedImages()
+		check(err)
+		for _, container := range []string{"quay-app", "quay-postgres", "quay-redis"} {
+			target := map[string]string{"quay-app": quayImage, "quay-postgres": postgresImage, "quay-redis": redisImage}[container]
+			if deployed[container] == "" && container != "quay-app" && deployed["quay-app"] != "" {
+				log.Infof("No %s container found, Quay uses an external server", container)
+				continue
+			}
+			if deployed[container] == "" {
+				check(errors.New("Could not find a deployed " + container + " container on " + targetHostname + ", is Quay installed?"))
+			}
+			if deployed[container] == target {
+				log.Infof("%s is already at %s", container, target)
+			} else {
+				log.Infof("%s will be upgraded from %s to %s", container, deployed[container], target)
+			}
+		}
+	}
+
+	// Report components whose image changed since the install or the last upgrade recorded it
+	state, err := readInstallState(targetHostname)
+	check(err)
+	if state == nil {
+		state = &installState{host: targetHostname}
+	}
+	if len(state.Images) > 0 && !skipForDryRun("compare the images running on "+targetHostname+" with the recorded ones") {
+		err = warnImageDrift(state.Images)
+		check(err)
+	}
+
+	// Back up the database on the target so a failed upgrade can be recovered
+	if deployed["quay-postgres"] == "" {
+		log.Warn("Skipping the database backup, back up the external database before upgrading")
+	} else if !skipDBBackup && !skipForDryRun("back up the Quay database to "+quayRoot+"/backups on "+targetHostname) {
+		backup := fmt.Sprintf("%s/backups/pre-upgrade-%s.sql.gz", quayRoot, time.Now().Format("20060102-150405"))
+		log.Printf("Backing up the Quay database to %s on %s", backup, targetHostname)
+		_, err = runRemoteCommand(fmt.Sprintf(`set -eo pipefail
+mkdir -p "$(dirname %[1]s)"
+podman exec quay-postgres pg_dump -U postgres quay | gzip > %[1]s
+chmod 600 %[1]s
+`, backup))
+		if err != nil {
+			check(errors.New("Database backup failed, rerun with --skipDBBackup to upgrade without a backup: " + err.Error()))
+		}
+	}
+
+	// Fall back to environment variables if the target cannot use podman secrets
+	if usePodmanSecrets && !skipForDryRun("check that podman on "+targetHostname+" supports secrets") {
+		runtime, err := gatherRuntimeFacts()
+		check(err)
+		checkPodmanSecretsSupport(runtime)
+	}
+
+	// Keep the network mode of the existing Quay pod unless requested otherwise
+	var podNetworkVars string
+	if flags.Changed("podNetworkMode") {
+		podNetworkVars = " pod_network_mode=" + podNetworkMode
+	}
+
+	// Keep passing the passwords the way the existing install does unless requested otherwise
+	var podmanSecretsVars string
+	if flags.Changed("usePodmanSecrets") {
+		podmanSecretsVars = fmt.Sprintf(" use_podman_secrets=%t use_podman_secrets_override=true", usePodmanSecrets)
+	}
+
 	// Handle Image Archive Defaulting
 	var imageArchiveMountFlag string
 	if imageArchivePath == "" {
-		executableDir, err := os.Executable()
+		defaultArchivePath, err := defaultImageArchive()
 		check(err)
-		defaultArchivePath := path.Join(path.Dir(executableDir), "image-archive.tar")
-		if pathExists(defaultArchivePath) {
+		if defaultArchivePath != "" {
 			imageArchivePath = defaultArchivePath
+			// The bundled archive holds the images of this release, overridden images are pulled instead
+			archiveSkipImages = overriddenImages
 		}
 	} else {
 		if !pathExists(imageArchivePath) {
@@ -81,10 +228,19 @@ func upgrade() {
 		}
 	}
 
+	if verifySignatures && !skipForDryRun("verify the signatures of the images with cosign") {
+		err = verifyImageSignatures()
+		check(err)
+	}
+
 	if imageArchivePath != "" {
-		imageArchiveMountFlag = fmt.Sprintf("-v %s:/runner/image-archive.tar", imageArchivePath)
+		imageArchiveMountFlag = fmt.Sprintf("-v %s:/runner/image-archive.tar", hostMountPath(imageArchivePath))
 		log.Info("Found image archive at " + imageArchivePath)
-		if isLocalInstall() {
+		if !skipForDryRun("verify the checksum of " + imageArchivePath) {
+			err = verifyArchive(imageArchivePath, imageArchiveDigest)
+			check(err)
+		}
+		if isLocalInstall() && !skipForDryRun("unpack "+imageArchivePath+" and load the images into local podman storage") {
 			log.Printf("Unpacking image archive from %s", imageArchivePath)
 			cmd := exec.Command("tar", "-xvf", imageArchivePath)
 			if verbose {
@@ -105,46 +261,52 @@ func upgrade() {
 			}
 			log.Debug("Importing Pause with command: ", pauseImport)
 			err = pauseImport.Run()
-			check(err)
+			check(withExitCode(exitPodman, err))
 
 			// Load Redis image
-			redisArchivePath := path.Join(path.Dir(executableDir), "redis.tar")
-			log.Printf("Loading redis image archive from %s", redisArchivePath)
-			statement = getImageMetadata("redis", redisImage, redisArchivePath)
-			redisImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				redisImport.Stderr = os.Stderr
-				redisImport.Stdout = os.Stdout
+			if loadsFromArchive("redis") {
+				redisArchivePath := path.Join(path.Dir(executableDir), "redis.tar")
+				log.Printf("Loading redis image archive from %s", redisArchivePath)
+				statement = getImageMetadata("redis", redisImage, redisArchivePath)
+				redisImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					redisImport.Stderr = os.Stderr
+					redisImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Redis with command: ", redisImport)
+				err = redisImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Redis with command: ", redisImport)
-			err = redisImport.Run()
-			check(err)
 
 			// Load Postgres image
-			postgresArchivePath := path.Join(path.Dir(executableDir), "postgres.tar")
-			log.Printf("Loading postgres image archive from %s", postgresArchivePath)
-			statement = getImageMetadata("postgres", postgresImage, postgresArchivePath)
-			postgresImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				postgresImport.Stderr = os.Stderr
-				postgresImport.Stdout = os.Stdout
+			if loadsFromArchive("postgres") {
+				postgresArchivePath := path.Join(path.Dir(executableDir), "postgres.tar")
+				log.Printf("Loading postgres image archive from %s", postgresArchivePath)
+				statement = getImageMetadata("postgres", postgresImage, postgresArchivePath)
+				postgresImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					postgresImport.Stderr = os.Stderr
+					postgresImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Postgres with command: ", postgresImport)
+				err = postgresImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Postgres with command: ", postgresImport)
-			err = postgresImport.Run()
-			check(err)
 
 			// Load Quay image
-			quayArchivePath := path.Join(path.Dir(executableDir), "quay.tar")
-			log.Printf("Loading Quay image archive from %s", quayArchivePath)
-			statement = getImageMetadata("quay", quayImage, quayArchivePath)
-			quayImport := exec.Command("/bin/bash", "-c", statement)
-			if verbose {
-				quayImport.Stderr = os.Stderr
-				quayImport.Stdout = os.Stdout
+			if loadsFromArchive("quay") {
+				quayArchivePath := path.Join(path.Dir(executableDir), "quay.tar")
+				log.Printf("Loading Quay image archive from %s", quayArchivePath)
+				statement = getImageMetadata("quay", quayImage, quayArchivePath)
+				quayImport := exec.Command("/bin/bash", "-c", statement)
+				if verbose {
+					quayImport.Stderr = os.Stderr
+					quayImport.Stdout = os.Stdout
+				}
+				log.Debug("Importing Quay with command: ", quayImport)
+				err = quayImport.Run()
+				check(withExitCode(exitPodman, err))
 			}
-			log.Debug("Importing Quay with command: ", quayImport)
-			err = quayImport.Run()
-			check(err)
 		}
 		log.Infof("Attempting to set SELinux rules on image archive")
 		cmd := exec.Command("chcon", "-Rt", "svirt_sandbox_file_t", imageArchivePath)
@@ -159,13 +321,11 @@ func upgrade() {
 
 	// Set quayHostname if not already set
 	if quayHostname == "" {
-		quayHostname = targetHostname + ":8443"
+		quayHostname = withPort(targetHostname, "8443")
 	}
 
 	// Add port if not present
-	if !strings.Contains(quayHostname, ":") {
-		quayHostname = quayHostname + ":8443"
-	}
+	quayHostname = withPort(quayHostname, "8443")
 
 	// Set askBecomePass flag if true
 	var askBecomePassFlag string
@@ -173,33 +333,75 @@ func upgrade() {
 		askBecomePassFlag = "-K"
 	}
 
+	// Mount the SSH key in a way the local podman can read it, or pass the SSH password
+	sshPodmanFlags, sshAnsibleFlags, cleanup, err := sshAuthFlags()
+	check(err)
+	defer cleanup()
+
 	// Run playbook
-	log.Printf("Running upgrade playbook. This may take some time. To see playbook output run the installer with -v (verbose) flag.")
+	log.Printf("Running upgrade playbook. This may take some time. To see the full playbook output run the installer with -v (verbose) flag.")
 	quayVersion := strings.Split(quayImage, ":")[1]
-	podmanCmd := fmt.Sprintf(`podman run `+
+	pinned := pinImages()
+	podmanCmd := fmt.Sprintf(eeRuntime.name()+` run `+
 		`--rm --interactive --tty `+
 		`--workdir /runner/project `+
-		`--net host `+
+		fmt.Sprintf("--net %s ", networkMode)+
 		imageArchiveMountFlag+ // optional image archive flag
-		` -v %s:/runner/env/ssh_key `+
+		` %s`+
 		`-e RUNNER_OMIT_EVENTS=False `+
 		`-e RUNNER_ONLY_FAILED_EVENTS=False `+
 		`-e ANSIBLE_HOST_KEY_CHECKING=False `+
 		`-e ANSIBLE_CONFIG=/runner/project/ansible.cfg `+
+		ansibleConnectionEnv()+
 		fmt.Sprintf("-e ANSIBLE_NOCOLOR=%t ", noColor)+
 		`--quiet `+
 		`--name ansible_runner_instance `+
 		fmt.Sprintf("%s ", eeImage)+
-		`ansible-playbook -i %s@%s, --private-key /runner/env/ssh_key -e "quay_image=%s quay_version=%s redis_image=%s postgres_image=%s pause_image=%s quay_hostname=%s local_install=%s quay_root=%s quay_storage=%s pg_storage=%s" upgrade_mirror_appliance.yml %s %s`,
-		sshKey, targetUsername, targetHostname, quayImage, quayVersion, redisImage, postgresImage, pauseImage, quayHostname, strconv.FormatBool(isLocalInstall()), quayRoot, quayStorage, pgStorage, askBecomePassFlag, additionalArgs)
+		`ansible-playbook -i %s@%s, %s -e "quay_image=%s quay_version=%s redis_image=%s postgres_image=%s pause_image=%s quay_hostname=%s local_install=%s quay_root=%s quay_storage=%s pg_storage=%s%s%s%s%s clair_image=%s%s" upgrade_mirror_appliance.yml %s %s`,
+		sshPodmanFlags, targetUsername, targetHostname, sshAnsibleFlags, quayImage, quayVersion, redisImage, postgresImage, pauseImage, quayHostname, strconv.FormatBool(isLocalInstall()), quayRoot, quayStorage, pgStorage, podNetworkVars, podmanSecretsVars, logLevelVars, imageOverrideVars(), clairImage, monitoringImageVars(), askBecomePassFlag, additionalArgs)
 
-	log.Debug("Running command: " + podmanCmd)
-	cmd := exec.Command("bash", "-c", podmanCmd)
+	playbookCmd := newPlaybookCommand(podmanCmd, nil)
+	if dryRun {
+		printDryRunCommand(playbookCmd)
+		return
+	}
+
+	log.Debug("Running command: " + playbookCmd.String())
+	cmd := playbookCmd.exec()
+	output, closeLog, err := openPlaybookLog(playbookOutput(), playbookCmd)
+	check(err)
+	defer closeLog()
 	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = output
 	cmd.Stdin = os.Stdin
-	err = cmd.Run()
-	check(err)
+	err = runPlaybook(cmd)
+	check(withExitCode(exitPlaybook, err))
 
-	log.Printf("Quay upgraded successfully")
+	// Record the upgraded images for status and the next upgrade
+	if err := recordImageIDs(pinned); err != nil {
+		log.Warnf("Could not read the image IDs of the containers on %s: %s", targetHostname, err.Error())
+	}
+	state.finish(pinned)
+	recordRegistry(currentRegistry(quayVersion), true)
+
+	log.Printf("Quay upgraded successfully, database migrations were applied when Quay started")
+}
+
+// deployedImages returns the image references of the running Quay containers on the target
+func deployedImages() (map[string]string, error) {
+	var script strings.Builder
+	for _, container := range []string{"quay-app", "quay-postgres", "quay-redis"} {
+		fmt.Fprintf(&script, "echo \"%s $(podman inspect --format '{{.ImageName}}' %s 2>/dev/null)\"\n", container, container)
+	}
+	out, err := runRemoteCommand(script.String())
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			images[fields[0]] = fields[1]
+		}
+	}
+	return images, nil
 }
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// extraUsers are the user:password accounts requested with --users
+var extraUsers []string
+
+// superUsers are the users granted superuser rights in addition to the init user
+var superUsers []string
+
+// quayUser is a parsed --users value
+type quayUser struct {
+	Name     string
+	Password string
+}
+
+// parseUsers validates the --users values
+func parseUsers(values []string) ([]quayUser, error) {
+	var users []quayUser
+	seen := map[string]bool{initUser: true}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid --users entry, expected user:password")
+		}
+		name, password := strings.TrimSpace(parts[0]), parts[1]
+		registerSecret(password)
+		if len(name) < 2 || !validNamespace.MatchString(name) {
+			return nil, errors.New("Invalid username " + name + " in --users")
+		}
+		if seen[name] {
+			return nil, errors.New("User " + name + " is given more than once in --users or is the init user")
+		}
+		seen[name] = true
+		if len(password) < 8 {
+			return nil, errors.New("The password of user " + name + " must be at least 8 characters long")
+		}
+		users = append(users, quayUser{Name: name, Password: password})
+	}
+	return users, nil
+}
+
+// applyUser creates the user if needed and sets its password, so re-runs converge on the requested credentials
+func applyUser(api *quayAPIClient, user quayUser) (string, error) {
+	status, err := api.do("GET", "/users/"+user.Name, nil, nil)
+	if status == http.StatusNotFound {
+		if _, err := api.do("POST", "/superuser/users/", map[string]string{"username": user.Name}, nil); err != nil {
+			return "", err
+		}
+		if err := setUserPassword(api, user.Name, user.Password); err != nil {
+			return "", err
+		}
+		return "created", nil
+	} else if err != nil {
+		return "", err
+	}
+	if err := setUserPassword(api, user.Name, user.Password); err != nil {
+		return "", err
+	}
+	return "password updated", nil
+}
+
+// superUserVars validates --superusers and returns its extra-var, or "" when only the init user is a superuser
+func superUserVars() (string, error) {
+	var names []string
+	for _, name := range superUsers {
+		name = strings.TrimSpace(name)
+		if !validNamespace.MatchString(name) {
+			return "", errors.New("Invalid username " + name + " in --superusers")
+		}
+		if name != initUser {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	return " super_users=" + strings.Join(names, ","), nil
+}
+
+// validateExternalUsers rejects the options that need local users when users are managed by LDAP or OIDC
+func validateExternalUsers() error {
+	switch {
+	case resetInitPassword:
+		return errors.New("--resetInitPassword cannot be used with --auth " + authMode + ", passwords are managed by the identity provider")
+	case len(extraUsers) > 0:
+		return errors.New("--users cannot be used with --auth " + authMode + ", users are managed by the identity provider")
+	case len(orgQuotas) > 0 || len(organizations) > 0 || len(repositories) > 0 || createAPIToken:
+		return errors.New("--orgQuota, --organization, --repository and --createApiToken use the access token of the init user, which is not created with --auth " + authMode)
+	case len(superUsers) == 0:
+		log.Warnf("No --superusers given, no %s user will be able to administer Quay", strings.ToUpper(authMode))
+	}
+	return nil
+}
@@ -6,42 +6,227 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
+// exitHooks are called with the error before the installer exits because of it
+var exitHooks []func(error)
+
+// eeArchivePath is the optional location of execution-environment.tar
+var eeArchivePath string
+
 func loadExecutionEnvironment() error {
 
-	// Ensure execution environment is present
-	executableDir, err := os.Executable()
-	if err != nil {
-		return err
+	// Ensure execution environment is present, preferring the one appended to the binary to a file next to it
+	executionEnvironmentPath := eeArchivePath
+	fromPayload := false
+	if executionEnvironmentPath == "" {
+		extracted, err := extractEEPayload()
+		if err != nil {
+			return err
+		}
+		executionEnvironmentPath = extracted
+		fromPayload = extracted != ""
+	}
+	if executionEnvironmentPath == "" {
+		executableDir, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		executionEnvironmentPath = path.Join(path.Dir(executableDir), "execution-environment.tar")
 	}
-	executionEnvironmentPath := path.Join(path.Dir(executableDir), "execution-environment.tar")
 	if !pathExists(executionEnvironmentPath) {
-		return errors.New("Could not find execution-environment.tar at " + executionEnvironmentPath)
+		return errors.New("Could not find execution-environment.tar at " + executionEnvironmentPath + ", pass its location with --eeArchive")
 	}
 	log.Info("Found execution environment at " + executionEnvironmentPath)
 
+	// The appended execution environment was already checked against its own checksum when it was extracted
+	if !fromPayload {
+		if err := verifyArchive(executionEnvironmentPath, eeArchiveDigest); err != nil {
+			return err
+		}
+	}
+
+	// Fail early with the path and sizes rather than midway through podman load
+	eeKiB, err := fileKiB(executionEnvironmentPath)
+	if err != nil {
+		return err
+	}
+	if graphRoot, err := eeRuntime.graphRoot(); err != nil {
+		log.Warnf("Skipping the disk space check: %s", err.Error())
+	} else if err := checkLocalDiskSpace([]diskNeed{{dir: graphRoot, what: "load the execution environment", kib: eeKiB + diskHeadroomKiB}}); err != nil {
+		return err
+	}
+
 	// Load execution environment into podman
 	log.Printf("Loading execution environment from execution-environment.tar")
 	statement := getImageMetadata("ansible", eeImage, executionEnvironmentPath)
-	cmd := exec.Command("/bin/bash", "-c", statement)
-	if verbose {
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
+	log.Debug("Importing execution enviornment with command: ", statement)
+
+	// A busy or briefly unavailable engine is retried, every attempt needs a fresh command
+	return retry("load the execution environment", anyFailure, func() error {
+		cmd := exec.Command("/bin/bash", "-c", statement)
+		if verbose {
+			cmd.Stderr = os.Stderr
+			cmd.Stdout = os.Stdout
+		}
+		return cmd.Run()
+	})
+}
+
+// isContainerized reports whether the installer itself runs inside a container
+func isContainerized() bool {
+	return pathExists("/run/.containerenv") || pathExists("/.dockerenv")
+}
+
+// checkContainerizedExecution rejects the combinations that cannot work when the installer runs inside a container
+func checkContainerizedExecution() error {
+	if !isContainerized() {
+		return nil
+	}
+	log.Info("Detected that the installer is running inside a container")
+	if isLocalInstall() {
+		return errors.New("Installing to the local host is not supported from inside a container, set --targetHostname and --targetUsername to the container host")
+	}
+	if !pathExists(sshKey) {
+		return errors.New("Could not find ssh key at " + sshKey + ". SSH keys are not generated inside a container, mount one and pass it with --ssh-key")
+	}
+	return nil
+}
+
+// hostMountPath translates a local path into the path podman must bind mount.
+// When podman is reached through a socket mounted into the installer container,
+// bind mount sources are resolved on the container host, so the path is mapped
+// through the mounts of the installer container.
+func hostMountPath(p string) string {
+	abs, err := filepath.Abs(p)
+	check(err)
+	if !isContainerized() || os.Getenv("CONTAINER_HOST") == "" {
+		return abs
 	}
-	log.Debug("Importing execution enviornment with command: ", cmd)
 
-	err = cmd.Run()
+	hostname, err := os.Hostname()
+	check(err)
+	out, err := exec.Command("podman", "container", "inspect", "--format", "{{range .Mounts}}{{.Destination}} {{.Source}}\n{{end}}", hostname).Output()
 	if err != nil {
-		return err
+		check(errors.New("Could not inspect the installer container " + hostname + " through the podman socket to translate mount paths: " + err.Error()))
+	}
+
+	// Use the most specific mount containing the path
+	var source, destination string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if (abs == fields[0] || strings.HasPrefix(abs, strings.TrimSuffix(fields[0], "/")+"/")) && len(fields[0]) > len(destination) {
+			destination, source = fields[0], fields[1]
+		}
 	}
+	if destination == "" {
+		check(errors.New(abs + " is not on a volume shared with the container host, mount it into the installer container"))
+	}
+	translated := path.Join(source, strings.TrimPrefix(abs, destination))
+	log.Debugf("Translated mount path %s to host path %s", abs, translated)
+	return translated
+}
+
+// validateNetworkModes checks the runner and pod network modes
+func validateNetworkModes() error {
+	switch networkMode {
+	case "host", "slirp4netns", "bridge":
+	default:
+		return errors.New("Invalid --networkMode " + networkMode + ", must be one of host, slirp4netns or bridge")
+	}
+	if networkMode != "host" && (targetHostname == "localhost" || strings.HasPrefix(targetHostname, "127.") || hostOnly(targetHostname) == "::1") {
+		return errors.New("--networkMode " + networkMode + " cannot reach " + targetHostname + " from inside the ansible-runner container, use the host FQDN instead")
+	}
+
+	switch podNetworkMode {
+	case "", "slirp4netns", "bridge":
+	case "host":
+		if port := portOf(quayHostname); port != "" && port != "8443" {
+			return errors.New("--podNetworkMode host publishes Quay on port 8443 only, remove the custom port from --quayHostname")
+		}
+	default:
+		return errors.New("Invalid --podNetworkMode " + podNetworkMode + ", must be one of host, slirp4netns or bridge")
+	}
+	log.Debugf("Using network mode %q for ansible-runner and %q for the Quay pod", networkMode, podNetworkMode)
 	return nil
 }
 
+// isRootlessPodman reports whether the local podman runs rootless
+func isRootlessPodman() bool {
+	out, err := exec.Command("podman", "info", "--format", "{{.Host.Security.Rootless}}").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// sshKeyMount returns the volume mounting the SSH key into the execution environment and a function removing temporary files.
+// Rootless podman can only read the key if it is relabeled, so a private copy is mounted instead of relabeling ~/.ssh.
+func sshKeyMount() (string, func(), error) {
+	if !eeRuntime.rootless() {
+		return hostMountPath(sshKey) + ":/runner/env/ssh_key", func() {}, nil
+	}
+	log.Infof("Running the execution environment with rootless %s", eeRuntime.name())
+
+	key, err := ioutil.ReadFile(sshKey)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir("", "mirror-registry-ssh")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	// Never leave a copy of the key behind, even when the command fails
+	exitHooks = append(exitHooks, func(error) { cleanup() })
+	if err := ioutil.WriteFile(path.Join(dir, "ssh_key"), key, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return hostMountPath(path.Join(dir, "ssh_key")) + ":/runner/env/ssh_key:Z", cleanup, nil
+}
+
+// validControlPersist matches the values accepted by the SSH ControlPersist option
+var validControlPersist = regexp.MustCompile(`^([0-9]+[smhdw]?|yes|no)$`)
+
+// validateAnsibleConnection checks the ansible timeout and SSH connection settings
+func validateAnsibleConnection() error {
+	if ansibleTimeout < 1 {
+		return errors.New("--ansibleTimeout must be at least 1 second")
+	}
+	if sshConnectTimeout < 0 {
+		return errors.New("--sshConnectTimeout must not be negative")
+	}
+	if retries < 0 || retryDelay < 0 {
+		return errors.New("--retries and --retryDelay must not be negative")
+	}
+	if sshPort < 1 || sshPort > 65535 {
+		return errors.New("--ssh-port must be between 1 and 65535")
+	}
+	if !validControlPersist.MatchString(sshControlPersist) {
+		return errors.New("Invalid --sshControlPersist " + sshControlPersist + ", must be a duration such as 60s or 10m, yes or no")
+	}
+	return nil
+}
+
+// ansibleConnectionEnv returns the ansible-runner environment flags for the connection settings
+func ansibleConnectionEnv() string {
+	sshArgs := "-C -o ControlMaster=auto -o ControlPersist=" + sshControlPersist
+	if sshConnectTimeout > 0 {
+		sshArgs += fmt.Sprintf(" -o ConnectTimeout=%d", sshConnectTimeout)
+	}
+	// ansible retries connections that fail with exit status 255 with its own backoff
+	return fmt.Sprintf("-e ANSIBLE_TIMEOUT=%d -e ANSIBLE_REMOTE_PORT=%d -e ANSIBLE_SSH_ARGS='%s' -e ANSIBLE_SSH_RETRIES=%d ", ansibleTimeout, sshPort, sshArgs, retries)
+}
+
 func isLocalInstall() bool {
 	if targetHostname == "localhost" || targetHostname == getFQDN() && targetUsername == os.Getenv("USER") {
 		log.Infof("Detected an installation to localhost")
@@ -51,6 +236,18 @@ func isLocalInstall() bool {
 }
 
 func loadSSHKeys() error {
+	if sshPasswordAuth {
+		return loadSSHPassword()
+	}
+	if sshKey == sshAgentKey {
+		return loadSSHAgent()
+	}
+	// Fall back to the agent when no key file exists for a remote install
+	if sshKey == os.Getenv("HOME")+"/.ssh/quay_installer" && !pathExists(sshKey) && os.Getenv("SSH_AUTH_SOCK") != "" && !isLocalInstall() {
+		log.Info("Did not find SSH key in default location, using the SSH agent")
+		sshKey = sshAgentKey
+		return loadSSHAgent()
+	}
 	if sshKey == os.Getenv("HOME")+"/.ssh/quay_installer" && isLocalInstall() {
 		if pathExists(sshKey) {
 			log.Info("Found SSH key at " + sshKey)
@@ -106,38 +303,23 @@ func setupLocalSSH() error {
 }
 
 func loadCerts(certFile, keyFile, hostname string, skipCheck bool) error {
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("--sslCert and --sslKey must be given together")
+	}
 	if certFile != "" && keyFile != "" {
 		log.Info("Loading SSL certificate file " + certFile)
 		log.Info("Loading SSL key file " + keyFile)
-		if !skipCheck {
-			certKey, err := tls.LoadX509KeyPair(certFile, keyFile)
-			if err != nil {
-				log.Errorf("Failed loading certificate and key file: %s", err.Error())
-				return err
-			}
-
-			cert, err := x509.ParseCertificate(certKey.Certificate[0])
-			if err != nil {
-				log.Errorf("Failed parsing certificate file: %s", err.Error())
-				return err
-			}
-
-			roots := x509.NewCertPool()
-			// Allow self-signed certificate and do not check the issuer
-			roots.AddCert(cert)
-
-			opts := x509.VerifyOptions{
-				DNSName:   hostname,
-				Roots:     roots,
-				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
-			}
 
-			_, err = cert.Verify(opts)
-			if err != nil {
-				log.Errorf("Failed verifying certificate: %s", err.Error())
-				return err
-			}
-			log.Info("SSL certificate check succeeded")
+		certPEM, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return err
+		}
+		keyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		if err := checkCertificate(certPEM, keyPEM, certFile, hostname, skipCheck); err != nil {
+			return err
 		}
 
 		if pathExists(certFile) {
@@ -156,6 +338,46 @@ func loadCerts(certFile, keyFile, hostname string, skipCheck bool) error {
 	return nil
 }
 
+// checkCertificate checks that a PEM certificate matches its key, has not expired and, unless skipCheck is set, covers
+// the hostname
+func checkCertificate(certPEM, keyPEM []byte, certFile, hostname string, skipCheck bool) error {
+	// The key must always match the certificate, otherwise Quay fails to start
+	certKey, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		log.Errorf("Failed loading certificate and key file: %s", err.Error())
+		return err
+	}
+
+	cert, err := x509.ParseCertificate(certKey.Certificate[0])
+	if err != nil {
+		log.Errorf("Failed parsing certificate file: %s", err.Error())
+		return err
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("SSL certificate %s expired on %s", certFile, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	if !skipCheck {
+		roots := x509.NewCertPool()
+		// Allow self-signed certificate and do not check the issuer
+		roots.AddCert(cert)
+
+		opts := x509.VerifyOptions{
+			DNSName:   hostname,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+
+		_, err = cert.Verify(opts)
+		if err != nil {
+			log.Errorf("Failed verifying certificate: %s", err.Error())
+			return fmt.Errorf("SSL certificate does not cover %s, the SAN of the certificate must include the quayHostname: %s", hostname, err.Error())
+		}
+		log.Info("SSL certificate check succeeded")
+	}
+	return nil
+}
+
 func setSELinux(path string) {
 	log.Infof("Attempting to set SELinux rules on " + path)
 	cmd := exec.Command("chcon", "-Rt", "svirt_sandbox_file_t", path)
@@ -168,6 +390,62 @@ func setSELinux(path string) {
 	}
 }
 
+// waitForQuayHealth polls the Quay instance health endpoint until it reports healthy or the attempts run out
+func waitForQuayHealth(hostname string, attempts int, delay time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = checkQuayHealth(hostname); err == nil {
+			return nil
+		}
+		log.Debugf("Quay is not healthy yet (%s), retrying in %s", err.Error(), delay)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// remotePreamble starts a remote script with SC set to the systemctl command, UNIT_DIR to the
+// systemd unit directory and CONFIG to the Quay config.yaml of the install on the target
+func remotePreamble() string {
+	return `set -e
+if [ "$(id -u)" = 0 ]; then SC="systemctl"; UNIT_DIR=/etc/systemd/system; else SC="systemctl --user"; UNIT_DIR=$HOME/.config/systemd/user; fi
+CONFIG=` + quayRoot + `/quay-config/config.yaml
+`
+}
+
+// remoteCommand prepares an SSH command running a shell script on the target host
+func remoteCommand(script string) *exec.Cmd {
+	cmd := sshCommand("ssh", targetUsername+"@"+hostOnly(targetHostname), "bash -s")
+	cmd.Stdin = strings.NewReader(script)
+	if verbose {
+		cmd.Stderr = os.Stderr
+	}
+	return cmd
+}
+
+// copyToRemote copies a local file to the target host over SSH
+func copyToRemote(local, remote string) error {
+	cmd := sshCommand("scp", local, targetUsername+"@"+scpHost()+":"+remote)
+	if verbose {
+		cmd.Stderr = os.Stderr
+	}
+	log.Debug("Copying file with command: ", cmd)
+	return sshFailure(cmd.Run())
+}
+
+// runRemoteCommand runs a shell script on the target host over SSH and returns its output. Connection failures, which
+// ssh reports with exit status 255, are retried.
+func runRemoteCommand(script string) (string, error) {
+	var out []byte
+	err := retry("connect to "+targetHostname, isSSHFailure, func() error {
+		cmd := remoteCommand(script)
+		log.Debug("Running remote command: ", cmd)
+		var err error
+		out, err = cmd.Output()
+		return sshFailure(err)
+	})
+	return string(out), err
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -176,35 +454,64 @@ func pathExists(path string) bool {
 func check(err error) {
 	if err != nil {
 		log.Errorf("An error occurred: %s", err.Error())
-		os.Exit(1)
+		for _, hook := range exitHooks {
+			hook(err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// checkQuayHealth queries the Quay instance health endpoint once
+func checkQuayHealth(hostname string) error {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			// The registry certificate is often self-signed and not trusted by the control host
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get("https://" + hostname + "/health/instance")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health endpoint returned %s", resp.Status)
 	}
+	return nil
 }
 
 // getImageMetadata provides the metadata needed for a corresponding image
 func getImageMetadata(app, imageName, archivePath string) string {
 	var statement string
 
+	// The execution environment runs on the engine of the control host, the images of Quay always on podman
+	importCommand := "/usr/bin/podman image import"
+	if app == "ansible" {
+		importCommand = eeRuntime.importCommand()
+	}
+
 	switch app {
 	case "pause":
-		statement = `/usr/bin/podman image import \
+		statement = importCommand + ` \
 					--change 'ENV PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin' \
 					--change 'ENV container=oci' \
 					--change 'ENTRYPOINT=["sleep"]' \
 					--change 'CMD=["infinity"]' \
 					- ` + imageName + ` < ` + archivePath
 	case "ansible":
-		statement = `/usr/bin/podman image import \
+		statement = importCommand + ` \
 					--change 'ENV PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin' \
 					--change 'ENV HOME=/home/runner' \
 					--change 'ENV container=oci' \
-					--change 'ENTRYPOINT=["entrypoint"]' \
-					--change 'WORKDIR=/runner' \
-					--change 'EXPOSE=6379' \
-					--change 'VOLUME=/runner' \
+					--change 'ENTRYPOINT ["entrypoint"]' \
+					--change 'WORKDIR /runner' \
+					--change 'EXPOSE 6379' \
+					--change 'VOLUME /runner' \
 					--change 'CMD ["ansible-runner", "run", "/runner"]' \
 					- ` + imageName + ` < ` + archivePath
 	case "redis":
-		statement = `/usr/bin/podman image import \
+		statement = importCommand + ` \
 					--change 'ENV PATH=/opt/app-root/src/bin:/opt/app-root/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin' \
 					--change 'ENV container=oci' \
 					--change 'ENV STI_SCRIPTS_URL=image:///usr/libexec/s2i' \
@@ -224,7 +531,7 @@ func getImageMetadata(app, imageName, archivePath string) string {
 					--change 'CMD ["run-redis"]' \
 					- ` + imageName + ` < ` + archivePath
 	case "postgres":
-		statement = `/usr/bin/podman image import \
+		statement = importCommand + ` \
 					--change 'ENV PATH=/opt/app-root/src/bin:/opt/app-root/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin' \
 					--change 'ENV STI_SCRIPTS_URL=image:///usr/libexec/s2i' \
 					--change 'ENV STI_SCRIPTS_PATH=/usr/libexec/s2i' \
@@ -244,7 +551,7 @@ func getImageMetadata(app, imageName, archivePath string) string {
 					- ` + imageName + ` < ` + archivePath
 	case "quay":
 		// quay.io
-		statement = `/usr/bin/podman image import \
+		statement = importCommand + ` \
 					--change 'ENV container=oci' \
 					--change 'ENV PATH=/app/bin/:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin' \
 					--change 'ENV PYTHONUNBUFFERED=1' \
@@ -276,25 +583,6 @@ func getImageMetadata(app, imageName, archivePath string) string {
 	return statement
 }
 
-// checkInput validates user input against available options
-func getApproval(question string) bool {
-	var response string
-	_, err := fmt.Scanln(&response)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	switch strings.ToLower(response) {
-	case "y":
-		return true
-	case "n":
-		return false
-	default:
-		fmt.Println("Invalid input.", question)
-		return getApproval(question)
-	}
-}
-
 func getFQDN() string {
 	fqdn, err := exec.Command("hostname", "-f").Output()
 	if err != nil {
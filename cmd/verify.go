@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// jsonOutput controls whether results are printed as JSON instead of human-readable text
+var jsonOutput bool
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the deployed mirror registry matches its last install or upgrade.",
+	Run: func(cmd *cobra.Command, args []string) {
+		verify()
+	},
+}
+
+// verifyCheck is a single expectation compared against the target
+type verifyCheck struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	OK       bool   `json:"ok"`
+}
+
+// verifyResult is the drift report for a target
+type verifyResult struct {
+	Host   string        `json:"host"`
+	Drift  bool          `json:"drift"`
+	Checks []verifyCheck `json:"checks"`
+}
+
+func init() {
+
+	// Add verify command
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVarP(&targetHostname, "targetHostname", "H", getFQDN(), "The hostname of the target Quay is installed on. This defaults to $HOST")
+	verifyCmd.Flags().StringVarP(&targetUsername, "targetUsername", "u", os.Getenv("USER"), "The user on the target host which will be used for SSH. This defaults to $USER")
+	verifyCmd.Flags().StringVarP(&sshKey, "ssh-key", "k", os.Getenv("HOME")+"/.ssh/quay_installer", "The path of your ssh identity key, or agent to use the identities of the running SSH agent. This defaults to ~/.ssh/quay_installer")
+	verifyCmd.Flags().IntVarP(&sshPort, "ssh-port", "", 22, "The port of the SSH server on the target host. This defaults to 22")
+	verifyCmd.Flags().BoolVarP(&sshPasswordAuth, "ssh-password", "", false, "Authenticate to the target with a password instead of an SSH key. The password is read from $MIRROR_REGISTRY_SSH_PASSWORD or prompted for, and requires sshpass on the control host.")
+	verifyCmd.Flags().StringVarP(&quayRoot, "quayRoot", "r", "~/quay-install", "The folder where quay persistent data are saved. This defaults to ~/quay-install")
+	verifyCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the drift report as JSON")
+}
+
+func verify() {
+
+	err := loadSSHKeys()
+	check(err)
+
+	// The images recorded by the last install or upgrade from this control host are the expected ones
+	state, err := readInstallState(targetHostname)
+	check(err)
+	recorded := map[string]deployedImage{}
+	if state != nil {
+		recorded = state.Images
+	}
+	if len(recorded) == 0 {
+		log.Warnf("No images are recorded for %s on this control host, skipping the image checks. Install or upgrade from this control host to record them", targetHostname)
+	}
+
+	// Gather unit states, container images and the config checksum in a single SSH session
+	var script strings.Builder
+	script.WriteString(remotePreamble() + "set +e\n")
+	for _, unit := range statusServices {
+		fmt.Fprintf(&script, "if [ -f \"$UNIT_DIR/%[1]s.service\" ]; then echo \"unit %[1]s $($SC is-enabled %[1]s.service 2>/dev/null) $($SC is-active %[1]s.service 2>/dev/null)\"; else echo \"unit %[1]s absent\"; fi\n", unit)
+	}
+	for _, container := range componentContainers {
+		fmt.Fprintf(&script, "echo \"container %s $(podman inspect --format '{{.Image}}' %s 2>/dev/null)\"\n", container, container)
+	}
+	script.WriteString(`echo "config $(sha256sum "$CONFIG" 2>/dev/null | cut -d' ' -f1) $(cat "$CONFIG.sha256" 2>/dev/null)"` + "\n")
+
+	log.Infof("Gathering deployment state from %s", targetHostname)
+	out, err := runRemoteCommand(script.String())
+	check(err)
+
+	facts := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		facts[fields[0]+" "+fields[1]] = fields[2:]
+	}
+	field := func(key string, i int) string {
+		if len(facts[key]) > i {
+			return facts[key][i]
+		}
+		return "missing"
+	}
+
+	result := verifyResult{Host: targetHostname}
+	add := func(name, expected, actual string) {
+		ok := expected == actual
+		result.Drift = result.Drift || !ok
+		result.Checks = append(result.Checks, verifyCheck{Name: name, Expected: expected, Actual: actual, OK: ok})
+	}
+	for _, unit := range statusServices {
+		if field("unit "+unit, 0) == "absent" {
+			// Postgres and Redis are absent when Quay uses external servers, and the optional services when not installed
+			if unit == "quay-postgres" || unit == "quay-redis" || optionalServices[unit] {
+				continue
+			}
+		}
+		add(unit+".service enabled", "enabled", field("unit "+unit, 0))
+		add(unit+".service active", "active", field("unit "+unit, 1))
+	}
+	for _, name := range []string{"quay", "redis", "postgres", "clair"} {
+		image, ok := recorded[name]
+		if !ok || image.ID == "" {
+			continue
+		}
+		container := componentContainers[name]
+		add(container+" image", image.Reference+"@"+shortID(image.ID), image.Reference+"@"+shortID(field("container "+container, 0)))
+	}
+	add("config.yaml checksum", field("config", 1), field("config", 0))
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(result, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+	} else {
+		for _, c := range result.Checks {
+			status := "OK"
+			if !c.OK {
+				status = "DRIFT"
+			}
+			fmt.Printf("%-6s %-30s expected=%s actual=%s\n", status, c.Name, c.Expected, c.Actual)
+		}
+	}
+
+	if result.Drift {
+		log.Errorf("Deployment on %s has drifted from its last install or upgrade", targetHostname)
+		os.Exit(1)
+	}
+	log.Infof("Deployment on %s matches its last install or upgrade", targetHostname)
+}
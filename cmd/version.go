@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version, build metadata and bundled image references.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Skip the banner so the output can be recorded as is
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		printVersion()
+	},
+}
+
+// versionImage is an image reference compiled into the binary
+type versionImage struct {
+	Name      string `json:"name"`
+	Reference string `json:"reference"`
+	Digest    string `json:"digest,omitempty"`
+}
+
+// versionInfo is the build metadata printed by the version command
+type versionInfo struct {
+	Version   string         `json:"version"`
+	GitCommit string         `json:"gitCommit"`
+	BuildDate string         `json:"buildDate"`
+	Images    []versionImage `json:"images"`
+}
+
+func init() {
+
+	// Add version command
+	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Print the build metadata as JSON")
+}
+
+func printVersion() {
+
+	info := versionInfo{
+		Version:   orUnknown(releaseVersion),
+		GitCommit: orUnknown(gitCommit),
+		BuildDate: orUnknown(buildDate),
+	}
+	for _, image := range []struct{ name, reference string }{
+		{"quay", quayImage},
+		{"redis", redisImage},
+		{"postgres", postgresImage},
+		{"pause", pauseImage},
+		{"clair", clairImage},
+		{"execution-environment", eeImage},
+	} {
+		info.Images = append(info.Images, versionImage{Name: image.name, Reference: orUnknown(image.reference), Digest: imageDigest(image.reference)})
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(info, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("Version:    %s\n", info.Version)
+	fmt.Printf("Git commit: %s\n", info.GitCommit)
+	fmt.Printf("Build date: %s\n", info.BuildDate)
+	fmt.Println("Images:")
+	for _, image := range info.Images {
+		digest := image.Digest
+		if digest == "" {
+			digest = "digest unknown"
+		}
+		fmt.Printf("  %-22s %s (%s)\n", image.Name+":", image.Reference, digest)
+	}
+}
+
+// imageDigest returns the digest pinned in an image reference, or the digest of the image in local podman storage
+func imageDigest(reference string) string {
+	if reference == "" {
+		return ""
+	}
+	if parts := strings.SplitN(reference, "@", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	out, err := exec.Command("podman", "image", "inspect", "--format", "{{.Digest}}", reference).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// orUnknown substitutes "unknown" for build metadata that was not set via ldflags
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
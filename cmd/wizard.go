@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// interactiveInstall holds whether or not to prompt for the main install options instead of reading them from flags
+var interactiveInstall bool
+
+// wizardAnswer is an option chosen in the wizard, shown in the plan and set as its flag
+type wizardAnswer struct {
+	flag   string
+	value  string
+	secret bool
+}
+
+// installWizard prompts for the install options on the terminal, validating every answer
+type installWizard struct {
+	in      *bufio.Reader
+	answers []wizardAnswer
+}
+
+// runInstallWizard walks through the target, SSH, storage, TLS and init user options, shows the resulting plan and
+// sets the answers as flags once it is confirmed. The current values of the flags and the config file are the defaults.
+func runInstallWizard(flags *pflag.FlagSet) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("--interactive needs a terminal, pass the options as flags instead")
+	}
+	if haMode {
+		return errors.New("--interactive cannot be used with --ha")
+	}
+	w := &installWizard{in: bufio.NewReader(os.Stdin)}
+	fmt.Println("This wizard asks for the options of the install. Press enter to keep the default shown in brackets.")
+
+	target := w.ask("targetHostname", "Target host Quay is installed on", targetHostname, func(v string) error {
+		if host := hostOnly(v); net.ParseIP(host) == nil && !validHostname.MatchString(host) {
+			return errors.New(v + " is not a valid hostname")
+		}
+		return nil
+	})
+	w.ask("targetUsername", "SSH user on the target", targetUsername, notEmpty)
+	auth := w.ask("ssh-key", "SSH key file, agent to use the SSH agent, or password", sshKey, func(v string) error {
+		if v == sshAgentKey && os.Getenv("SSH_AUTH_SOCK") == "" {
+			return errors.New("no SSH agent is running, $SSH_AUTH_SOCK is not set")
+		}
+		if v != sshAgentKey && v != "password" && !pathExists(v) && target != "localhost" {
+			return errors.New("no SSH key at " + v)
+		}
+		return nil
+	})
+	if auth == "password" {
+		w.answers[len(w.answers)-1] = wizardAnswer{flag: "ssh-password", value: "true"}
+	}
+	w.ask("ssh-port", "SSH port of the target", strconv.Itoa(sshPort), func(v string) error {
+		if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			return errors.New("the port must be a number between 1 and 65535")
+		}
+		return nil
+	})
+
+	defaultQuayHostname := quayHostname
+	if defaultQuayHostname == "" || !flags.Changed("quayHostname") {
+		defaultQuayHostname = withPort(target, "8443")
+	}
+	w.ask("quayHostname", "Hostname and port clients reach Quay at", defaultQuayHostname, func(v string) error {
+		previous := quayHostname
+		quayHostname = v
+		defer func() { quayHostname = previous }()
+		return validateQuayHostname()
+	})
+	w.ask("quayRoot", "Directory on the target for the Quay config", quayRoot, notEmpty)
+	w.ask("quayStorage", "Podman volume or absolute directory on the target for the images", quayStorage, func(v string) error {
+		if !strings.HasPrefix(v, "/") && !validNamespace.MatchString(v) {
+			return errors.New("use a volume name or an absolute path")
+		}
+		return nil
+	})
+
+	tls := "self-signed"
+	if sslCert != "" {
+		tls = "own"
+	}
+	tls = w.choose("TLS certificate, self-signed or own", tls, "self-signed", "own")
+	if tls == "own" {
+		w.ask("sslCert", "PEM certificate file", sslCert, fileExists)
+		w.ask("sslKey", "PEM key file", sslKey, fileExists)
+	}
+
+	w.ask("initUser", "Username of the init user", initUser, func(v string) error {
+		if !validNamespace.MatchString(v) {
+			return errors.New("use lowercase letters, digits, ., _ and - only")
+		}
+		return nil
+	})
+	if err := w.askPassword(); err != nil {
+		return err
+	}
+
+	// Show the plan and the equivalent command line for the next run
+	fmt.Println("\nThe install will run with:")
+	command := []string{"mirror-registry", "install"}
+	generated := true
+	for _, answer := range w.answers {
+		shown := answer.value
+		if answer.secret {
+			shown = "********"
+			generated = false
+		} else {
+			command = append(command, "--"+answer.flag+"="+shellQuote(answer.value))
+		}
+		fmt.Printf("  --%-16s %s\n", answer.flag, shown)
+	}
+	if tls == "self-signed" {
+		fmt.Printf("  %-18s %s\n", "TLS", "a self-signed certificate is generated")
+	}
+	if generated {
+		fmt.Printf("  %-18s %s\n", "init password", "generated and printed at the end of the install")
+	}
+	fmt.Printf("\nEquivalent command: %s\n", strings.Join(command, " "))
+	if w.choose("Proceed with the install", "no", "yes", "no") != "yes" {
+		return errors.New("Install cancelled")
+	}
+
+	for _, answer := range w.answers {
+		if err := flags.Set(answer.flag, answer.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ask prompts until the answer passes validate and records it for flag
+func (w *installWizard) ask(flag, label, value string, validate func(string) error) string {
+	for {
+		fmt.Printf("%s [%s]: ", label, value)
+		line, err := w.in.ReadString('\n')
+		if err != nil && line == "" {
+			check(errors.New("Install cancelled, the input ended"))
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = value
+		}
+		if err := validate(answer); err != nil {
+			fmt.Printf("  %s\n", err.Error())
+			continue
+		}
+		w.answers = append(w.answers, wizardAnswer{flag: flag, value: answer})
+		return answer
+	}
+}
+
+// choose prompts until one of the choices is given
+func (w *installWizard) choose(label, value string, choices ...string) string {
+	for {
+		fmt.Printf("%s? [%s]: ", label, value)
+		line, err := w.in.ReadString('\n')
+		if err != nil && line == "" {
+			check(errors.New("Install cancelled, the input ended"))
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer == "" {
+			answer = value
+		}
+		for _, choice := range choices {
+			if answer == choice || answer == choice[:1] {
+				return choice
+			}
+		}
+		fmt.Printf("  Answer %s\n", strings.Join(choices, " or "))
+	}
+}
+
+// askPassword prompts twice for the init password without echoing it, an empty password keeps it generated
+func (w *installWizard) askPassword() error {
+	for {
+		password, err := readSecret("Password of the init user, at least 8 characters, empty to generate one: ")
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return nil
+		}
+		if len(password) < 8 {
+			fmt.Println("  The password must have at least 8 characters")
+			continue
+		}
+		repeated, err := readSecret("Repeat the password: ")
+		if err != nil {
+			return err
+		}
+		if repeated != password {
+			fmt.Println("  The passwords do not match")
+			continue
+		}
+		registerSecret(password)
+		w.answers = append(w.answers, wizardAnswer{flag: "initPassword", value: password, secret: true})
+		return nil
+	}
+}
+
+// notEmpty rejects empty answers
+func notEmpty(v string) error {
+	if v == "" {
+		return errors.New("an answer is required")
+	}
+	return nil
+}
+
+// fileExists rejects paths that do not exist on the control host
+func fileExists(v string) error {
+	if v == "" || !pathExists(v) {
+		return errors.New("no file at " + v)
+	}
+	return nil
+}
+
+// shellQuote quotes a value for the equivalent command line when it holds characters the shell interprets
+func shellQuote(v string) string {
+	if strings.ContainsAny(v, " \t'\"$`\\*?;&|<>()") {
+		return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+	}
+	return v
+}